@@ -1,13 +1,14 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -62,11 +63,36 @@ type ResponseData struct {
 }
 
 type AnnsShoe struct {
-	SalePageId int      `json:"salePageId"`
-	Title      string   `json:"title"`
-	PicUrl     string   `json:"picUrl"`
-	PicList    []string `json:"picList"`
-	Price      int      `json:"price"`
+	SalePageId           int                  `json:"salePageId"`
+	Title                string               `json:"title"`
+	PicUrl               string               `json:"picUrl"`
+	PicList              []string             `json:"picList"`
+	Price                int                  `json:"price"`
+	SuggestPrice         int                  `json:"suggestPrice"`
+	PromotionPrices      []AnnsPromotionPrice `json:"promotionPrices"`
+	IsSoldOut            bool                 `json:"isSoldOut"`
+	IsComingSoon         bool                 `json:"isComingSoon"`
+	SellingStartDateTime string               `json:"sellingStartDateTime"`
+	ListingStartDateTime string               `json:"listingStartDateTime"`
+	SalePageGroup        *AnnsSalePageGroup   `json:"salePageGroup"`
+}
+
+type AnnsSalePageGroup struct {
+	GroupTitle string                  `json:"groupTitle"`
+	GroupItems []AnnsSalePageGroupItem `json:"groupItems"`
+}
+
+type AnnsSalePageGroupItem struct {
+	SalePageId int    `json:"salePageId"`
+	ItemTitle  string `json:"itemTitle"`
+	ItemUrl    string `json:"itemUrl"`
+}
+
+type AnnsPromotionPrice struct {
+	Price         float64 `json:"price"`
+	StartDateTime string  `json:"startDateTime"`
+	EndDateTime   string  `json:"endDateTime"`
+	Label         string  `json:"label"`
 }
 
 type SKUProperty struct {
@@ -102,7 +128,9 @@ type AnnsShoeDetail struct {
 	Id                   int           `json:"Id"`
 	ShopId               int           `json:"ShopId"`
 	Title                string        `json:"Title"`
+	SubTitle             string        `json:"SubTitle"`
 	SaleProductSKUIdList []int         `json:"SaleProductSKUIdList"`
+	SKUPropertySetList   []SKUProperty `json:"SKUPropertySetList"`
 	MajorList            []MajorList   `json:"MajorList"`
 	SalePageGroup        SalePageGroup `json:"SalePageGroup"`
 }
@@ -149,39 +177,91 @@ type SaleProductSKUIdDO struct {
 	SaleProductOuterId string `json:"SaleProductOuterId"`
 }
 
-const rootAPIURL = "https://fts-api.91app.com/pythia-cdn/graphql"
-const childAPIURL = "https://www.anns.tw/webapi/SalePageV2/GetSalePageV2Info/123/"
-const salepageURL = "https://www.anns.tw/SalePage/Index/"
-const sizeStockAPIURL = "https://www.anns.tw/webapi/ProductStock/GetSellingQtyListNew?v=0&shopId=123&lang=zh-TW"
+// annsCrawler Ann's 爬蟲，API 網址與並行數量來自設定
+type annsCrawler struct {
+	client *http.Client
+	config AnnsConfig
+	// config.Render 不是 off 時用來渲染商品頁
+	renderer *renderFetcher
+	// 列表上才有的全部圖片、原價、促銷資訊記在這裡，單一商品 API 會用到
+	catalog *productCatalog
+}
+
+// 商品頁的規格沒有尺寸，例如包包、配件
+var errAnnsNotShoe = errors.New("商品非鞋類")
 
 //const chromePath = "C:\\Program Files\\Google\\Chrome\\Application\\chrome.exe"
 
-func getAnnsFliterResponse(orderby, searchSize, searchColor, searchHeel, searchCat string) ([]Shoe, error) {
+func (anns *annsCrawler) getAnnsFliterResponse(ctx context.Context, params SearchParams) ([]Shoe, error) {
 
 	var shoes []Shoe
-	var resp *http.Response
-	var client *http.Client
-	startIndex := 0
-	totalSize := 0
+	seen := map[string]struct{}{}
 
 	// 記錄參數
-	log.Printf("func:getAnnsFliterResponse,Ann's篩選條件 - 排序規則: %s, 尺碼: %s, 顏色: %s, 跟高: %s, 款式: %s", orderby, searchSize, searchColor, searchHeel, searchCat)
+	annsLog.InfoContext(ctx, "Ann's 篩選條件", "orderby", params.OrderBy, "sizes", params.Sizes, "colors", params.Colors, "heels", params.Heels, "cats", params.Cats)
 
-	// 將 searchCat 轉換為整數
-	categoryId, err := strconv.Atoi(searchCat)
-	if err != nil {
-		log.Println("func:getAnnsFliterResponse,Ann's CategoryId 轉換錯誤:", err)
-		return shoes, err
+	if len(params.Cats) == 0 {
+		return shoes, fmt.Errorf("Ann's 至少要選擇一個款式")
 	}
-	// 構建請求的 Body
+
+	// 構建請求的 tagFilters，同一個 group 帶多個 key 時 Ann's 本身就是 OR
 	tagFilters := []TagFilter{}
-	if searchColor != "" {
+	for _, searchColor := range params.Colors {
 		tagFilters = append(tagFilters, TagFilter{GroupId: "G87", KeyId: searchColor})
 	}
-	if searchHeel != "" {
+	for _, searchHeel := range params.Heels {
 		tagFilters = append(tagFilters, TagFilter{GroupId: "G88", KeyId: searchHeel})
 	}
 
+	// 一次請求只能查一個分類，多個分類要分開請求再合併
+	reportProgress(ctx, "list", 0, len(params.Cats))
+	for catIndex, searchCat := range params.Cats {
+		// 將 searchCat 轉換為整數
+		categoryId, err := strconv.Atoi(searchCat)
+		if err != nil {
+			annsLog.ErrorContext(ctx, "Ann's CategoryId 轉換錯誤", "cat", searchCat, "error", err)
+			return shoes, err
+		}
+
+		categoryShoes, err := anns.getAnnsShoeList(ctx, params.OrderBy, categoryId, tagFilters)
+		if err != nil {
+			return shoes, err
+		}
+
+		// 同一雙鞋可能同時出現在多個分類
+		for _, shoe := range categoryShoes {
+			if _, exists := seen[shoe.ListID]; exists {
+				continue
+			}
+			seen[shoe.ListID] = struct{}{}
+			shoes = append(shoes, shoe)
+		}
+		reportProgress(ctx, "list", catIndex+1, len(params.Cats))
+	}
+
+	// 遍歷訪問shoes.URL，取得每個shoes的Size和Color，並去掉包包、配件
+	shoes = anns.getSizeAndColorByHttpRequset(ctx, shoes, params.Sizes)
+
+	// 列表顯示有貨但實際上所有尺寸都沒有庫存的，也視為售罄
+	for i := range shoes {
+		markSoldOutIfNoSize(&shoes[i])
+	}
+
+	// 篩選出有符合尺寸的鞋子
+	filteredShoes := filterShoesBySize(shoes, params.Sizes)
+	annsLog.InfoContext(ctx, "結束尺寸篩選", "shoes", len(filteredShoes))
+
+	return filteredShoes, nil
+}
+
+// 取得 Ann's 單一分類下符合篩選條件的所有鞋子(尚未取得尺寸與顏色)
+func (anns *annsCrawler) getAnnsShoeList(ctx context.Context, orderby string, categoryId int, tagFilters []TagFilter) ([]Shoe, error) {
+
+	var shoes []Shoe
+	var resp *http.Response
+	startIndex := 0
+	totalSize := 0
+
 	requestBody := RequestBody{
 		ShopId:        123,
 		Lang:          "zh-TW",
@@ -205,30 +285,17 @@ func getAnnsFliterResponse(orderby, searchSize, searchColor, searchHeel, searchC
 		},
 	}
 
-	log.Printf("開始請求，從編號%d開始", startIndex)
+	annsLog.DebugContext(ctx, "開始請求商品列表", "categoryId", categoryId, "startIndex", startIndex)
 	jsonData, err := json.Marshal(requestBody)
 	if err != nil {
-		log.Println("func:getAnnsFliterResponse,Ann's JSON 編碼錯誤:", err)
+		annsLog.ErrorContext(ctx, "Ann's JSON 編碼錯誤", "error", err)
 		return shoes, err
 	}
 
-	if enviroment == "release" {
-		// 正式環境，要設定自訂的帶有 CA 憑證的 HTTP 客戶端
-		client, err = createHTTPClientWithCACert("/etc/ssl/certs/ca-certificates.crt")
-		if err != nil {
-			log.Println("func:getAnnsFliterResponse,Ann's 無法創建 HTTP 客戶端:", err)
-			return shoes, err
-		}
-
-	} else {
-		// 本地端，不用設定 CA 憑證
-		client = &http.Client{}
-	}
-
-	// 帶有 CA 憑證的 HTTP 客戶端向 Ann's 打 Fliter HTTP POST 請求
-	resp, err = client.Post(rootAPIURL, "application/json", bytes.NewBuffer(jsonData))
+	// 向 Ann's 打 Fliter HTTP POST 請求
+	resp, err = postJSONWithContext(ctx, anns.client, anns.config.GraphQLURL, jsonData)
 	if err != nil {
-		log.Println("func:getAnnsFliterResponse,Ann's 商品列表初始請求錯誤:", err)
+		annsLog.ErrorContext(ctx, "Ann's 商品列表初始請求錯誤", "categoryId", categoryId, "error", err)
 		return shoes, err
 	}
 	defer resp.Body.Close()
@@ -236,44 +303,37 @@ func getAnnsFliterResponse(orderby, searchSize, searchColor, searchHeel, searchC
 	// 讀取回應內容
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		log.Println("func:getAnnsFliterResponse,Ann's 商品列表初始讀取回應錯誤:", err)
+		annsLog.ErrorContext(ctx, "Ann's 商品列表初始讀取回應錯誤", "categoryId", categoryId, "error", err)
 		return shoes, err
 	}
 
 	// 提取並解析傳回來body.json的資料
-	shoes, totalSize, err = extractSalePageList(body)
+	shoes, totalSize, err = anns.extractSalePageList(body)
 	if err != nil {
-		log.Println("func:getAnnsFliterResponse,Ann's解析 salePageList 錯誤:", err)
+		annsLog.ErrorContext(ctx, "Ann's 解析 salePageList 錯誤", "categoryId", categoryId, "error", err)
 		return shoes, err
 	}
-	log.Printf("結束請求與解析，從編號%d開始到編號%d", startIndex, startIndex+len(shoes))
+	annsLog.DebugContext(ctx, "結束請求與解析", "categoryId", categoryId, "startIndex", startIndex, "endIndex", startIndex+len(shoes), "totalSize", totalSize)
 
 	// 拿到totalSize後，再去拿所有鞋子的資訊，因為他一次請求只會回最多100雙，因此要迴圈請求
 	startIndex += 100
 	if totalSize > startIndex {
-		shoes, err = getTotalShoesByFliterResponse(shoes, startIndex, totalSize, requestBody)
+		shoes, err = anns.getTotalShoesByFliterResponse(ctx, shoes, startIndex, totalSize, requestBody)
 	}
 	if err != nil {
-		log.Println("func:getAnnsFliterResponse,Ann's 去拿所有鞋子的資訊錯誤:", err)
+		annsLog.ErrorContext(ctx, "Ann's 去拿所有鞋子的資訊錯誤", "categoryId", categoryId, "error", err)
 		return shoes, err
 	}
 
-	// 遍歷訪問shoes.URL，取得每個shoes的Size和Color
-	getSizeAndColorByHttpRequset(shoes)
-
-	// 篩選出有符合尺寸的鞋子
-	filteredShoes := filterShoesBySize(shoes, searchSize)
-	log.Printf("結束尺寸篩選，共有%d雙鞋", len(filteredShoes))
-
-	return filteredShoes, nil
+	return shoes, nil
 }
 
 // 提取並解析傳回來body.json的資料，並塞入ListID、Name、Price、Image、URL
-func extractSalePageList(body []byte) ([]Shoe, int, error) {
+func (anns *annsCrawler) extractSalePageList(body []byte) ([]Shoe, int, error) {
 	var responseData ResponseData
 	err := json.Unmarshal(body, &responseData)
 	if err != nil {
-		return nil, 0, fmt.Errorf("func:extractSalePageList,ann's JSON 解析錯誤: %v", err)
+		return nil, 0, newParseError("Ann's", "商品列表 JSON", err)
 	}
 
 	var shoes []Shoe
@@ -283,22 +343,92 @@ func extractSalePageList(body []byte) ([]Shoe, int, error) {
 		price := fmt.Sprintf("%v", item.Price)
 
 		shoe := Shoe{
-			ListID: salePageId,
-			Name:   item.Title,
-			Image:  item.PicUrl,
-			URL:    salepageURL + salePageId,
-			Price:  price,
+			ListID:         salePageId,
+			Name:           item.Title,
+			Image:          item.PicUrl,
+			URL:            anns.config.SalePageURL + salePageId,
+			Price:          price,
+			SuggestPrice:   annsSuggestPrice(item),
+			Store:          "anns",
+			Status:         shoeStatusAvailable,
+			SellingStartAt: normalizeStoreTime(item.SellingStartDateTime),
+			ListedAt:       normalizeStoreTime(item.ListingStartDateTime),
+		}
+		// 從標題與分類名稱解析款式屬性
+		shoe.Attributes = extractStyleAttributes(item.Title, responseData.Data.ShopCategory.SalePageList.ShopCategoryName)
+		// 同款不同色的商品用 SalePageGroup 串起來
+		shoe.FamilyID, shoe.FamilyColor = getAnnsFamily(item)
+		// 商品狀態，尚未開賣優先於售罄
+		if item.IsComingSoon {
+			shoe.Status = shoeStatusComingSoon
+		} else if item.IsSoldOut {
+			shoe.Status = shoeStatusSoldOut
 		}
 		shoes = append(shoes, shoe)
+
+		// 列表上才有的全部圖片、原價、促銷資訊先記下來，單一商品 API 會用到
+		anns.rememberAnnsShoe(item, responseData.Data.ShopCategory.SalePageList.ShopCategoryName)
 	}
 	totalSize := responseData.Data.ShopCategory.SalePageList.TotalSize
 
 	return shoes, totalSize, nil
 }
 
+// 以 SalePageGroup 中最小的 SalePageId 作為同款商品的 FamilyID，並取出本商品在群組中的顏色名稱
+func getAnnsFamily(item AnnsShoe) (string, string) {
+	familyID := item.SalePageId
+	familyColor := ""
+	if item.SalePageGroup != nil {
+		for _, groupItem := range item.SalePageGroup.GroupItems {
+			if groupItem.SalePageId < familyID {
+				familyID = groupItem.SalePageId
+			}
+			if groupItem.SalePageId == item.SalePageId {
+				familyColor = groupItem.ItemTitle
+			}
+		}
+	}
+	return strconv.Itoa(familyID), familyColor
+}
+
+// 把列表上的商品資訊記到 catalog
+func (anns *annsCrawler) rememberAnnsShoe(item AnnsShoe, categoryName string) {
+	images := item.PicList
+	if len(images) == 0 && item.PicUrl != "" {
+		images = []string{item.PicUrl}
+	}
+
+	var promotions []Promotion
+	for _, promotion := range item.PromotionPrices {
+		promotions = append(promotions, Promotion{
+			Label:   promotion.Label,
+			Price:   fmt.Sprintf("%v", promotion.Price),
+			StartAt: promotion.StartDateTime,
+			EndAt:   promotion.EndDateTime,
+		})
+	}
+
+	anns.catalog.remember(catalogEntry{
+		Store:        "anns",
+		ListID:       fmt.Sprintf("%v", item.SalePageId),
+		Images:       images,
+		SuggestPrice: annsSuggestPrice(item),
+		Promotions:   promotions,
+		Category:     categoryName,
+	})
+}
+
+// 列表上的原價，沒有時為空字串
+func annsSuggestPrice(item AnnsShoe) string {
+	if item.SuggestPrice <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("%v", item.SuggestPrice)
+}
+
 // 拿到totalSize後，再去拿所有鞋子的資訊，因為他一次請求只會回最多100雙
 // 注意:在併發區塊下下斷點，可能會有系統錯誤!
-func getTotalShoesByFliterResponse(shoes []Shoe, startIndex, totalSize int, requestBody RequestBody) ([]Shoe, error) {
+func (anns *annsCrawler) getTotalShoesByFliterResponse(ctx context.Context, shoes []Shoe, startIndex, totalSize int, requestBody RequestBody) ([]Shoe, error) {
 	var wg sync.WaitGroup
 	var mu sync.Mutex
 	ch := make(chan []Shoe)
@@ -310,35 +440,27 @@ func getTotalShoesByFliterResponse(shoes []Shoe, startIndex, totalSize int, requ
 		go func(startIndex int) {
 
 			var resp *http.Response
-			var client *http.Client
 			var newShoes []Shoe
 			defer wg.Done()
+			defer recoverPanic("Ann's 鞋子List請求", nil)
 
-			// 更新 requestBody 中的 StartIndex
-			log.Printf("開始鞋子List請求，從編號%d開始", startIndex)
-			requestBody.Variables.StartIndex = startIndex
-			jsonData, err := json.Marshal(requestBody)
+			// 更新 requestBody 中的 StartIndex，複製一份避免各 goroutine 互相覆蓋
+			annsLog.DebugContext(ctx, "開始鞋子 List 請求", "startIndex", startIndex)
+			pageRequestBody := requestBody
+			pageRequestBody.Variables.StartIndex = startIndex
+			jsonData, err := json.Marshal(pageRequestBody)
 			if err != nil {
-				log.Println("鞋子List請求,Ann's JSON 編碼錯誤:", err)
+				annsLog.ErrorContext(ctx, "鞋子 List 請求 JSON 編碼錯誤", "error", err)
+				reportListPageWarning(ctx, startIndex, err)
 				return
 			}
 
-			if enviroment == "release" {
-				// 正式環境，要設定自訂的帶有 CA 憑證的 HTTP 客戶端
-				client, err = createHTTPClientWithCACert("/etc/ssl/certs/ca-certificates.crt")
-				if err != nil {
-					log.Println("func:getTotalShoesByFliterResponse,Ann's 無法創建 HTTP 客戶端:", err)
-					return
-				}
-			} else {
-				// 本地端，不用設定 CA 憑證
-				client = &http.Client{}
-			}
 			// 直接向 Ann's 打 Fliter HTTP POST 請求
-			resp, err = client.Post(rootAPIURL, "application/json", bytes.NewBuffer(jsonData))
+			resp, err = postJSONWithContext(ctx, anns.client, anns.config.GraphQLURL, jsonData)
 
 			if err != nil {
-				log.Println("鞋子List請求,Ann's 鞋子List Post請求錯誤:", err)
+				annsLog.ErrorContext(ctx, "鞋子 List 請求錯誤", "startIndex", startIndex, "error", err)
+				reportListPageWarning(ctx, startIndex, err)
 				return
 			}
 			defer resp.Body.Close()
@@ -346,17 +468,19 @@ func getTotalShoesByFliterResponse(shoes []Shoe, startIndex, totalSize int, requ
 			// 讀取回應內容
 			body, err := io.ReadAll(resp.Body)
 			if err != nil {
-				log.Println("鞋子List請求,Ann's 鞋子List請求讀取Body錯誤:", err)
+				annsLog.ErrorContext(ctx, "鞋子 List 請求讀取 Body 錯誤", "startIndex", startIndex, "error", err)
+				reportListPageWarning(ctx, startIndex, err)
 				return
 			}
 
 			// 提取並解析傳回來body.json的資料
-			newShoes, totalSize, err = extractSalePageList(body)
+			newShoes, _, err = anns.extractSalePageList(body)
 			if err != nil {
-				log.Println("鞋子List請求，Ann's解析 salePageList 錯誤:", err)
+				annsLog.ErrorContext(ctx, "鞋子 List 請求解析 salePageList 錯誤", "startIndex", startIndex, "error", err)
+				reportListPageWarning(ctx, startIndex, err)
 				return
 			}
-			log.Printf("鞋子List請求，結束請求與解析，從編號%d開始到編號%d", startIndex, startIndex+len(newShoes))
+			annsLog.DebugContext(ctx, "鞋子 List 請求結束", "startIndex", startIndex, "endIndex", startIndex+len(newShoes))
 
 			ch <- newShoes
 
@@ -376,34 +500,36 @@ func getTotalShoesByFliterResponse(shoes []Shoe, startIndex, totalSize int, requ
 		mu.Unlock()
 	}
 
-	log.Println("撈取鞋子總雙數:", len(shoes))
+	annsLog.DebugContext(ctx, "撈取鞋子總雙數", "shoes", len(shoes))
 	return shoes, nil
 }
 
-// 遍歷訪問shoes.URL，取得每個shoes的Size和Color
-func getSizeAndColorByHttpRequset(shoes []Shoe) {
+// 列表某一頁取得失敗時這頁的鞋子會缺少，回報警告讓使用者知道結果不完整
+func reportListPageWarning(ctx context.Context, startIndex int, err error) {
+	reportWarning(ctx, newWarning("anns", "", fmt.Errorf("第 %d 筆起的商品列表取得失敗，結果可能不完整: %w", startIndex+1, err)))
+}
+
+// 遍歷訪問shoes.URL，取得每個shoes的Size和Color，回傳去掉非鞋類商品後的鞋子
+// searchSizes 只用來篩選回報給搜尋工作的部分結果
+func (anns *annsCrawler) getSizeAndColorByHttpRequset(ctx context.Context, shoes []Shoe, searchSizes []string) []Shoe {
 
 	// 用於等待所有 goroutines 完成
 	var wg sync.WaitGroup //類似C#的Task
-	// 用於保護共享資源
-	var mu sync.Mutex
 	// 傳遞結果的 channel
+	// renderedSizes 為瀏覽器渲染商品頁取得的尺寸，沒有 SKU 可查庫存；取得商品頁失敗的不會送出結果
 	ch := make(chan struct {
-		index int
-		size  []string
-		color []string
+		index         int
+		skus          []annsSKU
+		color         []string
+		renderedSizes []string
+		notShoe       bool
 	})
 
-	log.Println("要訪問的鞋子總雙數:", len(shoes))
+	annsLog.DebugContext(ctx, "要訪問的鞋子總雙數", "shoes", len(shoes))
 
 	// 用 semaphore 限制同時執行的 goroutine 數量
-	var sem = make(chan struct{}, 30) // 限制同時最多 X 個 goroutines
-
-	// 使用 rod 包啟動無頭瀏覽器
-	// url := launcher.New().Headless(true).MustLaunch()
-	// browser := rod.New().ControlURL(url).MustConnect()
-	// log.Println("Ann's Headless瀏覽器已啟動")
-	// defer browser.Close() // 確保程式結束時關閉瀏覽器
+	var sem = make(chan struct{}, anns.config.DetailWorkers) // 限制同時最多 X 個 goroutines
+	mode := anns.config.Render.Detail
 
 	for i := range shoes {
 		// 增加 WaitGroup 計數
@@ -412,52 +538,79 @@ func getSizeAndColorByHttpRequset(shoes []Shoe) {
 			// 當 goroutine 完成時減少 WaitGroup 計數
 			defer wg.Done()
 
-			var client *http.Client
-			var err error
-
 			// 使用 semaphore 保證最大併發數
 			sem <- struct{}{}
 			defer func() { <-sem }() // 完成後釋放 semaphore
+			defer metrics.detailFetchStarted("anns")()
+			// 取得失敗時回報警告，該商品仍會回傳但沒有尺碼
+			var err error
+			defer func() {
+				if err != nil && !errors.Is(err, errAnnsNotShoe) {
+					reportWarning(ctx, newWarning("anns", shoes[i].ListID, err))
+				}
+			}()
+			// 單一商品資料異常只略過該商品
+			defer recoverPanic("Ann's 商品 "+shoes[i].ListID, &err)
+
+			var skus []annsSKU
+			var color, renderedSizes []string
+			if mode != renderAlways {
+				childURL := anns.config.SalePageAPIURL + shoes[i].ListID
+
+				// 發送 GET 請求
+				var resp *http.Response
+				resp, err = getWithContext(ctx, anns.client, childURL)
+				if err != nil {
+					annsLog.WarnContext(ctx, "取得鞋子尺寸與顏色 JSON 請求錯誤", "listID", shoes[i].ListID, "error", err)
+					return
+				}
+				defer resp.Body.Close()
 
-			childURL := childAPIURL + shoes[i].ListID
+				// 讀取回應內容
+				var body []byte
+				body, err = io.ReadAll(resp.Body)
+				if err != nil {
+					annsLog.WarnContext(ctx, "取得鞋子尺寸與顏色 JSON 讀取 Body 錯誤", "listID", shoes[i].ListID, "error", err)
+					return
+				}
 
-			if enviroment == "release" {
-				// 正式環境，要設定自訂的帶有 CA 憑證的 HTTP 客戶端
-				client, err = createHTTPClientWithCACert("/etc/ssl/certs/ca-certificates.crt")
-			} else {
-				// 本地端，不用設定 CA 憑證
-				client = &http.Client{}
-			}
+				// 解析 JSON 取得各 SKU 的尺寸與顏色，庫存之後再一起批次查詢
+				var subTitle string
+				skus, color, subTitle, err = extractSizesAndColorsByHttpRequest(body)
+				if err != nil {
+					annsLog.DebugContext(ctx, "取得鞋子尺寸與顏色 JSON 解析異常", "listID", shoes[i].ListID, "name", shoes[i].Name, "url", shoes[i].URL, "error", err)
+				}
 
-			// 發送 GET 請求
-			resp, err := client.Get(childURL)
-			if err != nil {
-				log.Println("取得鞋子尺寸與顏色JSON,Ann's 發Get請求錯誤:", err)
-				return
+				// 副標題常寫有材質、跟高等描述，補進款式屬性(每個 goroutine 只寫自己的 index)
+				shoes[i].Attributes = mergeStyleAttributes(shoes[i].Attributes, extractStyleAttributes(subTitle))
 			}
-			defer resp.Body.Close()
 
-			// 讀取回應內容
-			body, err := io.ReadAll(resp.Body)
-			if err != nil {
-				log.Println("取得鞋子尺寸與顏色JSON,Ann's 解析Body錯誤:", err)
-				return
+			// 一律渲染，或 API 解析不到尺寸時改用瀏覽器渲染商品頁；包包、配件本來就沒有尺寸，不用渲染
+			if shouldRender(mode, len(skus) > 0 || errors.Is(err, errAnnsNotShoe)) {
+				sizes, colors, renderErr := anns.getSizeAndColorByGoRod(ctx, shoes[i])
+				switch {
+				case renderErr == nil:
+					renderedSizes = sizes
+					color = mergeUnique(append(color, colors...))
+					err = nil
+				case mode == renderAlways:
+					err = renderErr
+				}
 			}
 
-			//log.Printf("商品編號:%s, 已成功加載頁面", shoes[i].ListID)
-
-			// 解析 HTML 取得鞋子尺寸與顏色
-			size, color, err := extractSizesAndColorsByHttpRequest(body)
-			if err != nil {
-				log.Printf("取得鞋子尺寸與顏色JSON,Ann's 解析 JSON 異常，商品編號:%s,商品名稱:%s,商品URL:%s，錯誤資訊:%s", shoes[i].ListID, shoes[i].Name, shoes[i].URL, err)
+			// 解析失敗時尺碼不明，不送出結果，之後視為庫存未確認
+			if err != nil && !errors.Is(err, errAnnsNotShoe) {
+				return
 			}
 
 			// 將結果發送到 channel
 			ch <- struct {
-				index int
-				size  []string
-				color []string
-			}{index: i, size: size, color: color}
+				index         int
+				skus          []annsSKU
+				color         []string
+				renderedSizes []string
+				notShoe       bool
+			}{index: i, skus: skus, color: color, renderedSizes: renderedSizes, notShoe: errors.Is(err, errAnnsNotShoe)}
 		}(i)
 	}
 
@@ -468,98 +621,114 @@ func getSizeAndColorByHttpRequset(shoes []Shoe) {
 		close(ch)
 	}()
 
-	// 從 channel 接收結果並更新鞋子的尺寸和顏色
+	// 從 channel 接收結果，記下每雙鞋的 SKU 並更新顏色
+	productSKUs := make([][]annsSKU, len(shoes))
+	renderedSizes := make([][]string, len(shoes))
+	fetched := make([]bool, len(shoes))
+	notShoe := make([]bool, len(shoes))
+	var skuIds []int
+	done := 0
+	reportProgress(ctx, "detail", done, len(shoes))
 	for result := range ch {
-		// 鎖定 mutex 以保護共享資源
-		mu.Lock()
-		shoes[result.index].Size = result.size
 		shoes[result.index].Color = result.color
-		mu.Unlock()
+		productSKUs[result.index] = result.skus
+		renderedSizes[result.index] = result.renderedSizes
+		fetched[result.index] = true
+		notShoe[result.index] = result.notShoe
+		for _, sku := range result.skus {
+			skuIds = append(skuIds, sku.SKUId)
+		}
+		done++
+		reportProgress(ctx, "detail", done, len(shoes))
 	}
-}
 
-// 遍歷訪問shoes.URL，取得每個shoes的Size和Color
-func getSizeAndColorByGoRod(shoes []Shoe) {
+	// 所有商品的 SKU 合併成少數幾次庫存查詢，查詢失敗的 SKU 庫存未確認
+	stocks, stockErr := anns.getAnnsStockBySKU(ctx, skuIds)
 
-	// 用於等待所有 goroutines 完成
-	var wg sync.WaitGroup //類似C#的Task
-	// 用於保護共享資源
-	var mu sync.Mutex
-	// 傳遞結果的 channel
-	ch := make(chan struct {
-		index int
-		size  []string
-		color []string
-	})
-
-	log.Println("要訪問的鞋子總雙數:", len(shoes))
+	unverified := 0
 	for i := range shoes {
-		// 增加 WaitGroup 計數
-		wg.Add(1)
-		go func(i int) {
-			// 當 goroutine 完成時減少 WaitGroup 計數
-			defer wg.Done()
+		// 包包、配件沒有尺寸，不是沒有庫存，不能當成售罄的鞋子回傳
+		if notShoe[i] {
+			continue
+		}
+		shoes[i].Size = annsStockSizes(productSKUs[i], stocks)
+		// 商品頁或部分 SKU 的庫存沒取得時，不知道是否售罄
+		if !fetched[i] {
+			shoes[i].StockUnverified = true
+		} else if !annsStockFetched(productSKUs[i], stocks) {
+			shoes[i].StockUnverified = true
+			unverified++
+		}
+		// 渲染取得的商品沒有 SKU，無法查庫存，使用頁面上的尺寸並標示庫存未確認
+		if len(productSKUs[i]) == 0 && len(renderedSizes[i]) > 0 {
+			shoes[i].Size = renderedSizes[i]
+			shoes[i].StockUnverified = true
+			reportWarning(ctx, newWarning("anns", shoes[i].ListID, errAnnsRenderedSizes))
+		}
 
-			var client *http.Client
-			var err error
+		// 回報已完成且符合尺寸的鞋子
+		shoe := shoes[i]
+		markSoldOutIfNoSize(&shoe)
+		reportPartial(ctx, filterShoesBySize([]Shoe{shoe}, searchSizes)...)
+	}
 
-			if enviroment == "release" {
-				// 正式環境，要設定自訂的帶有 CA 憑證的 HTTP 客戶端
-				client, err = createHTTPClientWithCACert("/etc/ssl/certs/ca-certificates.crt")
-				if err != nil {
-					log.Println("Ann's 無法創建 HTTP 客戶端:", err)
-					return
-				}
-			} else {
-				// 本地端，不用設定 CA 憑證
-				client = &http.Client{}
-			}
+	// 商品頁失敗已逐一回報，這裡回報庫存查詢失敗影響的商品數
+	if stockErr != nil {
+		annsLog.WarnContext(ctx, "取得鞋子庫存部分 SKU 查詢失敗", "unverified", unverified, "error", stockErr)
+		reportWarning(ctx, newWarning("anns", "", fmt.Errorf("部分庫存查詢失敗，%d 個商品的尺碼與售罄狀態未確認: %w", unverified, stockErr)))
+	}
 
-			// 發送shoes.URL HTTP GET 請求
-			childresp, err := client.Get(shoes[i].URL)
-			if err != nil {
-				log.Println("Ann's 遍歷訪問各商品時請求錯誤:", err)
-				return
-			}
-			defer childresp.Body.Close()
+	shoeOnly := shoes[:0]
+	for i, shoe := range shoes {
+		if !notShoe[i] {
+			shoeOnly = append(shoeOnly, shoe)
+		}
+	}
+	if dropped := len(shoes) - len(shoeOnly); dropped > 0 {
+		annsLog.DebugContext(ctx, "略過非鞋類商品", "products", dropped)
+	}
+	return shoeOnly
+}
 
-			// 解析 HTML 取得鞋子尺寸與顏色
-			size, color, err := extractSizesAndColorsByGoRod(childresp.Body)
-			if err != nil {
-				log.Printf("Ann's 解析 HTML 異常，商品編號:%s,商品名稱:%s,商品URL:%s，錯誤資訊:%s", shoes[i].ListID, shoes[i].Name, shoes[i].URL, err)
-				return
-			}
+var errAnnsRenderedSizes = errors.New("尺寸由瀏覽器渲染商品頁取得，沒有庫存資料，可能包含已售完的尺寸")
 
-			// 將結果發送到 channel
-			ch <- struct {
-				index int
-				size  []string
-				color []string
-			}{index: i, size: size, color: color}
-		}(i)
+// 商品的每個 SKU 都查到庫存(含 0)才算確認過庫存
+func annsStockFetched(skus []annsSKU, stocks map[int]int) bool {
+	for _, sku := range skus {
+		if _, ok := stocks[sku.SKUId]; !ok {
+			return false
+		}
 	}
+	return true
+}
 
-	// 啟動一個 goroutine 來等待所有工作完成並關閉 channel
-	go func() {
-		// 等待所有 goroutines 完成
-		wg.Wait() //類似C#的Task.WaitAll()
-		close(ch)
-	}()
+// 列表顯示有貨但確認過庫存後所有尺寸都是 0 的，視為售罄；庫存未確認的維持列表上的狀態
+func markSoldOutIfNoSize(shoe *Shoe) {
+	if shoe.Status == shoeStatusAvailable && len(shoe.Size) == 0 && !shoe.StockUnverified {
+		shoe.Status = shoeStatusSoldOut
+	}
+}
 
-	// 從 channel 接收結果並更新鞋子的尺寸和顏色
-	for result := range ch {
-		// 鎖定 mutex 以保護共享資源
-		mu.Lock()
-		shoes[result.index].Size = result.size
-		shoes[result.index].Color = result.color
-		mu.Unlock()
+// 以無頭瀏覽器渲染商品頁，從渲染後的 HTML 取得尺寸與顏色
+func (anns *annsCrawler) getSizeAndColorByGoRod(ctx context.Context, shoe Shoe) ([]string, []string, error) {
+
+	html, err := anns.renderer.fetch(ctx, "anns", shoe.URL)
+	if err != nil {
+		return nil, nil, err
 	}
+	sizes, colors, err := extractSizesAndColorsByGoRod(strings.NewReader(html))
+	if err != nil {
+		annsLog.WarnContext(ctx, "Ann's 解析渲染後的 HTML 異常", "listID", shoe.ListID, "name", shoe.Name, "url", shoe.URL, "error", err)
+		return nil, nil, err
+	}
+	annsLog.DebugContext(ctx, "Ann's 以瀏覽器渲染商品頁", "listID", shoe.ListID, "sizes", len(sizes))
+	return sizes, colors, nil
 }
 
-// 解析 API 傳回來的資料並從中提取鞋子尺寸跟顏色
-func extractSizesAndColorsByHttpRequest(body []byte) ([]string, []string, error) {
+// 解析 API 傳回來的資料並從中提取各 SKU 的尺寸跟顏色，以及商品副標題
+func extractSizesAndColorsByHttpRequest(body []byte) ([]annsSKU, []string, string, error) {
 
-	var sizes []string
+	var skus []annsSKU
 	var colors []string
 	var err error
 	var annsShoeDetailOrignalHTML AnnsShoeDetailOrignalHTML
@@ -568,16 +737,37 @@ func extractSizesAndColorsByHttpRequest(body []byte) ([]string, []string, error)
 	// 將JSON格式的資料轉型成物件
 	err = json.Unmarshal(body, &annsShoeDetailOrignalHTML)
 	if err != nil {
-		log.Println("Ann's,解析尺寸與顏色的API JSON :", body)
-		return sizes, colors, fmt.Errorf("Ann's,解析尺寸與顏色的API,JSON 解析錯誤: %v", err)
+		annsLog.Debug("Ann's 解析尺寸與顏色的 API JSON 失敗", "bodyBytes", len(body))
+		return skus, colors, "", newParseError("Ann's", "尺寸與顏色 JSON", err)
 	}
 
 	annsShoeDetail = annsShoeDetailOrignalHTML.Data
-	log.Printf("Ann's,解析尺寸與顏色的API,商品名:%s", annsShoeDetail.Title)
+	annsLog.Debug("Ann's 解析尺寸與顏色的 API", "name", annsShoeDetail.Title)
+
+	skus, err = extractAnnsSKUs(annsShoeDetail)
+	if err != nil {
+		return skus, colors, annsShoeDetail.SubTitle, err
+	}
+
+	// 從 annsShoeDetail 中提取顏色，同一商品頁有多色規格時也一併列出
+	for _, productColor := range annsShoeDetail.SalePageGroup.SalePageItems {
+		colors = append(colors, productColor.GroupItemTitle)
+	}
+	for _, sku := range skus {
+		colors = append(colors, sku.Color)
+	}
+	colors = mergeUnique(colors)
 
-	// 從 annsShoeDetail 中提取尺寸(下分兩種情況，一種是單色，那他的尺寸是在MajorList[0].SKUList[1]裡，而MajorList[0].SKUList[0]放的是顏色資訊，另一種是多色，那他的尺寸即是在MajorList[0].SKUList[0]裡)
+	return skus, colors, annsShoeDetail.SubTitle, nil
+}
+
+// 從 annsShoeDetail 中提取尺寸(下分兩種情況，一種是單色，那他的尺寸是在MajorList[0].SKUList[1]裡，而MajorList[0].SKUList[0]放的是顏色資訊，另一種是多色，那他的尺寸即是在MajorList[0].SKUList[0]裡)
+func extractAnnsDetailSizes(annsShoeDetail AnnsShoeDetail) ([]string, error) {
+	if len(annsShoeDetail.MajorList) == 0 || len(annsShoeDetail.MajorList[0].SKUList) == 0 {
+		return nil, newParseError("Ann's", "尺寸與顏色", errors.New("商品沒有規格資料"))
+	}
 	displayPropertyName := annsShoeDetail.MajorList[0].SKUList[0].DisplayPropertyName
-	sizes = strings.Split(displayPropertyName, "/")
+	sizes := strings.Split(displayPropertyName, "/")
 	// 檢查 sizes 的長度是否為 1 或者裡面不包含數字
 	if len(sizes) == 1 || !containsDigit(sizes) {
 		// 檢查 annsShoeDetail.MajorList[0].SKUList[1] 是否存在
@@ -585,20 +775,94 @@ func extractSizesAndColorsByHttpRequest(body []byte) ([]string, []string, error)
 			displayPropertyName = annsShoeDetail.MajorList[0].SKUList[1].DisplayPropertyName
 			sizes = strings.Split(displayPropertyName, "/")
 		} else {
-			log.Printf("Ann's,解析尺寸與顏色的API,商品名:%s非鞋類", annsShoeDetail.Title)
-			return sizes, colors, fmt.Errorf("Ann's,解析尺寸與顏色的API,商品非鞋類")
+			annsLog.Debug("Ann's 商品非鞋類", "name", annsShoeDetail.Title)
+			return sizes, newParseError("Ann's", "尺寸與顏色", errAnnsNotShoe)
 		}
 	}
+	return sizes, nil
+}
 
-	//篩選出未受罄的尺寸
-	sizes = filterStockSizeByHttpRequest(annsShoeDetail.SaleProductSKUIdList, sizes)
+// annsSKU 一個 SKU 與其對應的顏色、尺寸，單色商品的 Color 可能為空
+type annsSKU struct {
+	SKUId int
+	Color string
+	Size  string
+}
 
-	// 從 annsShoeDetail 中提取顏色
-	for _, productColor := range annsShoeDetail.SalePageGroup.SalePageItems {
-		colors = append(colors, productColor.GroupItemTitle)
+// PropertyNameSet 中代表尺寸、顏色的屬性名稱
+var (
+	annsSizePropertyNames  = []string{"尺寸", "尺碼", "size"}
+	annsColorPropertyNames = []string{"顏色", "色", "color"}
+)
+
+// 解析各 SKU 的顏色與尺寸。優先使用 SKUPropertySetList 的 PropertyNameSet(如 "顏色:黑色,尺寸:41")，
+// 多色商品的 SKU 數為顏色數×尺寸數，需逐一對應；沒有 SKUPropertySetList 時，SKU 數與尺寸數相同才依序對應
+func extractAnnsSKUs(annsShoeDetail AnnsShoeDetail) ([]annsSKU, error) {
+
+	var skus []annsSKU
+	for _, property := range annsShoeDetail.SKUPropertySetList {
+		color, size := parseAnnsPropertyNameSet(property.PropertyNameSet)
+		if size == "" {
+			continue
+		}
+		skus = append(skus, annsSKU{SKUId: property.SaleProductSKUId, Color: color, Size: size})
+	}
+	if len(skus) > 0 {
+		return skus, nil
+	}
+	if len(annsShoeDetail.SKUPropertySetList) > 0 {
+		annsLog.Debug("Ann's 商品規格沒有尺寸，非鞋類", "name", annsShoeDetail.Title)
+		return nil, newParseError("Ann's", "尺寸與顏色", errAnnsNotShoe)
 	}
 
-	return sizes, colors, nil
+	sizes, err := extractAnnsDetailSizes(annsShoeDetail)
+	if err != nil {
+		return nil, err
+	}
+	ids := annsShoeDetail.SaleProductSKUIdList
+	if len(ids) != len(sizes) {
+		annsLog.Warn("Ann's SKU 數與尺寸數不同，無法對應庫存", "name", annsShoeDetail.Title, "skus", len(ids), "sizes", len(sizes))
+		return nil, newParseError("Ann's", "尺寸與顏色", errors.New("SKU 與尺寸數量不符"))
+	}
+	for i, id := range ids {
+		skus = append(skus, annsSKU{SKUId: id, Size: sizes[i]})
+	}
+	return skus, nil
+}
+
+// 解析 PropertyNameSet，例如 "顏色:黑色,尺寸:41"、"尺寸:41"。
+// 單一規格的商品有時把尺寸放在「顏色」底下(如 "顏色:41")，值為數字且沒有尺寸屬性時當作尺寸
+func parseAnnsPropertyNameSet(propertyNameSet string) (string, string) {
+
+	var color, size string
+	var numericValue string
+	for _, part := range strings.FieldsFunc(propertyNameSet, func(r rune) bool { return r == ',' || r == '，' || r == '|' }) {
+		name, value, found := strings.Cut(part, ":")
+		if !found {
+			name, value, found = strings.Cut(part, "：")
+		}
+		if !found {
+			continue
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		value = strings.TrimSpace(value)
+		switch {
+		case containsAny(name, annsSizePropertyNames):
+			size = value
+		case containsAny(name, annsColorPropertyNames):
+			if containsDigit([]string{value}) && numericValue == "" {
+				numericValue = value
+			}
+			color = value
+		}
+	}
+	if size == "" && numericValue != "" {
+		size = numericValue
+		if color == numericValue {
+			color = ""
+		}
+	}
+	return color, size
 }
 
 // 解析 HTML 並從中提取鞋子尺寸跟顏色
@@ -626,17 +890,19 @@ func extractSizesAndColorsByGoRod(body io.Reader) ([]string, []string, error) {
 		sizeSet[match[1]] = struct{}{}
 	}
 
-	// 轉成 slice 回傳
+	// 轉成 slice 回傳，依尺寸排序
 	sizes := make([]string, 0, len(sizeSet))
 	for size := range sizeSet {
 		sizes = append(sizes, size)
 	}
+	sort.Strings(sizes)
 	// 找出所有 "GroupItemTitle": 後的顏色
 	colorRe := regexp.MustCompile(`"GroupItemTitle"\s*:\s*"([^"]*)"`)
 	colorMatches := colorRe.FindAllStringSubmatch(htmlContent, -1)
 
+	// 單色或沒有顏色的商品仍回傳尺寸
 	if colorMatches == nil {
-		return nil, nil, fmt.Errorf("ann's 未找到任何顏色資料，或其只有單色/沒有顏色")
+		return sizes, nil, nil
 	}
 
 	// 用 map 避免重複
@@ -650,30 +916,106 @@ func extractSizesAndColorsByGoRod(body io.Reader) ([]string, []string, error) {
 	for color := range colorSet {
 		colors = append(colors, color)
 	}
+	sort.Strings(colors)
 	return sizes, colors, nil
 }
 
-// 尺寸篩選
-func filterShoesBySize(shoes []Shoe, searchSize string) []Shoe {
+// 尺寸篩選，有任一個 searchSizes 的尺寸即符合；售罄與尚未開賣的鞋子沒有庫存尺寸，交給 filterShoesByStatus 決定是否保留
+func filterShoesBySize(shoes []Shoe, searchSizes []string) []Shoe {
+	// 沒有指定尺碼就不篩選
+	if len(searchSizes) == 0 {
+		return shoes
+	}
 	var filteredShoes []Shoe
 	for _, shoe := range shoes {
-		for _, size := range shoe.Size {
-			if size == searchSize {
-				filteredShoes = append(filteredShoes, shoe)
-			}
+		if shoe.Status != shoeStatusAvailable || hasAnySize(shoe, searchSizes) {
+			filteredShoes = append(filteredShoes, shoe)
 		}
 	}
 	return filteredShoes
 }
 
-// 篩選出未受罄的尺寸
-func filterStockSizeByHttpRequest(saleProductSKUIdList []int, sizes []string) []string {
+// 鞋子的現貨尺碼中是否有任一個指定的尺碼
+func hasAnySize(shoe Shoe, searchSizes []string) bool {
+	for _, size := range shoe.Size {
+		for _, searchSize := range searchSizes {
+			if size == searchSize {
+				return true
+			}
+		}
+	}
+	return false
+}
 
+// 依 SKU 庫存挑出有貨的尺寸，保留原本的尺寸順序
+func annsStockSizes(skus []annsSKU, stocks map[int]int) []string {
 	var stockSizes []string
+	for _, sku := range skus {
+		if stocks[sku.SKUId] > 0 {
+			stockSizes = append(stockSizes, sku.Size)
+		}
+	}
+	return mergeUnique(stockSizes)
+}
+
+// 批次查詢多個 SKU 的可售數量，依設定的批次大小分批並行送出，回傳 SaleProductSKUId 對應的數量。
+// 部分批次失敗時仍回傳其他批次的結果
+func (anns *annsCrawler) getAnnsStockBySKU(ctx context.Context, saleProductSKUIdList []int) (map[int]int, error) {
+
+	stocks := map[int]int{}
+	if len(saleProductSKUIdList) == 0 {
+		return stocks, nil
+	}
+
+	var batches [][]int
+	batchSize := anns.config.StockBatchSize
+	for start := 0; start < len(saleProductSKUIdList); start += batchSize {
+		end := min(start+batchSize, len(saleProductSKUIdList))
+		batches = append(batches, saleProductSKUIdList[start:end])
+	}
+	annsLog.DebugContext(ctx, "Ann's 庫存查詢", "skus", len(saleProductSKUIdList), "batches", len(batches))
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var firstErr error
+	var sem = make(chan struct{}, anns.config.StockConcurrency)
+	done := 0
+	reportProgress(ctx, "stock", done, len(batches))
+
+	for _, batch := range batches {
+		wg.Add(1)
+		go func(batch []int) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			saleProductSKUIdDO, err := anns.getAnnsSellingQty(ctx, batch)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+			} else {
+				// 以 SKU ID 對應，不依賴回應的順序
+				for _, sku := range saleProductSKUIdDO {
+					stocks[sku.SaleProductSKUId] = sku.SellingQty
+				}
+			}
+			done++
+			reportProgress(ctx, "stock", done, len(batches))
+		}(batch)
+	}
+	wg.Wait()
+
+	return stocks, firstErr
+}
+
+// 向 Ann's 尺寸庫存 API 查詢各 SKU 的可售數量
+func (anns *annsCrawler) getAnnsSellingQty(ctx context.Context, saleProductSKUIdList []int) ([]SaleProductSKUIdDO, error) {
+
 	var saleProductSKUIdDO []SaleProductSKUIdDO
-	var client *http.Client
-	var response *http.Response
-	var err error
 
 	// 將 saleProductSKUIdList 轉換為逗號分隔的字符串
 	ids := strings.Trim(strings.Join(strings.Fields(fmt.Sprint(saleProductSKUIdList)), ","), "[]")
@@ -684,47 +1026,27 @@ func filterStockSizeByHttpRequest(saleProductSKUIdList []int, sizes []string) []
 	}
 	sizeJsonData, err := json.Marshal(sizeRequestBody)
 	if err != nil {
-		log.Println("篩選出未受罄的尺寸,Ann's JSON 編碼打尺寸資訊的API的請求參數錯誤:", err)
-		return nil
-	}
-
-	if enviroment == "release" {
-		// 正式環境，要設定自訂的帶有 CA 憑證的 HTTP 客戶端
-		client, err = createHTTPClientWithCACert("/etc/ssl/certs/ca-certificates.crt")
-	} else {
-		// 本地端，不用設定 CA 憑證
-		client = &http.Client{}
+		return nil, fmt.Errorf("JSON 編碼打尺寸資訊的API的請求參數錯誤: %v", err)
 	}
 
-	// 帶有 CA 憑證的 HTTP 客戶端向 Ann's 打 Fliter HTTP POST 請求
-	response, err = client.Post(sizeStockAPIURL, "application/json", bytes.NewBuffer(sizeJsonData))
+	// 向 Ann's 打尺寸資訊 HTTP POST 請求
+	response, err := postJSONWithContext(ctx, anns.client, anns.config.StockAPIURL, sizeJsonData)
 	if err != nil {
-		log.Println("篩選出未受罄的尺寸,Ann's 打尺寸資訊的API錯誤:", err)
-		return nil
+		return nil, fmt.Errorf("打尺寸資訊的API錯誤: %v", err)
 	}
 	defer response.Body.Close()
 
 	// 讀取回應內容
 	body, err := io.ReadAll(response.Body)
 	if err != nil {
-		log.Println("篩選出未受罄的尺寸,Ann's 讀取打尺寸資訊的API的回應錯誤:", err)
-		return nil
+		return nil, fmt.Errorf("讀取打尺寸資訊的API的回應錯誤: %v", err)
 	}
 
 	// 解析回應
 	err = json.Unmarshal(body, &saleProductSKUIdDO)
 	if err != nil {
-		log.Println("篩選出未受罄的尺寸,Ann's 尺寸資訊的API的回應的 JSON 解析錯誤:", err)
-		return nil
+		return nil, newParseError("Ann's", "庫存 JSON", err)
 	}
 
-	// 遍歷 saleProductSKUIdDO，挑選出 SellingQty > 0 的元素，並將其對應的尺寸加入 stockSizes
-	for i, sku := range saleProductSKUIdDO {
-		if sku.SellingQty > 0 {
-			stockSizes = append(stockSizes, sizes[i])
-		}
-	}
-
-	return stockSizes
-
+	return saleProductSKUIdDO, nil
 }
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func readTestdata(t testing.TB, name string) []byte {
+	t.Helper()
+	body, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+func TestParseAnnsPropertyNameSet(t *testing.T) {
+	tests := []struct {
+		propertyNameSet string
+		color, size     string
+	}{
+		{propertyNameSet: "顏色:黑色,尺寸:41", color: "黑色", size: "41"},
+		{propertyNameSet: "尺寸:41", size: "41"},
+		{propertyNameSet: "尺碼：42", size: "42"},
+		{propertyNameSet: "Color:Black|Size:40", color: "Black", size: "40"},
+		{propertyNameSet: " 顏色 : 杏色 ， 尺寸 : 39 ", color: "杏色", size: "39"},
+		// 單一規格把尺寸放在顏色底下
+		{propertyNameSet: "顏色:41", size: "41"},
+		{propertyNameSet: "顏色:黑色,尺寸:41,顏色:2號色", color: "2號色", size: "41"},
+		// 只有顏色的非鞋類規格
+		{propertyNameSet: "顏色:黑色", color: "黑色"},
+		{propertyNameSet: "款式:單肩", color: "", size: ""},
+		{propertyNameSet: "", color: "", size: ""},
+	}
+	for _, test := range tests {
+		color, size := parseAnnsPropertyNameSet(test.propertyNameSet)
+		if color != test.color || size != test.size {
+			t.Errorf("parseAnnsPropertyNameSet(%q) = %q, %q，應為 %q, %q", test.propertyNameSet, color, size, test.color, test.size)
+		}
+	}
+}
+
+func TestExtractAnnsSKUs(t *testing.T) {
+	tests := []struct {
+		fixture  string
+		skus     []annsSKU
+		colors   []string
+		subTitle string
+		err      error
+	}{
+		{
+			fixture: "anns/detail_color_size.json",
+			skus: []annsSKU{
+				{SKUId: 50110001, Color: "黑色", Size: "40"},
+				{SKUId: 50110002, Color: "黑色", Size: "41"},
+				{SKUId: 50110003, Color: "杏色", Size: "40"},
+				{SKUId: 50110004, Color: "杏色", Size: "41"},
+			},
+			colors:   []string{"黑色", "杏色"},
+			subTitle: "跟高5cm｜真皮內裡",
+		},
+		{
+			fixture: "anns/detail_size_only.json",
+			skus: []annsSKU{
+				{SKUId: 50220001, Size: "42"},
+				{SKUId: 50220002, Size: "43"},
+				{SKUId: 50220003, Size: "44"},
+			},
+			subTitle: "平底好走",
+		},
+		{
+			// 沒有 SKUPropertySetList 時依 MajorList 的尺寸順序對應
+			fixture: "anns/detail_major_list.json",
+			skus: []annsSKU{
+				{SKUId: 50440001, Size: "41"},
+				{SKUId: 50440002, Size: "42"},
+			},
+		},
+		{
+			fixture:  "anns/detail_not_shoe.json",
+			subTitle: "大容量",
+			err:      errAnnsNotShoe,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.fixture, func(t *testing.T) {
+			skus, colors, subTitle, err := extractSizesAndColorsByHttpRequest(readTestdata(t, test.fixture))
+			if test.err != nil {
+				var parseError *ParseError
+				if !errors.Is(err, test.err) || !errors.As(err, &parseError) {
+					t.Fatalf("錯誤 %v，應為 %v 的 ParseError", err, test.err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(skus, test.skus) {
+				t.Errorf("SKU %+v，應為 %+v", skus, test.skus)
+			}
+			if test.err == nil && (len(colors) > 0 || len(test.colors) > 0) && !reflect.DeepEqual(colors, test.colors) {
+				t.Errorf("顏色 %q，應為 %q", colors, test.colors)
+			}
+			if subTitle != test.subTitle {
+				t.Errorf("副標題 %q，應為 %q", subTitle, test.subTitle)
+			}
+		})
+	}
+}
+
+func TestExtractAnnsSKUsMismatch(t *testing.T) {
+	detail := AnnsShoeDetail{
+		SaleProductSKUIdList: []int{1, 2, 3},
+		MajorList:            []MajorList{{SKUList: []SKUList{{DisplayPropertyName: "41/42"}}}},
+	}
+	var parseError *ParseError
+	if _, err := extractAnnsSKUs(detail); !errors.As(err, &parseError) || errors.Is(err, errAnnsNotShoe) {
+		t.Errorf("SKU 數與尺寸數不同應回傳 ParseError，得到 %v", err)
+	}
+}
+
+func TestMarkSoldOutIfNoSize(t *testing.T) {
+	skus := []annsSKU{{SKUId: 1, Size: "40"}, {SKUId: 2, Size: "41"}}
+	tests := []struct {
+		name   string
+		stocks map[int]int
+		want   string
+	}{
+		{name: "所有尺寸都沒有庫存", stocks: map[int]int{1: 0, 2: 0}, want: shoeStatusSoldOut},
+		{name: "有尺寸有庫存", stocks: map[int]int{1: 0, 2: 3}, want: shoeStatusAvailable},
+		// 庫存查詢失敗的批次不在 stocks 中，不能當成售罄
+		{name: "部分 SKU 庫存未取得", stocks: map[int]int{1: 0}, want: shoeStatusAvailable},
+		{name: "庫存全部未取得", stocks: map[int]int{}, want: shoeStatusAvailable},
+	}
+	for _, test := range tests {
+		shoe := Shoe{Status: shoeStatusAvailable, Size: annsStockSizes(skus, test.stocks)}
+		shoe.StockUnverified = !annsStockFetched(skus, test.stocks)
+		markSoldOutIfNoSize(&shoe)
+		if shoe.Status != test.want {
+			t.Errorf("%s: 狀態 %s，應為 %s", test.name, shoe.Status, test.want)
+		}
+	}
+}
+
+func TestGetSizeAndColorDropsNonShoes(t *testing.T) {
+	details := map[string]string{"1": "anns/detail_size_only.json", "2": "anns/detail_not_shoe.json", "3": "anns/detail_color_size.json"}
+	stocks := []SaleProductSKUIdDO{
+		{SaleProductSKUId: 50220001}, {SaleProductSKUId: 50220002}, {SaleProductSKUId: 50220003},
+		{SaleProductSKUId: 50110001, SellingQty: 3}, {SaleProductSKUId: 50110002}, {SaleProductSKUId: 50110003, SellingQty: 2}, {SaleProductSKUId: 50110004},
+		{SaleProductSKUId: 50330001, SellingQty: 6}, {SaleProductSKUId: 50330002, SellingQty: 1},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/stock" {
+			json.NewEncoder(w).Encode(stocks)
+			return
+		}
+		w.Write(readTestdata(t, details[strings.TrimPrefix(r.URL.Path, "/salepage/")]))
+	}))
+	defer server.Close()
+
+	config := defaultConfig("test").Anns
+	config.SalePageAPIURL = server.URL + "/salepage/"
+	config.StockAPIURL = server.URL + "/stock"
+	anns := &annsCrawler{client: server.Client(), config: config, catalog: newProductCatalog()}
+	shoes := []Shoe{
+		{Store: "anns", ListID: "1", Status: shoeStatusAvailable},
+		{Store: "anns", ListID: "2", Status: shoeStatusAvailable},
+		{Store: "anns", ListID: "3", Status: shoeStatusAvailable},
+	}
+
+	shoes = anns.getSizeAndColorByHttpRequset(context.Background(), shoes, nil)
+	for i := range shoes {
+		markSoldOutIfNoSize(&shoes[i])
+	}
+	// 包包沒有尺寸規格，不應當成售罄的鞋子回傳
+	got := map[string]string{}
+	for _, shoe := range shoes {
+		got[shoe.ListID] = shoe.Status + " " + strings.Join(shoe.Size, ",")
+	}
+	want := map[string]string{"1": shoeStatusSoldOut + " ", "3": shoeStatusAvailable + " 40"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("得到 %v，應為 %v", got, want)
+	}
+}
+
+func TestExtractSalePageListRemembersCatalog(t *testing.T) {
+	anns := &annsCrawler{config: defaultConfig("test").Anns, catalog: newProductCatalog()}
+	shoes, _, err := anns.extractSalePageList(readTestdata(t, "anns/list.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(shoes) != 2 || shoes[0].URL != "https://www.anns.tw/SalePage/Index/8123456" {
+		t.Fatalf("解析結果不符: %+v", shoes)
+	}
+	entry, ok := anns.catalog.lookup("anns", "8123456")
+	if !ok || len(entry.Images) != 2 || entry.Category != "全部鞋款" {
+		t.Errorf("列表上的資訊應記到商品目錄，得到 %+v %v", entry, ok)
+	}
+	if _, ok := anns.catalog.lookup("anns", "8200001"); !ok {
+		t.Error("每個商品都應記到商品目錄")
+	}
+}
+
+func FuzzExtractSalePageList(f *testing.F) {
+	f.Add(readTestdata(f, "anns/list.json"))
+	f.Add([]byte(`{"data": {"shopCategory": {"salePageList": {"salePageList": [{"salePageId": 1, "salePageGroup": {"groupItems": []}}]}}}}`))
+	f.Add([]byte(`{"data": {"shopCategory": {"salePageList": {"salePageList": [{"salePageId": "1"}]}}}}`))
+	f.Add([]byte(`null`))
+	f.Add([]byte(`{`))
+	f.Fuzz(func(t *testing.T, body []byte) {
+		anns := &annsCrawler{config: defaultConfig("test").Anns, catalog: newProductCatalog()}
+		shoes, totalSize, err := anns.extractSalePageList(body)
+		requireParseError(t, err)
+		if err != nil && (len(shoes) > 0 || totalSize != 0) {
+			t.Fatalf("解析失敗時不應回傳商品: %d 雙、共 %d 雙", len(shoes), totalSize)
+		}
+		for _, shoe := range shoes {
+			if shoe.Store != "anns" || shoe.Status == "" {
+				t.Fatalf("商品缺少店鋪或狀態: %+v", shoe)
+			}
+		}
+	})
+}
+
+func FuzzParseAnnsPropertyNameSet(f *testing.F) {
+	for _, seed := range []string{"顏色:黑色,尺寸:41", "尺寸:41", "顏色:41", "顏色:黑色", "Color:Black|Size:40", "尺碼：42，顏色：杏", ":,:", ""} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, propertyNameSet string) {
+		color, size := parseAnnsPropertyNameSet(propertyNameSet)
+		// 解析出的值一定來自原字串
+		if !strings.Contains(propertyNameSet, color) || !strings.Contains(propertyNameSet, size) {
+			t.Fatalf("parseAnnsPropertyNameSet(%q) = %q, %q 不在原字串中", propertyNameSet, color, size)
+		}
+		if color != "" && color == size {
+			t.Fatalf("parseAnnsPropertyNameSet(%q) 顏色與尺寸相同: %q", propertyNameSet, color)
+		}
+	})
+}
+
+func FuzzExtractAnnsSKUs(f *testing.F) {
+	for _, fixture := range []string{"detail_color_size.json", "detail_size_only.json", "detail_major_list.json", "detail_not_shoe.json"} {
+		f.Add(readTestdata(f, "anns/"+fixture))
+	}
+	f.Add([]byte(`{"Data": {"SaleProductSKUIdList": [1], "MajorList": [{"SKUList": []}]}}`))
+	f.Add([]byte(`{"Data": {"SaleProductSKUIdList": [1, 2], "MajorList": [{"SKUList": [{"DisplayPropertyName": "黑"}]}]}}`))
+	f.Add([]byte(`{"Data": {"SKUPropertySetList": [{"SaleProductSKUId": 1, "PropertyNameSet": ""}]}}`))
+	f.Add([]byte(`{"Data": null}`))
+	f.Fuzz(func(t *testing.T, body []byte) {
+		skus, _, _, err := extractSizesAndColorsByHttpRequest(body)
+		requireParseError(t, err)
+		if err != nil && len(skus) > 0 {
+			t.Fatalf("解析失敗時不應回傳 SKU: %+v", skus)
+		}
+		for _, sku := range skus {
+			if sku.Size == "" {
+				t.Fatalf("SKU 缺少尺寸: %+v", sku)
+			}
+		}
+	})
+}
+
+func TestAnnsMalformedResponses(t *testing.T) {
+	anns := &annsCrawler{catalog: newProductCatalog()}
+	for _, body := range []string{"", "{", `{"data": {"shopCategory": {"salePageList": {"salePageList": [{"salePageId": "1"}]}}}}`} {
+		_, _, err := anns.extractSalePageList([]byte(body))
+		var parseError *ParseError
+		if !errors.As(err, &parseError) {
+			t.Errorf("extractSalePageList(%q) 應回傳 *ParseError，得到 %v", body, err)
+		}
+	}
+	for _, body := range []string{"", `{"Data": null}`, `{"Data": {"MajorList": [{"SKUList": []}]}}`} {
+		_, _, _, err := extractSizesAndColorsByHttpRequest([]byte(body))
+		var parseError *ParseError
+		if !errors.As(err, &parseError) {
+			t.Errorf("extractSizesAndColorsByHttpRequest(%q) 應回傳 *ParseError，得到 %v", body, err)
+		}
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// 錯誤代碼，前端依此顯示對應的訊息
+const (
+	errCodeUnknownStore     = "unknown_store"
+	errCodeBadParam         = "bad_param"
+	errCodeNotFound         = "not_found"
+	errCodeUpstreamTimeout  = "upstream_timeout"
+	errCodeUpstreamError    = "upstream_error"
+	errCodeParseError       = "parse_error"
+	errCodeBusy             = "busy"
+	errCodeRateLimited      = "rate_limited"
+	errCodeMethodNotAllowed = "method_not_allowed"
+	errCodeInternal         = "internal_error"
+)
+
+var errUnknownStore = errors.New("未知的商店")
+
+var errMethodNotAllowed = errors.New("不支援此 HTTP 方法")
+
+// ParamError 查詢參數錯誤，Field 為出錯的參數名稱(無法確定時為空)
+type ParamError struct {
+	Field string
+	Err   error
+}
+
+func (e *ParamError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ParamError) Unwrap() error {
+	return e.Err
+}
+
+func newParamError(field string, err error) *ParamError {
+	return &ParamError{Field: field, Err: err}
+}
+
+// APIResponse 所有 JSON API 的回應格式，成功時有 data，失敗時有 error
+type APIResponse struct {
+	RequestID string      `json:"requestId"`
+	Data      interface{} `json:"data,omitempty"`
+	Page      *PageInfo   `json:"page,omitempty"`
+	Error     *APIError   `json:"error,omitempty"`
+	Warnings  []Warning   `json:"warnings,omitempty"`
+}
+
+// APIError 錯誤代碼與可直接顯示給使用者的訊息
+type APIError struct {
+	Code    string       `json:"code"`
+	Message string       `json:"message"`
+	Store   string       `json:"store,omitempty"`
+	Field   string       `json:"field,omitempty"`
+	Fields  []FieldError `json:"fields,omitempty"`
+}
+
+// Warning 個別商品取得尺碼、顏色或庫存失敗，該商品仍會回傳但資料可能不完整
+type Warning struct {
+	Store   string `json:"store"`
+	ListID  string `json:"listID,omitempty"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// internal_error 回傳給客戶端的訊息；原始錯誤可能包含內部網址或程式細節，只記在 log
+const internalErrorMessage = "伺服器發生非預期錯誤，請稍後再試"
+
+// 依錯誤類型轉成錯誤代碼與 HTTP 狀態碼
+func classifyError(err error) (int, APIError) {
+
+	apiError := APIError{Code: errCodeInternal, Message: err.Error()}
+
+	var paramError *ParamError
+	var validationError *ValidationError
+	var parseError *ParseError
+	var statusError *UpstreamStatusError
+	var netError net.Error
+	switch {
+	case errors.Is(err, errUnknownStore):
+		apiError.Code = errCodeUnknownStore
+		return http.StatusBadRequest, apiError
+	case errors.As(err, &validationError):
+		apiError.Code = errCodeBadParam
+		apiError.Fields = validationError.Fields
+		return http.StatusBadRequest, apiError
+	case errors.As(err, &paramError):
+		apiError.Code = errCodeBadParam
+		apiError.Field = paramError.Field
+		return http.StatusBadRequest, apiError
+	case errors.Is(err, errProductNotFound), errors.Is(err, errSearchJobNotFound), errors.Is(err, errCursorExpired):
+		apiError.Code = errCodeNotFound
+		return http.StatusNotFound, apiError
+	case errors.Is(err, errMethodNotAllowed):
+		apiError.Code = errCodeMethodNotAllowed
+		return http.StatusMethodNotAllowed, apiError
+	case errors.Is(err, errRateLimited):
+		apiError.Code = errCodeRateLimited
+		return http.StatusTooManyRequests, apiError
+	case errors.Is(err, errSearchQueueFull), errors.Is(err, errShuttingDown):
+		apiError.Code = errCodeBusy
+		return http.StatusServiceUnavailable, apiError
+	case errors.As(err, &parseError):
+		apiError.Code = errCodeParseError
+		apiError.Store = parseError.Store
+		return http.StatusBadGateway, apiError
+	case errors.As(err, &statusError):
+		apiError.Code = errCodeUpstreamError
+		apiError.Store = statusError.Store
+		apiError.Message = "商店暫時無法回應，請稍後再試"
+		return http.StatusBadGateway, apiError
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netError) && netError.Timeout():
+		apiError.Code = errCodeUpstreamTimeout
+		apiError.Message = "商店回應逾時，請稍後再試"
+		return http.StatusGatewayTimeout, apiError
+	case errors.As(err, &netError):
+		apiError.Code = errCodeUpstreamError
+		apiError.Message = "無法連線到商店，請稍後再試"
+		return http.StatusBadGateway, apiError
+	}
+	apiError.Message = internalErrorMessage
+	return http.StatusInternalServerError, apiError
+}
+
+// 個別商品失敗時的警告
+func newWarning(store, listID string, err error) Warning {
+	_, apiError := classifyError(err)
+	message := err.Error()
+	if apiError.Code == errCodeInternal {
+		message = apiError.Message
+	}
+	return Warning{Store: store, ListID: listID, Code: apiError.Code, Message: message}
+}
+
+// 回傳成功的 JSON 結果
+func writeData(w http.ResponseWriter, r *http.Request, status int, data interface{}, warnings []Warning) {
+	writeAPIResponse(w, status, APIResponse{
+		RequestID: requestIDFrom(r.Context()),
+		Data:      data,
+		Warnings:  warnings,
+	})
+}
+
+// 回傳成功的 JSON 結果與分頁資訊，page 為 nil 時與 writeData 相同
+func writePage(w http.ResponseWriter, r *http.Request, status int, data interface{}, page *PageInfo, warnings []Warning) {
+	writeAPIResponse(w, status, APIResponse{
+		RequestID: requestIDFrom(r.Context()),
+		Data:      data,
+		Page:      page,
+		Warnings:  warnings,
+	})
+}
+
+// 回傳錯誤的 JSON 結果
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	status, apiError := classifyError(err)
+	if status == http.StatusInternalServerError {
+		// 客戶端只看到通用訊息，完整的錯誤以 request ID 對應
+		serverLog.ErrorContext(r.Context(), "內部錯誤", "path", r.URL.Path, "error", err)
+	}
+	setRetryAfter(w, status, err)
+	writeAPIResponse(w, status, APIResponse{
+		RequestID: requestIDFrom(r.Context()),
+		Error:     &apiError,
+	})
+}
+
+// 回傳 405，Allow 標頭列出可用的方法
+func writeMethodNotAllowed(w http.ResponseWriter, r *http.Request, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	writeError(w, r, fmt.Errorf("%w，只接受 %s 請求", errMethodNotAllowed, strings.Join(allowed, "、")))
+}
+
+// 路由只接受 allowed 方法時，其他方法由此回傳 405
+func methodNotAllowedHandler(allowed ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writeMethodNotAllowed(w, r, allowed...)
+	}
+}
+
+// 429、503 加上 Retry-After，錯誤有估計的等待時間時使用它，否則 10 秒
+func setRetryAfter(w http.ResponseWriter, status int, err error) {
+	if status != http.StatusTooManyRequests && status != http.StatusServiceUnavailable {
+		return
+	}
+	seconds := 10
+	var retryError *RetryAfterError
+	if errors.As(err, &retryError) {
+		seconds = retryAfterSeconds(retryError.RetryAfter)
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+}
+
+func writeAPIResponse(w http.ResponseWriter, status int, response APIResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(response)
+}
+
+type requestIDKey struct{}
+
+// 客戶端帶的 X-Request-ID 只接受英數、"-"、"_"，避免把任意內容寫進回應標頭與 log
+var requestIDRe = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// withRequestID 為每個請求產生 request ID(或沿用 X-Request-ID)，放進 context 與回應標頭
+func withRequestID(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !requestIDRe.MatchString(id) {
+			id = newRandomID()
+		}
+		w.Header().Set("X-Request-ID", id)
+		next(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	}
+}
+
+// 取出 request ID，沒有時回傳空字串
+func requestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMethodNotAllowed(t *testing.T) {
+	server := &Server{}
+	handlers := map[string]http.HandlerFunc{
+		"/filter":           server.filterHandler,
+		"/products/daf/1_2": server.productHandler,
+		"/sizes/recommend":  server.sizeRecommendHandler,
+		"/params":           server.paramsHandler,
+		"/searches":         methodNotAllowedHandler(http.MethodPost),
+	}
+	for path, handler := range handlers {
+		recorder := httptest.NewRecorder()
+		withRequestID(handler)(recorder, httptest.NewRequest(http.MethodDelete, path, nil))
+
+		if recorder.Code != http.StatusMethodNotAllowed {
+			t.Errorf("%s: 狀態碼 %d，應為 405", path, recorder.Code)
+		}
+		if recorder.Header().Get("Allow") == "" {
+			t.Errorf("%s: 沒有 Allow 標頭", path)
+		}
+		var response APIResponse
+		if err := json.NewDecoder(recorder.Body).Decode(&response); err != nil {
+			t.Fatalf("%s: 回應不是 JSON: %v", path, err)
+		}
+		if response.Error == nil || response.Error.Code != errCodeMethodNotAllowed || response.RequestID == "" {
+			t.Errorf("%s: 回應 %+v", path, response)
+		}
+	}
+}
+
+func TestWithRequestID(t *testing.T) {
+	tests := []struct {
+		header string
+		keep   bool
+	}{
+		{header: "abc-123_XYZ", keep: true},
+		{header: "", keep: false},
+		{header: "bad id", keep: false},
+		{header: "id\r\nSet-Cookie: x=1", keep: false},
+		{header: `{"level":"ERROR"}`, keep: false},
+		{header: "請求", keep: false},
+		{header: string(make([]byte, 65)), keep: false},
+	}
+	for _, test := range tests {
+		var got string
+		handler := withRequestID(func(w http.ResponseWriter, r *http.Request) {
+			got = requestIDFrom(r.Context())
+		})
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.Header["X-Request-Id"] = []string{test.header}
+		recorder := httptest.NewRecorder()
+		handler(recorder, request)
+
+		if test.keep && got != test.header {
+			t.Errorf("%q 應沿用，得到 %q", test.header, got)
+		}
+		if !test.keep && (got == test.header || !requestIDRe.MatchString(got)) {
+			t.Errorf("%q 應另外產生，得到 %q", test.header, got)
+		}
+		if recorder.Header().Get("X-Request-ID") != got {
+			t.Errorf("回應標頭 %q 與 context %q 不同", recorder.Header().Get("X-Request-ID"), got)
+		}
+	}
+}
+
+func TestInternalErrorHidesDetails(t *testing.T) {
+	logs := captureLog(t, &serverLog, logServer)
+	err := fmt.Errorf("讀取 /var/cache/shoes/index.db 失敗: %w", errors.New("permission denied"))
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodGet, "/filter?store=daf", nil)
+	request.Header.Set("X-Request-ID", "req-internal-1")
+	withRequestID(func(w http.ResponseWriter, r *http.Request) { writeError(w, r, err) })(recorder, request)
+
+	var response APIResponse
+	if err := json.NewDecoder(recorder.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if recorder.Code != http.StatusInternalServerError || response.Error == nil || response.Error.Code != errCodeInternal {
+		t.Fatalf("應回傳 500 internal_error，得到 %d %+v", recorder.Code, response.Error)
+	}
+	// 客戶端只看到通用訊息，原始錯誤與 request ID 記在 log
+	if response.Error.Message != internalErrorMessage || response.RequestID != "req-internal-1" {
+		t.Errorf("回應 %+v", response)
+	}
+	if output := logs.String(); !strings.Contains(output, "permission denied") || !strings.Contains(output, `"requestId":"req-internal-1"`) {
+		t.Errorf("log 應包含完整錯誤與 request ID: %s", output)
+	}
+
+	// 其他錯誤代碼維持原本的訊息
+	if _, apiError := classifyError(newParamError("page", errors.New("應為正整數"))); apiError.Message == internalErrorMessage {
+		t.Errorf("參數錯誤不應改成通用訊息: %+v", apiError)
+	}
+	if warning := newWarning("anns", "1", fmt.Errorf("%w(商品 1): index out of range", errPanic)); warning.Message != internalErrorMessage {
+		t.Errorf("警告也不應帶出內部錯誤: %+v", warning)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// 前端的 JavaScript、CSS 與圖片直接編進執行檔，開發與正式環境使用同一份
+//
+//go:embed statics scripts css
+var embeddedAssets embed.FS
+
+// 伺服器端渲染的頁面模板，啟動時解析一次，不當靜態檔案提供
+//
+//go:embed templates
+var embeddedTemplates embed.FS
+
+// assetServer 提供內嵌的靜態檔案。網址帶有與內容雜湊相符的 ?v= 時可長期快取，否則每次都要以 ETag 確認
+type assetServer struct {
+	files fs.FS
+	// 檔案路徑(如 scripts/daf.js)對應內容雜湊
+	hashes map[string]string
+}
+
+// 計算所有檔案的內容雜湊
+func newAssetServer(files fs.FS) (*assetServer, error) {
+	assets := &assetServer{files: files, hashes: map[string]string{}}
+	err := fs.WalkDir(files, ".", func(name string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		content, err := fs.ReadFile(files, name)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(content)
+		assets.hashes[name] = hex.EncodeToString(sum[:])[:12]
+		return nil
+	})
+	return assets, err
+}
+
+// 模板用的網址，例如 {{asset "/scripts/daf.js"}} 產生 /scripts/daf.js?v=內容雜湊；找不到檔案時原樣回傳
+func (assets *assetServer) url(name string) string {
+	hash, ok := assets.hashes[strings.TrimPrefix(name, "/")]
+	if !ok {
+		return name
+	}
+	return name + "?v=" + hash
+}
+
+// 解析頁面模板，模板內可用 asset 函式取得帶雜湊的網址
+func (assets *assetServer) parseTemplate(name string) (*template.Template, error) {
+	return template.New(path.Base(name)).
+		Funcs(template.FuncMap{"asset": assets.url}).
+		ParseFS(embeddedTemplates, name)
+}
+
+// 處理 /statics/、/scripts/、/css/ 的請求
+func (assets *assetServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	name := strings.TrimPrefix(path.Clean(r.URL.Path), "/")
+	hash, ok := assets.hashes[name]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	content, err := fs.ReadFile(assets.files, name)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.URL.Query().Get("v") == hash {
+		// 內容改變時雜湊(網址)也會改變，可以放心長期快取
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		w.Header().Set("Cache-Control", "no-cache")
+	}
+	w.Header().Set("ETag", `"`+hash+`"`)
+	// ServeContent 依副檔名設定 Content-Type，並處理 If-None-Match 與 Range
+	http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(content))
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// StyleAttributes 從商品標題、描述中解析出的款式屬性，解析不到的欄位留空
+type StyleAttributes struct {
+	Toe        string   `json:"toe,omitempty"`
+	Closure    []string `json:"closure,omitempty"`
+	Material   []string `json:"material,omitempty"`
+	HeelCm     *float64 `json:"heelCm,omitempty"`
+	Waterproof bool     `json:"waterproof,omitempty"`
+}
+
+// 屬性值與對應的關鍵字，關鍵字需為 normalizeText 之後的形式
+type attributeKeywords struct {
+	Value    string
+	Keywords []string
+}
+
+// 鞋頭形狀，依序比對，先符合者優先
+var toeKeywords = []attributeKeywords{
+	{Value: "pointed", Keywords: []string{"尖頭", "尖楦"}},
+	{Value: "square", Keywords: []string{"方頭", "方楦"}},
+	{Value: "open", Keywords: []string{"露趾", "魚口"}},
+	{Value: "round", Keywords: []string{"圓頭", "圓楦", "圓弧頭"}},
+}
+
+// 穿脫方式
+var closureKeywords = []attributeKeywords{
+	{Value: "laceUp", Keywords: []string{"綁帶", "繫帶", "鞋帶", "綁繩"}},
+	{Value: "slipOn", Keywords: []string{"懶人", "套腳", "一腳蹬", "穆勒", "樂福"}},
+	{Value: "buckle", Keywords: []string{"扣帶", "釦帶", "扣環", "釦環", "瑪莉珍"}},
+	{Value: "zipper", Keywords: []string{"拉鍊", "拉鏈"}},
+	{Value: "velcro", Keywords: []string{"魔鬼氈", "黏扣"}},
+	{Value: "elastic", Keywords: []string{"鬆緊"}},
+}
+
+// 材質
+var materialKeywords = []attributeKeywords{
+	{Value: "leather", Keywords: []string{"真皮", "牛皮", "羊皮"}},
+	{Value: "suede", Keywords: []string{"麂皮", "絨面", "反毛皮"}},
+	{Value: "patent", Keywords: []string{"漆皮", "鏡面"}},
+	{Value: "canvas", Keywords: []string{"帆布"}},
+	{Value: "knit", Keywords: []string{"針織", "飛織"}},
+	{Value: "mesh", Keywords: []string{"網布", "網面"}},
+	{Value: "synthetic", Keywords: []string{"合成皮", "人造皮"}},
+	{Value: "wool", Keywords: []string{"毛呢", "羊毛"}},
+}
+
+// 防水
+var waterproofKeywords = []string{"防水", "防潑水", "雨靴", "雨鞋"}
+
+// 跟高: 「跟高5cm」「5公分粗跟」，數字前後要有「跟」，避免把靴筒高度、鞋墊長度當成跟高
+var (
+	heelBeforeRe = regexp.MustCompile(`跟[^\d]{0,4}(\d+(?:\.\d+)?)\s*(?:cm|公分)`)
+	heelAfterRe  = regexp.MustCompile(`(\d+(?:\.\d+)?)\s*(?:cm|公分)[^\d]{0,3}跟`)
+)
+
+// 超過此高度的不會是跟高(例如「高跟靴筒30cm」抓到的是靴筒高度)
+const maxHeelCm = 15
+
+// 平底鞋沒有標跟高時視為 1 公分
+const flatHeelCm = 1.0
+
+// 從商品標題與描述中解析款式屬性
+func extractStyleAttributes(texts ...string) StyleAttributes {
+
+	text := normalizeText(strings.Join(texts, " "))
+	var attributes StyleAttributes
+
+	for _, toe := range toeKeywords {
+		if containsAny(text, toe.Keywords) {
+			attributes.Toe = toe.Value
+			break
+		}
+	}
+	for _, closure := range closureKeywords {
+		if containsAny(text, closure.Keywords) {
+			attributes.Closure = append(attributes.Closure, closure.Value)
+		}
+	}
+	for _, material := range materialKeywords {
+		if containsAny(text, material.Keywords) {
+			attributes.Material = append(attributes.Material, material.Value)
+		}
+	}
+	attributes.Waterproof = containsAny(text, waterproofKeywords)
+	attributes.HeelCm = extractHeelCm(text)
+
+	return attributes
+}
+
+// 解析跟高(cm)
+func extractHeelCm(text string) *float64 {
+	for _, re := range []*regexp.Regexp{heelBeforeRe, heelAfterRe} {
+		for _, match := range re.FindAllStringSubmatch(text, -1) {
+			heel, err := strconv.ParseFloat(match[1], 64)
+			if err == nil && heel <= maxHeelCm {
+				return &heel
+			}
+		}
+	}
+	if strings.Contains(text, "平底") {
+		heel := flatHeelCm
+		return &heel
+	}
+	return nil
+}
+
+// 合併兩次解析的結果，first 已有的值優先
+func mergeStyleAttributes(first, second StyleAttributes) StyleAttributes {
+	if first.Toe == "" {
+		first.Toe = second.Toe
+	}
+	first.Closure = mergeUnique(first.Closure, second.Closure)
+	first.Material = mergeUnique(first.Material, second.Material)
+	if first.HeelCm == nil {
+		first.HeelCm = second.HeelCm
+	}
+	first.Waterproof = first.Waterproof || second.Waterproof
+	return first
+}
+
+func containsAny(text string, keywords []string) bool {
+	for _, keyword := range keywords {
+		if strings.Contains(text, keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+// AttributeFilter 款式屬性篩選條件，同一欄位為 OR、不同欄位為 AND
+type AttributeFilter struct {
+	Toes       []string
+	Closures   []string
+	Materials  []string
+	Waterproof *bool
+	HeelMin    *float64
+	HeelMax    *float64
+	// 跟高條件是否不含等號(heelCm<3、heelCm>3)
+	HeelMinExclusive bool
+	HeelMaxExclusive bool
+}
+
+// 跟高條件可寫成 heelCm<=3、heelCm>=3、heelCm<3、heelCm>3、heelCm=3，或 heelCmMin=3&heelCmMax=5
+var heelConditionRe = regexp.MustCompile(`^heelCm(<=|>=|<|>|=)(\d+(?:\.\d+)?)$`)
+
+// 從 query string 取出款式屬性篩選條件
+func parseAttributeFilter(query url.Values) (AttributeFilter, error) {
+
+	filter := AttributeFilter{
+		Toes:      splitMultiValue(query["toe"]),
+		Closures:  splitMultiValue(query["closure"]),
+		Materials: splitMultiValue(query["material"]),
+	}
+
+	if !allKnown(filter.Toes, toeKeywords) {
+		return filter, fmt.Errorf("toe 應為 %s", knownValues(toeKeywords))
+	}
+	if !allKnown(filter.Closures, closureKeywords) {
+		return filter, fmt.Errorf("closure 應為 %s", knownValues(closureKeywords))
+	}
+	if !allKnown(filter.Materials, materialKeywords) {
+		return filter, fmt.Errorf("material 應為 %s", knownValues(materialKeywords))
+	}
+
+	if value := query.Get("waterproof"); value != "" {
+		waterproof, err := strconv.ParseBool(value)
+		if err != nil {
+			return filter, fmt.Errorf("waterproof 應為 true 或 false")
+		}
+		filter.Waterproof = &waterproof
+	}
+
+	// url.Values 以第一個 "=" 拆開 key 與 value: heelCm<=3 變成 key "heelCm<"、value "3"，
+	// heelCm<3 則整段都是 key、value 為空；依 key 把條件接回去再解析，依 key 排序讓結果固定
+	for _, key := range sortedKeys(query) {
+		if !strings.HasPrefix(key, "heelCm") {
+			continue
+		}
+		for _, value := range query[key] {
+			var condition string
+			switch {
+			case key == "heelCmMin":
+				condition = "heelCm>=" + value
+			case key == "heelCmMax":
+				condition = "heelCm<=" + value
+			case key == "heelCm<" || key == "heelCm>" || key == "heelCm":
+				condition = key + "=" + value
+			case value == "":
+				condition = key
+			default:
+				condition = key + "=" + value
+			}
+			if err := filter.addHeelCondition(condition); err != nil {
+				return filter, err
+			}
+		}
+	}
+
+	return filter, nil
+}
+
+func (filter *AttributeFilter) addHeelCondition(condition string) error {
+	match := heelConditionRe.FindStringSubmatch(condition)
+	if match == nil {
+		return fmt.Errorf("跟高條件格式錯誤: %s，例如 heelCm<=3", condition)
+	}
+	heel, _ := strconv.ParseFloat(match[2], 64)
+	switch match[1] {
+	case "<=", "<":
+		filter.HeelMax = &heel
+		filter.HeelMaxExclusive = match[1] == "<"
+	case ">=", ">":
+		filter.HeelMin = &heel
+		filter.HeelMinExclusive = match[1] == ">"
+	case "=":
+		filter.HeelMin = &heel
+		filter.HeelMax = &heel
+		filter.HeelMinExclusive = false
+		filter.HeelMaxExclusive = false
+	}
+	return nil
+}
+
+// 是否有任何屬性篩選條件
+func (filter AttributeFilter) isEmpty() bool {
+	return len(filter.Toes) == 0 && len(filter.Closures) == 0 && len(filter.Materials) == 0 &&
+		filter.Waterproof == nil && filter.HeelMin == nil && filter.HeelMax == nil
+}
+
+// 款式屬性篩選，沒解析出該屬性的鞋子視為不符合
+func filterShoesByAttributes(shoes []Shoe, filter AttributeFilter) []Shoe {
+	if filter.isEmpty() {
+		return shoes
+	}
+	filteredShoes := []Shoe{}
+	for _, shoe := range shoes {
+		if filter.matches(shoe.Attributes) {
+			filteredShoes = append(filteredShoes, shoe)
+		}
+	}
+	return filteredShoes
+}
+
+func (filter AttributeFilter) matches(attributes StyleAttributes) bool {
+	if len(filter.Toes) > 0 && !containsString(filter.Toes, attributes.Toe) {
+		return false
+	}
+	if len(filter.Closures) > 0 && !containsAnyString(filter.Closures, attributes.Closure) {
+		return false
+	}
+	if len(filter.Materials) > 0 && !containsAnyString(filter.Materials, attributes.Material) {
+		return false
+	}
+	if filter.Waterproof != nil && *filter.Waterproof != attributes.Waterproof {
+		return false
+	}
+	if filter.HeelMin != nil || filter.HeelMax != nil {
+		if attributes.HeelCm == nil {
+			return false
+		}
+		heel := *attributes.HeelCm
+		if filter.HeelMin != nil && (heel < *filter.HeelMin || (filter.HeelMinExclusive && heel == *filter.HeelMin)) {
+			return false
+		}
+		if filter.HeelMax != nil && (heel > *filter.HeelMax || (filter.HeelMaxExclusive && heel == *filter.HeelMax)) {
+			return false
+		}
+	}
+	return true
+}
+
+func containsString(values []string, target string) bool {
+	for _, value := range values {
+		if value == target {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAnyString(values []string, targets []string) bool {
+	for _, target := range targets {
+		if containsString(values, target) {
+			return true
+		}
+	}
+	return false
+}
+
+func allKnown(values []string, dictionary []attributeKeywords) bool {
+	for _, value := range values {
+		known := false
+		for _, entry := range dictionary {
+			if entry.Value == value {
+				known = true
+			}
+		}
+		if !known {
+			return false
+		}
+	}
+	return true
+}
+
+func knownValues(dictionary []attributeKeywords) string {
+	var values []string
+	for _, entry := range dictionary {
+		values = append(values, entry.Value)
+	}
+	return strings.Join(values, "、")
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func float64Pointer(value float64) *float64 {
+	return &value
+}
+
+func TestExtractHeelCm(t *testing.T) {
+	tests := []struct {
+		text string
+		want *float64
+	}{
+		{text: "跟高5cm", want: float64Pointer(5)},
+		{text: "跟高 3.5 公分", want: float64Pointer(3.5)},
+		{text: "7公分粗跟", want: float64Pointer(7)},
+		{text: "6cm細跟", want: float64Pointer(6)},
+		{text: "平底樂福鞋", want: float64Pointer(flatHeelCm)},
+		{text: "平底鞋 跟高2cm", want: float64Pointer(2)},
+		// 沒有「跟」的長度不是跟高
+		{text: "靴筒高 12cm", want: nil},
+		{text: "鞋墊長 25cm 穆勒鞋", want: nil},
+		{text: "高跟靴筒30cm", want: nil},
+		{text: "尖頭瑪莉珍", want: nil},
+	}
+	for _, test := range tests {
+		got := extractHeelCm(normalizeText(test.text))
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("extractHeelCm(%q) = %v，應為 %v", test.text, formatHeel(got), formatHeel(test.want))
+		}
+	}
+}
+
+func formatHeel(heel *float64) interface{} {
+	if heel == nil {
+		return nil
+	}
+	return *heel
+}
+
+func TestExtractStyleAttributes(t *testing.T) {
+	tests := []struct {
+		texts []string
+		want  StyleAttributes
+	}{
+		{
+			texts: []string{"真皮尖頭綁帶短靴", "側邊拉鍊 防潑水"},
+			want:  StyleAttributes{Toe: "pointed", Closure: []string{"laceUp", "zipper"}, Material: []string{"leather"}, Waterproof: true},
+		},
+		{
+			// 方頭、圓頭都有時依字典順序取第一個
+			texts: []string{"方頭瑪莉珍 圓頭款", "麂皮 鬆緊"},
+			want:  StyleAttributes{Toe: "square", Closure: []string{"buckle", "elastic"}, Material: []string{"suede"}},
+		},
+		{
+			// 全形英數與大寫先正規化
+			texts: []string{"ＰＶＣ雨靴　魚口", "跟高３ＣＭ"},
+			want:  StyleAttributes{Toe: "open", Waterproof: true, HeelCm: float64Pointer(3)},
+		},
+		{texts: []string{"針織飛織 懶人鞋"}, want: StyleAttributes{Closure: []string{"slipOn"}, Material: []string{"knit"}}},
+		{texts: []string{"經典款"}, want: StyleAttributes{}},
+	}
+	for _, test := range tests {
+		if got := extractStyleAttributes(test.texts...); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("extractStyleAttributes(%q) = %+v，應為 %+v", test.texts, got, test.want)
+		}
+	}
+}
+
+// 每個關鍵字都要是 normalizeText 之後的形式，且只屬於一個值
+func TestAttributeKeywords(t *testing.T) {
+	for name, dictionary := range map[string][]attributeKeywords{
+		"toe":      toeKeywords,
+		"closure":  closureKeywords,
+		"material": materialKeywords,
+	} {
+		seen := map[string]string{}
+		for _, entry := range dictionary {
+			for _, keyword := range entry.Keywords {
+				if normalizeText(keyword) != keyword {
+					t.Errorf("%s 的關鍵字 %q 不是正規化後的形式", name, keyword)
+				}
+				if value, ok := seen[keyword]; ok {
+					t.Errorf("%s 的關鍵字 %q 同時屬於 %s 與 %s", name, keyword, value, entry.Value)
+				}
+				seen[keyword] = entry.Value
+			}
+		}
+	}
+}
+
+func TestParseAttributeFilter(t *testing.T) {
+	tests := []struct {
+		query        string
+		min, max     *float64
+		minExclusive bool
+		maxExclusive bool
+		wantErr      bool
+	}{
+		{query: "heelCm<=3", max: float64Pointer(3)},
+		{query: "heelCm>=3", min: float64Pointer(3)},
+		{query: "heelCm<3", max: float64Pointer(3), maxExclusive: true},
+		{query: "heelCm>3.5", min: float64Pointer(3.5), minExclusive: true},
+		{query: "heelCm=3", min: float64Pointer(3), max: float64Pointer(3)},
+		{query: "heelCmMin=3&heelCmMax=5", min: float64Pointer(3), max: float64Pointer(5)},
+		{query: "heelCm>1&heelCm<=5", min: float64Pointer(1), max: float64Pointer(5), minExclusive: true},
+		// 瀏覽器把運算子編碼時整段都在 key
+		{query: "heelCm%3C%3D3", max: float64Pointer(3)},
+		{query: "heelCm%3E3", min: float64Pointer(3), minExclusive: true},
+		{query: "heelCm<=", wantErr: true},
+		{query: "heelCm<==3", wantErr: true},
+		{query: "heelCm=<3", wantErr: true},
+		{query: "heelCm<3=5", wantErr: true},
+		{query: "heelCm~3", wantErr: true},
+		{query: "heelCmMin=abc", wantErr: true},
+		{query: "heelCmFoo=3", wantErr: true},
+	}
+	for _, test := range tests {
+		query, err := url.ParseQuery(test.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		filter, err := parseAttributeFilter(query)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%q: 錯誤 %v", test.query, err)
+			continue
+		}
+		if test.wantErr {
+			continue
+		}
+		if !reflect.DeepEqual(filter.HeelMin, test.min) || !reflect.DeepEqual(filter.HeelMax, test.max) ||
+			filter.HeelMinExclusive != test.minExclusive || filter.HeelMaxExclusive != test.maxExclusive {
+			t.Errorf("%q: 得到 min=%v(%v) max=%v(%v)", test.query,
+				formatHeel(filter.HeelMin), filter.HeelMinExclusive, formatHeel(filter.HeelMax), filter.HeelMaxExclusive)
+		}
+	}
+}
+
+func TestParseAttributeFilterKeywords(t *testing.T) {
+	query, _ := url.ParseQuery("toe=pointed,round&closure=zipper&material=leather&material=suede&waterproof=true")
+	filter, err := parseAttributeFilter(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(filter.Toes, []string{"pointed", "round"}) || !reflect.DeepEqual(filter.Materials, []string{"leather", "suede"}) ||
+		filter.Waterproof == nil || !*filter.Waterproof {
+		t.Errorf("得到 %+v", filter)
+	}
+
+	for _, raw := range []string{"toe=oval", "closure=button", "material=gold", "waterproof=maybe"} {
+		query, _ := url.ParseQuery(raw)
+		if _, err := parseAttributeFilter(query); err == nil {
+			t.Errorf("%q 應回傳錯誤", raw)
+		}
+	}
+}
+
+func TestAttributeFilterMatches(t *testing.T) {
+	heel := func(cm float64) StyleAttributes { return StyleAttributes{HeelCm: float64Pointer(cm)} }
+	tests := []struct {
+		query      string
+		attributes StyleAttributes
+		want       bool
+	}{
+		{query: "heelCm<=3", attributes: heel(3), want: true},
+		{query: "heelCm<3", attributes: heel(3), want: false},
+		{query: "heelCm<3", attributes: heel(2.9), want: true},
+		{query: "heelCm>=3", attributes: heel(3), want: true},
+		{query: "heelCm>3", attributes: heel(3), want: false},
+		{query: "heelCm=3", attributes: heel(3), want: true},
+		{query: "heelCm=3", attributes: heel(3.5), want: false},
+		{query: "heelCmMin=3&heelCmMax=5", attributes: heel(5), want: true},
+		{query: "heelCmMin=3&heelCmMax=5", attributes: heel(5.5), want: false},
+		// 沒解析出跟高的鞋子視為不符合
+		{query: "heelCm<=3", attributes: StyleAttributes{}, want: false},
+		{query: "toe=pointed,round", attributes: StyleAttributes{Toe: "round"}, want: true},
+		{query: "toe=pointed&closure=zipper", attributes: StyleAttributes{Toe: "pointed", Closure: []string{"laceUp"}}, want: false},
+		{query: "material=leather", attributes: StyleAttributes{Material: []string{"suede", "leather"}}, want: true},
+		{query: "waterproof=false", attributes: StyleAttributes{Waterproof: true}, want: false},
+	}
+	for _, test := range tests {
+		query, _ := url.ParseQuery(test.query)
+		filter, err := parseAttributeFilter(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := filter.matches(test.attributes); got != test.want {
+			t.Errorf("%q 比對 %+v = %v，應為 %v", test.query, test.attributes, got, test.want)
+		}
+	}
+}
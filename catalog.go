@@ -0,0 +1,83 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// catalogEntry 爬列表時順便記下、但 Shoe 放不下的商品資訊(全部圖片、原價、促銷、分類)
+type catalogEntry struct {
+	Store        string
+	ListID       string
+	Images       []string
+	SuggestPrice string
+	Promotions   []Promotion
+	Category     string
+	RefreshedAt  time.Time
+}
+
+const (
+	// 商品目錄與搜尋索引最多保存的商品數
+	catalogSize = 20000
+	// 超過此時間沒再爬到的商品視為已下架，不再查得到
+	catalogTTL = 7 * 24 * time.Hour
+)
+
+// productCatalog 以 store/ListID 為 key 保存最近一次爬到的商品資訊，供單一商品 API 使用；滿了時先清掉過期的，仍然太多再清掉最舊的
+type productCatalog struct {
+	mu      sync.RWMutex
+	entries map[string]*list.Element
+	// 依刷新時間排列的 catalogEntry，最舊的在尾端，清除時只需從尾端移除
+	order *list.List
+}
+
+func newProductCatalog() *productCatalog {
+	return &productCatalog{entries: map[string]*list.Element{}, order: list.New()}
+}
+
+func catalogKey(store, listID string) string {
+	return store + "/" + listID
+}
+
+// remember 記錄(或覆蓋)一筆商品資訊，並更新最後刷新時間
+func (c *productCatalog) remember(entry catalogEntry) {
+	entry.RefreshedAt = time.Now()
+	key := catalogKey(entry.Store, entry.ListID)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, exists := c.entries[key]; exists {
+		element.Value = entry
+		c.order.MoveToFront(element)
+	} else {
+		c.entries[key] = c.order.PushFront(entry)
+	}
+	c.evict()
+}
+
+// 從最舊的一端清掉過期的商品，仍然超過 catalogSize 時繼續清掉最舊的；呼叫時須持有寫入鎖
+func (c *productCatalog) evict() {
+	for oldest := c.order.Back(); oldest != nil; oldest = c.order.Back() {
+		entry := oldest.Value.(catalogEntry)
+		if len(c.entries) <= catalogSize && time.Since(entry.RefreshedAt) < catalogTTL {
+			return
+		}
+		c.order.Remove(oldest)
+		delete(c.entries, catalogKey(entry.Store, entry.ListID))
+	}
+}
+
+// lookup 取出未過期的商品資訊，沒有爬過或太久沒爬到則回傳 false
+func (c *productCatalog) lookup(store, listID string) (catalogEntry, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	element, ok := c.entries[catalogKey(store, listID)]
+	if !ok {
+		return catalogEntry{}, false
+	}
+	entry := element.Value.(catalogEntry)
+	if time.Since(entry.RefreshedAt) >= catalogTTL {
+		return catalogEntry{}, false
+	}
+	return entry, true
+}
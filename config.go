@@ -0,0 +1,493 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config 伺服器設定，先依 GO_ENV 取預設值，再讀設定檔(CONFIG_FILE)，最後套用環境變數
+type Config struct {
+	// 環境名稱，會傳給首頁模板
+	Environment string `json:"environment"`
+	// 監聽位址，例如 :8080
+	ListenAddr string `json:"listenAddr"`
+	// 向商店發請求用的 CA 憑證，空字串表示使用系統預設
+	CABundle string `json:"caBundle"`
+	// 向商店發請求的逾時時間
+	HTTPTimeout Duration `json:"httpTimeout"`
+	// 啟用的店鋪，未啟用的店鋪視同未知的商店
+	EnabledStores []string `json:"enabledStores"`
+	// 單一商品詳細資訊快取時間
+	ProductDetailTTL Duration `json:"productDetailTTL"`
+	// 收到 SIGTERM 後等待進行中的請求與搜尋工作完成的期限，須短於平台強制結束前的等待時間
+	ShutdownTimeout Duration `json:"shutdownTimeout"`
+	// 商店定義(*.json)所在的目錄，其中的店鋪一樣要列在 enabledStores 才會啟用
+	StoreDefinitionsDir string `json:"storeDefinitionsDir"`
+
+	Log       LogConfig       `json:"log"`
+	Search    SearchConfig    `json:"search"`
+	RateLimit RateLimitConfig `json:"rateLimit"`
+	CORS      CORSConfig      `json:"cors"`
+	Security  SecurityConfig  `json:"security"`
+	Render    RenderConfig    `json:"render"`
+	DAF       DAFConfig       `json:"daf"`
+	Anns      AnnsConfig      `json:"anns"`
+
+	// 從 StoreDefinitionsDir 讀取的商店定義，以店鋪代碼為 key
+	storeDefinitions map[string]*StoreDefinition
+}
+
+// LogConfig JSON log 的等級
+type LogConfig struct {
+	// 預設等級: debug、info、warn 或 error
+	Level string `json:"level"`
+	// 個別子系統(server、search、daf、anns、scraper、product、upstream、render)的等級，覆寫預設等級
+	Levels map[string]string `json:"levels"`
+}
+
+// SearchConfig 搜尋工作佇列
+type SearchConfig struct {
+	// 同時執行的搜尋工作數
+	Workers int `json:"workers"`
+	// 排隊中的搜尋工作上限，超過就拒絕
+	QueueSize int `json:"queueSize"`
+	// 完成的搜尋工作保留多久
+	JobRetention Duration `json:"jobRetention"`
+	// 相同條件的搜尋在多久內直接沿用已完成的結果，0 表示只合併執行中的搜尋
+	ResultTTL Duration `json:"resultTTL"`
+}
+
+// RateLimitConfig 每個客戶端發起新搜尋的頻率限制，沿用既有結果與翻頁不受限制
+type RateLimitConfig struct {
+	// 每分鐘補充的次數，0 表示不限制
+	PerMinute int `json:"perMinute"`
+	// 短時間內最多可連續發起的次數
+	Burst int `json:"burst"`
+	// 取得客戶端 IP 的標頭，空字串或請求沒帶時使用連線位址；
+	// 客戶端可以自己帶任何標頭，只有部署在會覆寫此標頭的 proxy 後面(例如 fly.io 的 Fly-Client-IP)才能設定
+	ClientIPHeader string `json:"clientIPHeader"`
+	// 不受限制的內部客戶端，IP 或 CIDR
+	Exempt []string `json:"exempt"`
+}
+
+// CORSConfig 允許哪些網站以瀏覽器跨域呼叫 API
+type CORSConfig struct {
+	// 允許的來源，例如 https://example.com，"*" 表示所有來源
+	AllowedOrigins []string `json:"allowedOrigins"`
+	// 預檢時允許的方法與請求標頭
+	AllowedMethods []string `json:"allowedMethods"`
+	AllowedHeaders []string `json:"allowedHeaders"`
+	// 瀏覽器快取預檢結果的時間
+	MaxAge Duration `json:"maxAge"`
+}
+
+// SecurityConfig 所有回應都加上的安全標頭，空字串表示不加
+type SecurityConfig struct {
+	ContentSecurityPolicy string `json:"contentSecurityPolicy"`
+	ReferrerPolicy        string `json:"referrerPolicy"`
+}
+
+// DAFConfig D+AF 爬蟲
+type DAFConfig struct {
+	// 爬取方式: builtin(daf.go) 或 definition(內建的商店定義 stores/daf.json)
+	Engine  string `json:"engine"`
+	BaseURL string `json:"baseURL"`
+	// 多選時最多展開幾組上游查詢
+	MaxQueries int `json:"maxQueries"`
+	// 同時請求的列表頁數、商品頁數
+	ListPageWorkers int `json:"listPageWorkers"`
+	DetailWorkers   int `json:"detailWorkers"`
+	// 各端點是否改用無頭瀏覽器取得頁面
+	Render StoreRenderConfig `json:"render"`
+}
+
+// AnnsConfig Ann's 爬蟲
+type AnnsConfig struct {
+	// 商品列表 GraphQL API
+	GraphQLURL string `json:"graphqlURL"`
+	// 單一商品 API，後面接 SalePageId
+	SalePageAPIURL string `json:"salePageAPIURL"`
+	// 商品頁網址，後面接 SalePageId
+	SalePageURL string `json:"salePageURL"`
+	// 庫存 API
+	StockAPIURL string `json:"stockAPIURL"`
+	// 同時請求的商品數
+	DetailWorkers int `json:"detailWorkers"`
+	// 庫存 API 一次最多帶幾個 SKU，與同時送出的批次數
+	StockBatchSize   int `json:"stockBatchSize"`
+	StockConcurrency int `json:"stockConcurrency"`
+	// 各端點是否改用無頭瀏覽器取得頁面
+	Render StoreRenderConfig `json:"render"`
+}
+
+// RenderConfig 無頭瀏覽器，只有店鋪的端點設定為 fallback 或 always 時才會啟動
+type RenderConfig struct {
+	// Chromium 執行檔，空字串表示自動尋找
+	BrowserPath string `json:"browserPath"`
+	// 同時開啟的分頁數
+	Pages int `json:"pages"`
+	// 每頁從開啟到渲染完成的期限
+	PageTimeout Duration `json:"pageTimeout"`
+	// 不載入的資源類型: image、font、media、stylesheet
+	BlockResources []string `json:"blockResources"`
+}
+
+// StoreRenderConfig 店鋪各端點取得頁面的方式: off(只用 HTTP)、fallback(HTTP 解析不到尺碼時改用瀏覽器)、always
+type StoreRenderConfig struct {
+	// 商品頁(尺碼、顏色)
+	Detail string `json:"detail"`
+}
+
+// Duration 設定檔中的時間長度，寫成 "30m"、"10s" 這類字串
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var value string
+	if err := json.Unmarshal(b, &value); err != nil {
+		return fmt.Errorf("時間長度應為字串，例如 \"30s\"")
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	d.Duration = duration
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// 支援的店鋪
+var knownStores = []string{"daf", "anns"}
+
+// 依環境取得預設設定；release 使用 Docker 映像檔內的 CA 憑證
+func defaultConfig(environment string) Config {
+	config := Config{
+		Environment:      environment,
+		ListenAddr:       ":8080",
+		HTTPTimeout:      Duration{30 * time.Second},
+		EnabledStores:    append([]string(nil), knownStores...),
+		ProductDetailTTL: Duration{10 * time.Minute},
+		ShutdownTimeout:  Duration{25 * time.Second},
+		Log:              LogConfig{Level: "info"},
+		Search: SearchConfig{
+			Workers:      2,
+			QueueSize:    32,
+			JobRetention: Duration{30 * time.Minute},
+			ResultTTL:    Duration{5 * time.Minute},
+		},
+		RateLimit: RateLimitConfig{
+			PerMinute: 10,
+			Burst:     5,
+			Exempt:    []string{"127.0.0.0/8", "::1"},
+		},
+		CORS: CORSConfig{
+			AllowedOrigins: []string{"*"},
+			AllowedMethods: []string{http.MethodGet, http.MethodPost},
+			AllowedHeaders: []string{"Content-Type", "X-Request-ID"},
+			MaxAge:         Duration{10 * time.Minute},
+		},
+		Security: SecurityConfig{
+			ContentSecurityPolicy: defaultContentSecurityPolicy,
+			ReferrerPolicy:        "strict-origin-when-cross-origin",
+		},
+		DAF: DAFConfig{
+			Engine:          storeEngineBuiltin,
+			BaseURL:         "https://www.daf-shoes.com/",
+			MaxQueries:      20,
+			ListPageWorkers: 4,
+			DetailWorkers:   8,
+			Render:          StoreRenderConfig{Detail: renderOff},
+		},
+		Anns: AnnsConfig{
+			GraphQLURL:       "https://fts-api.91app.com/pythia-cdn/graphql",
+			SalePageAPIURL:   "https://www.anns.tw/webapi/SalePageV2/GetSalePageV2Info/123/",
+			SalePageURL:      "https://www.anns.tw/SalePage/Index/",
+			StockAPIURL:      "https://www.anns.tw/webapi/ProductStock/GetSellingQtyListNew?v=0&shopId=123&lang=zh-TW",
+			DetailWorkers:    30,
+			StockBatchSize:   100,
+			StockConcurrency: 4,
+			Render:           StoreRenderConfig{Detail: renderOff},
+		},
+		Render: RenderConfig{
+			Pages:          2,
+			PageTimeout:    Duration{20 * time.Second},
+			BlockResources: []string{"image", "font", "media"},
+		},
+	}
+	if environment == "release" {
+		config.CABundle = "/etc/ssl/certs/ca-certificates.crt"
+	}
+	if environment == "debug" {
+		config.Log.Level = "debug"
+	}
+	return config
+}
+
+// loadConfig 讀取並檢查設定，任何一項錯誤都會回傳 error，伺服器不應啟動
+func loadConfig() (Config, error) {
+
+	config := defaultConfig(os.Getenv("GO_ENV"))
+
+	// 設定檔只需寫要覆寫的欄位
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		file, err := os.Open(path)
+		if err != nil {
+			return config, fmt.Errorf("無法讀取設定檔: %w", err)
+		}
+		defer file.Close()
+		decoder := json.NewDecoder(file)
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&config); err != nil {
+			return config, fmt.Errorf("設定檔 %s 格式錯誤: %w", path, err)
+		}
+		serverLog.Info("已讀取設定檔", "path", path)
+	}
+
+	if err := applyEnvOverrides(&config); err != nil {
+		return config, err
+	}
+	definitions, err := loadStoreDefinitions(config.StoreDefinitionsDir)
+	if err != nil {
+		return config, fmt.Errorf("設定錯誤: %w", err)
+	}
+	config.storeDefinitions = definitions
+	if err := config.validate(); err != nil {
+		return config, err
+	}
+	return config, nil
+}
+
+// 環境變數覆寫設定，格式錯誤時回傳 error 而不是默默使用預設值
+func applyEnvOverrides(config *Config) error {
+
+	var errs []error
+	setString := func(name string, target *string) {
+		if value, ok := os.LookupEnv(name); ok {
+			*target = value
+		}
+	}
+	setInt := func(name string, target *int) {
+		if value := os.Getenv(name); value != "" {
+			number, err := strconv.Atoi(value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s 應為整數: %q", name, value))
+				return
+			}
+			*target = number
+		}
+	}
+	// 以逗號分隔的清單，設為空字串表示清空
+	setList := func(name string, target *[]string) {
+		if value, ok := os.LookupEnv(name); ok {
+			*target = nil
+			for _, part := range splitMultiValue([]string{value}) {
+				if part != "" {
+					*target = append(*target, part)
+				}
+			}
+		}
+	}
+	setDuration := func(name string, target *Duration) {
+		if value := os.Getenv(name); value != "" {
+			duration, err := time.ParseDuration(value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s 應為時間長度，例如 30s: %q", name, value))
+				return
+			}
+			target.Duration = duration
+		}
+	}
+
+	// PORT 為 fly.io 等平台慣用的變數，LISTEN_ADDR 優先
+	if port := os.Getenv("PORT"); port != "" {
+		config.ListenAddr = ":" + port
+	}
+	setString("LISTEN_ADDR", &config.ListenAddr)
+	setString("CA_BUNDLE", &config.CABundle)
+	setDuration("HTTP_TIMEOUT", &config.HTTPTimeout)
+	if value := os.Getenv("ENABLED_STORES"); value != "" {
+		config.EnabledStores = splitMultiValue([]string{value})
+	}
+	setDuration("PRODUCT_DETAIL_TTL", &config.ProductDetailTTL)
+	setDuration("SHUTDOWN_TIMEOUT", &config.ShutdownTimeout)
+	setString("STORE_DEFINITIONS_DIR", &config.StoreDefinitionsDir)
+
+	// LOG_LEVELS 格式為 daf=debug,upstream=warn
+	setString("LOG_LEVEL", &config.Log.Level)
+	if value := os.Getenv("LOG_LEVELS"); value != "" {
+		levels := map[string]string{}
+		for _, pair := range splitMultiValue([]string{value}) {
+			subsystem, level, ok := strings.Cut(pair, "=")
+			if !ok {
+				errs = append(errs, fmt.Errorf("LOG_LEVELS 應為 子系統=等級，以逗號分隔: %q", pair))
+				continue
+			}
+			levels[strings.TrimSpace(subsystem)] = strings.TrimSpace(level)
+		}
+		config.Log.Levels = levels
+	}
+
+	setInt("SEARCH_WORKERS", &config.Search.Workers)
+	setInt("SEARCH_QUEUE_SIZE", &config.Search.QueueSize)
+	setDuration("SEARCH_JOB_RETENTION", &config.Search.JobRetention)
+	setDuration("SEARCH_RESULT_TTL", &config.Search.ResultTTL)
+
+	setInt("RATE_LIMIT_PER_MINUTE", &config.RateLimit.PerMinute)
+	setInt("RATE_LIMIT_BURST", &config.RateLimit.Burst)
+	setString("RATE_LIMIT_CLIENT_IP_HEADER", &config.RateLimit.ClientIPHeader)
+	// 設為空字串表示沒有豁免
+	setList("RATE_LIMIT_EXEMPT", &config.RateLimit.Exempt)
+
+	setList("CORS_ALLOWED_ORIGINS", &config.CORS.AllowedOrigins)
+	setList("CORS_ALLOWED_METHODS", &config.CORS.AllowedMethods)
+	setList("CORS_ALLOWED_HEADERS", &config.CORS.AllowedHeaders)
+	setDuration("CORS_MAX_AGE", &config.CORS.MaxAge)
+	setString("CONTENT_SECURITY_POLICY", &config.Security.ContentSecurityPolicy)
+	setString("REFERRER_POLICY", &config.Security.ReferrerPolicy)
+
+	setString("DAF_ENGINE", &config.DAF.Engine)
+	setString("DAF_BASE_URL", &config.DAF.BaseURL)
+	setInt("DAF_MAX_QUERIES", &config.DAF.MaxQueries)
+	setInt("DAF_LIST_PAGE_WORKERS", &config.DAF.ListPageWorkers)
+	setInt("DAF_DETAIL_WORKERS", &config.DAF.DetailWorkers)
+	setString("DAF_RENDER_DETAIL", &config.DAF.Render.Detail)
+
+	setString("ANNS_GRAPHQL_URL", &config.Anns.GraphQLURL)
+	setString("ANNS_SALE_PAGE_API_URL", &config.Anns.SalePageAPIURL)
+	setString("ANNS_SALE_PAGE_URL", &config.Anns.SalePageURL)
+	setString("ANNS_STOCK_API_URL", &config.Anns.StockAPIURL)
+	setInt("ANNS_DETAIL_WORKERS", &config.Anns.DetailWorkers)
+	setInt("ANNS_STOCK_BATCH_SIZE", &config.Anns.StockBatchSize)
+	setInt("ANNS_STOCK_CONCURRENCY", &config.Anns.StockConcurrency)
+	setString("ANNS_RENDER_DETAIL", &config.Anns.Render.Detail)
+
+	setString("RENDER_BROWSER_PATH", &config.Render.BrowserPath)
+	setInt("RENDER_PAGES", &config.Render.Pages)
+	setDuration("RENDER_PAGE_TIMEOUT", &config.Render.PageTimeout)
+	setList("RENDER_BLOCK_RESOURCES", &config.Render.BlockResources)
+
+	return errors.Join(errs...)
+}
+
+// 檢查設定值，一次列出所有錯誤
+func (config Config) validate() error {
+
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(config.ListenAddr != "", "listenAddr 不可為空")
+	if config.CABundle != "" {
+		_, err := os.Stat(config.CABundle)
+		check(err == nil, "找不到 CA 憑證: %s", config.CABundle)
+	}
+	check(config.HTTPTimeout.Duration > 0, "httpTimeout 必須大於 0")
+	check(config.ProductDetailTTL.Duration >= 0, "productDetailTTL 不可為負數")
+	check(config.ShutdownTimeout.Duration > 0, "shutdownTimeout 必須大於 0")
+
+	check(len(config.EnabledStores) > 0, "enabledStores 至少要啟用一家店")
+	supportedStores := config.supportedStores()
+	for _, store := range config.EnabledStores {
+		check(containsString(supportedStores, store), "enabledStores 不支援 %q，可用的值: %s", store, strings.Join(supportedStores, "、"))
+	}
+
+	_, err := parseLogLevel(config.Log.Level)
+	check(err == nil, "log.level: %v", err)
+	for subsystem, level := range config.Log.Levels {
+		check(containsString(logSubsystems, subsystem), "log.levels 不支援子系統 %q，可用的值: %s", subsystem, strings.Join(logSubsystems, "、"))
+		_, err := parseLogLevel(level)
+		check(err == nil, "log.levels.%s: %v", subsystem, err)
+	}
+
+	check(config.Search.Workers > 0, "search.workers 必須大於 0")
+	check(config.Search.QueueSize > 0, "search.queueSize 必須大於 0")
+	check(config.Search.JobRetention.Duration > 0, "search.jobRetention 必須大於 0")
+	check(config.Search.ResultTTL.Duration >= 0, "search.resultTTL 不可為負數")
+	check(config.Search.ResultTTL.Duration <= config.Search.JobRetention.Duration, "search.resultTTL 不可超過 search.jobRetention")
+
+	check(config.RateLimit.PerMinute >= 0, "rateLimit.perMinute 不可為負數")
+	check(config.RateLimit.PerMinute == 0 || config.RateLimit.Burst > 0, "rateLimit.burst 必須大於 0")
+	if err := validateExempt(config.RateLimit.Exempt); err != nil {
+		errs = append(errs, err)
+	}
+	errs = append(errs, validateCORS(config.CORS)...)
+
+	check(config.DAF.Engine == storeEngineBuiltin || config.DAF.Engine == storeEngineDefinition,
+		"daf.engine 應為 %s 或 %s: %q", storeEngineBuiltin, storeEngineDefinition, config.DAF.Engine)
+	if config.DAF.Engine == storeEngineDefinition {
+		_, err := builtinStoreDefinition("daf")
+		check(err == nil, "daf.engine: %v", err)
+	}
+	check(validBaseURL(config.DAF.BaseURL), "daf.baseURL 應為 http(s) 網址: %q", config.DAF.BaseURL)
+	check(strings.HasSuffix(config.DAF.BaseURL, "/"), "daf.baseURL 應以 / 結尾")
+	check(config.DAF.MaxQueries > 0, "daf.maxQueries 必須大於 0")
+	check(config.DAF.ListPageWorkers > 0, "daf.listPageWorkers 必須大於 0")
+	check(config.DAF.DetailWorkers > 0, "daf.detailWorkers 必須大於 0")
+	if err := validateRenderMode("daf.render.detail", config.DAF.Render.Detail); err != nil {
+		errs = append(errs, err)
+	}
+
+	check(validBaseURL(config.Anns.GraphQLURL), "anns.graphqlURL 應為 http(s) 網址: %q", config.Anns.GraphQLURL)
+	check(validBaseURL(config.Anns.SalePageAPIURL), "anns.salePageAPIURL 應為 http(s) 網址: %q", config.Anns.SalePageAPIURL)
+	check(validBaseURL(config.Anns.SalePageURL), "anns.salePageURL 應為 http(s) 網址: %q", config.Anns.SalePageURL)
+	check(validBaseURL(config.Anns.StockAPIURL), "anns.stockAPIURL 應為 http(s) 網址: %q", config.Anns.StockAPIURL)
+	check(config.Anns.DetailWorkers > 0, "anns.detailWorkers 必須大於 0")
+	check(config.Anns.StockBatchSize > 0, "anns.stockBatchSize 必須大於 0")
+	check(config.Anns.StockConcurrency > 0, "anns.stockConcurrency 必須大於 0")
+	if err := validateRenderMode("anns.render.detail", config.Anns.Render.Detail); err != nil {
+		errs = append(errs, err)
+	}
+
+	check(config.Render.Pages > 0, "render.pages 必須大於 0")
+	check(config.Render.PageTimeout.Duration > 0, "render.pageTimeout 必須大於 0")
+	if err := validateBlockResources(config.Render.BlockResources); err != nil {
+		errs = append(errs, err)
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("設定錯誤: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+func validBaseURL(value string) bool {
+	parsed, err := url.Parse(value)
+	return err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host != ""
+}
+
+// 內建的店鋪加上商店定義的店鋪
+func (config Config) supportedStores() []string {
+	return append(append([]string(nil), knownStores...), sortedKeys(config.storeDefinitions)...)
+}
+
+// 店鋪是否啟用
+func (config Config) storeEnabled(store string) bool {
+	return containsString(config.EnabledStores, store)
+}
+
+// 是否有啟用的店鋪設定要用無頭瀏覽器取得頁面
+func (config Config) renderEnabled() bool {
+	for _, store := range config.EnabledStores {
+		switch {
+		case store == "daf" && config.DAF.Render.Detail != renderOff,
+			store == "anns" && config.Anns.Render.Detail != renderOff,
+			config.storeDefinitions[store] != nil && config.storeDefinitions[store].Render.Detail != renderOff:
+			return true
+		}
+	}
+	return false
+}
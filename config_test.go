@@ -0,0 +1,203 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 寫入設定檔並設定 CONFIG_FILE，沒有內容時不設定
+func setConfigFile(t *testing.T, content string) {
+	t.Helper()
+	t.Setenv("GO_ENV", "test")
+	if content == "" {
+		t.Setenv("CONFIG_FILE", "")
+		return
+	}
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CONFIG_FILE", path)
+}
+
+func requireErrorContains(t *testing.T, err error, wants ...string) {
+	t.Helper()
+	if err == nil {
+		t.Fatalf("應回傳錯誤，包含 %q", wants)
+	}
+	for _, want := range wants {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("錯誤訊息應包含 %q，得到 %v", want, err)
+		}
+	}
+}
+
+func TestLoadConfigDefaults(t *testing.T) {
+	setConfigFile(t, "")
+	config, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := defaultConfig("test"); !reflect.DeepEqual(config.Search, want.Search) || config.ListenAddr != want.ListenAddr ||
+		!reflect.DeepEqual(config.EnabledStores, knownStores) || config.CABundle != "" {
+		t.Errorf("沒有設定檔與環境變數時應為預設值，得到 %+v", config)
+	}
+	if config := defaultConfig("release"); config.CABundle == "" {
+		t.Error("release 應使用映像檔內的 CA 憑證")
+	}
+	if config := defaultConfig("debug"); config.Log.Level != "debug" {
+		t.Errorf("debug 的 log 等級為 %s", config.Log.Level)
+	}
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	setConfigFile(t, `{
+		"listenAddr": ":9000",
+		"httpTimeout": "10s",
+		"search": {"workers": 4, "jobRetention": "1h"},
+		"rateLimit": {"perMinute": 30},
+		"cors": {"allowedOrigins": ["https://shoes.example"]}
+	}`)
+	// 環境變數覆寫設定檔，設定檔覆寫預設值
+	t.Setenv("PORT", "7000")
+	t.Setenv("SEARCH_WORKERS", "6")
+	t.Setenv("RATE_LIMIT_EXEMPT", "")
+	t.Setenv("LOG_LEVELS", "daf=debug, upstream=warn")
+	t.Setenv("CORS_ALLOWED_ORIGINS", "https://a.example,https://b.example")
+
+	config, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name      string
+		got, want interface{}
+	}{
+		{"PORT 覆寫設定檔", config.ListenAddr, ":7000"},
+		{"只寫在設定檔", config.HTTPTimeout.Duration, 10 * time.Second},
+		{"設定檔只覆寫寫到的欄位", config.Search.QueueSize, 32},
+		{"環境變數覆寫設定檔", config.Search.Workers, 6},
+		{"設定檔的時間長度", config.Search.JobRetention.Duration, time.Hour},
+		{"設定檔的數字", config.RateLimit.PerMinute, 30},
+		{"預設值", config.RateLimit.Burst, 5},
+		{"空字串清空清單", len(config.RateLimit.Exempt), 0},
+		{"清單以逗號分隔", config.CORS.AllowedOrigins, []string{"https://a.example", "https://b.example"}},
+		{"子系統等級", config.Log.Levels, map[string]string{"daf": "debug", "upstream": "warn"}},
+	}
+	for _, test := range tests {
+		if !reflect.DeepEqual(test.got, test.want) {
+			t.Errorf("%s: 得到 %v，應為 %v", test.name, test.got, test.want)
+		}
+	}
+
+	// LISTEN_ADDR 優先於 PORT
+	t.Setenv("LISTEN_ADDR", "127.0.0.1:8081")
+	config, err = loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.ListenAddr != "127.0.0.1:8081" {
+		t.Errorf("listenAddr 為 %s，LISTEN_ADDR 應優先", config.ListenAddr)
+	}
+}
+
+func TestLoadConfigFileErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []string
+	}{
+		{"JSON 格式錯誤", `{"listenAddr": `, []string{"格式錯誤"}},
+		{"未知的欄位", `{"listenAdress": ":9000"}`, []string{"格式錯誤", "listenAdress"}},
+		{"時間長度不是字串", `{"httpTimeout": 30}`, []string{"時間長度應為字串"}},
+		{"時間長度格式錯誤", `{"httpTimeout": "30 秒"}`, []string{"格式錯誤"}},
+		{"設定值錯誤", `{"search": {"workers": 0}}`, []string{"設定錯誤", "search.workers 必須大於 0"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setConfigFile(t, test.content)
+			_, err := loadConfig()
+			requireErrorContains(t, err, test.want...)
+		})
+	}
+
+	t.Run("找不到設定檔", func(t *testing.T) {
+		t.Setenv("GO_ENV", "test")
+		t.Setenv("CONFIG_FILE", filepath.Join(t.TempDir(), "missing.json"))
+		_, err := loadConfig()
+		requireErrorContains(t, err, "無法讀取設定檔")
+	})
+}
+
+func TestApplyEnvOverridesErrors(t *testing.T) {
+	t.Setenv("SEARCH_WORKERS", "兩個")
+	t.Setenv("HTTP_TIMEOUT", "30")
+	t.Setenv("LOG_LEVELS", "daf")
+	t.Setenv("RATE_LIMIT_BURST", "5")
+
+	config := defaultConfig("test")
+	err := applyEnvOverrides(&config)
+	// 格式錯誤的值一次全部列出，不會默默使用預設值
+	requireErrorContains(t, err, `SEARCH_WORKERS 應為整數: "兩個"`, `HTTP_TIMEOUT 應為時間長度`, `LOG_LEVELS 應為 子系統=等級`)
+	if config.Search.Workers != 2 || config.HTTPTimeout.Duration != 30*time.Second {
+		t.Errorf("格式錯誤的環境變數不應覆寫設定: %+v", config)
+	}
+	if config.RateLimit.Burst != 5 {
+		t.Errorf("格式正確的環境變數仍應套用，burst 為 %d", config.RateLimit.Burst)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(config *Config)
+		want   []string
+	}{
+		{"預設值", func(*Config) {}, nil},
+		{"監聽位址", func(c *Config) { c.ListenAddr = "" }, []string{"listenAddr 不可為空"}},
+		{"CA 憑證", func(c *Config) { c.CABundle = "/nonexistent/ca.crt" }, []string{"找不到 CA 憑證"}},
+		{"逾時時間", func(c *Config) { c.HTTPTimeout.Duration = 0 }, []string{"httpTimeout 必須大於 0"}},
+		{"商品快取時間", func(c *Config) { c.ProductDetailTTL.Duration = -time.Second }, []string{"productDetailTTL 不可為負數"}},
+		{"停止期限", func(c *Config) { c.ShutdownTimeout.Duration = 0 }, []string{"shutdownTimeout 必須大於 0"}},
+		{"沒有店鋪", func(c *Config) { c.EnabledStores = nil }, []string{"enabledStores 至少要啟用一家店"}},
+		{"未知的店鋪", func(c *Config) { c.EnabledStores = []string{"daf", "zara"} }, []string{`enabledStores 不支援 "zara"`}},
+		{"log 等級", func(c *Config) { c.Log.Level = "verbose" }, []string{"log.level"}},
+		{"log 子系統", func(c *Config) { c.Log.Levels = map[string]string{"cart": "debug", "daf": "loud"} }, []string{`log.levels 不支援子系統 "cart"`, "log.levels.daf"}},
+		{"搜尋工作", func(c *Config) { c.Search = SearchConfig{} }, []string{"search.workers 必須大於 0", "search.queueSize 必須大於 0", "search.jobRetention 必須大於 0"}},
+		{"結果沿用時間", func(c *Config) { c.Search.ResultTTL.Duration = time.Hour }, []string{"search.resultTTL 不可超過 search.jobRetention"}},
+		{"頻率限制", func(c *Config) { c.RateLimit.PerMinute = -1 }, []string{"rateLimit.perMinute 不可為負數"}},
+		{"頻率限制 burst", func(c *Config) { c.RateLimit.Burst = 0 }, []string{"rateLimit.burst 必須大於 0"}},
+		{"不限制時不需要 burst", func(c *Config) { c.RateLimit = RateLimitConfig{} }, nil},
+		{"豁免", func(c *Config) { c.RateLimit.Exempt = []string{"10.0.0.0/33"} }, []string{"rateLimit.exempt 應為 IP 或 CIDR"}},
+		{"CORS", func(c *Config) { c.CORS.AllowedOrigins = []string{"shoes.example"} }, []string{"cors.allowedOrigins"}},
+		{"爬取方式", func(c *Config) { c.DAF.Engine = "headless" }, []string{"daf.engine 應為 builtin 或 definition"}},
+		{"商店定義", func(c *Config) { c.DAF.Engine = storeEngineDefinition }, nil},
+		{"D+AF 網址", func(c *Config) { c.DAF.BaseURL = "https://www.daf-shoes.com" }, []string{"daf.baseURL 應以 / 結尾"}},
+		{"D+AF 數量", func(c *Config) { c.DAF.MaxQueries, c.DAF.ListPageWorkers, c.DAF.DetailWorkers = 0, 0, 0 },
+			[]string{"daf.maxQueries 必須大於 0", "daf.listPageWorkers 必須大於 0", "daf.detailWorkers 必須大於 0"}},
+		{"D+AF 渲染", func(c *Config) { c.DAF.Render.Detail = "sometimes" }, []string{"daf.render.detail"}},
+		{"Ann's 網址", func(c *Config) { c.Anns.StockAPIURL = "/webapi/ProductStock" }, []string{"anns.stockAPIURL 應為 http(s) 網址"}},
+		{"Ann's 數量", func(c *Config) { c.Anns.DetailWorkers, c.Anns.StockBatchSize, c.Anns.StockConcurrency = 0, 0, 0 },
+			[]string{"anns.detailWorkers 必須大於 0", "anns.stockBatchSize 必須大於 0", "anns.stockConcurrency 必須大於 0"}},
+		{"瀏覽器", func(c *Config) { c.Render.Pages, c.Render.PageTimeout.Duration = 0, 0 }, []string{"render.pages 必須大於 0", "render.pageTimeout 必須大於 0"}},
+		{"不載入的資源", func(c *Config) { c.Render.BlockResources = []string{"script"} }, []string{"script"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := defaultConfig("test")
+			test.modify(&config)
+			err := config.validate()
+			if test.want == nil {
+				if err != nil {
+					t.Fatalf("應為合法的設定: %v", err)
+				}
+				return
+			}
+			requireErrorContains(t, err, append([]string{"設定錯誤"}, test.want...)...)
+		})
+	}
+}
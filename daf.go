@@ -1,19 +1,19 @@
 package main
 
 import (
-	"encoding/json"
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
+	"net/url"
 	"regexp"
 	"strconv"
+	"strings"
 	"sync"
 )
 
-const rootURL = "https://www.daf-shoes.com/"
-
 // 靴類的searchCat
 var bootCategory = map[string]int{
 	"148": 1,
@@ -23,141 +23,226 @@ var bootCategory = map[string]int{
 	"259": 5,
 }
 
-func getDAFFliterResponse(orderby, searchSize, searchColor, searchHeel, searchCat string) ([]Shoe, error) {
+// dafCrawler D+AF 爬蟲，網址與並行數量來自設定
+type dafCrawler struct {
+	client *http.Client
+	config DAFConfig
+	// config.Render 不是 off 時用來渲染商品頁
+	renderer *renderFetcher
+}
+
+func (daf *dafCrawler) getDAFFliterResponse(ctx context.Context, params SearchParams) ([]Shoe, error) {
 
-	var url string
-	var pagecount int = 1
-	var isBoot bool = false
-	var resp *http.Response
-	var err error
-	// 用於等待所有 goroutines 完成
-	var wg sync.WaitGroup
-	// 用於保護共享資源
-	var mu sync.Mutex
 	shoes := []Shoe{}
 
 	// 記錄參數
-	log.Printf("D+AF篩選條件 - 排序規則: %s, 尺碼: %s, 顏色: %s, 跟高: %s, 款式: %s", orderby, searchSize, searchColor, searchHeel, searchCat)
-
-	var fliterQuery = fmt.Sprintf("orderby=%s&searchSize=%s&searchColor=%s&searchHeel=%s&searchCat=%s", orderby, searchSize, searchColor, searchHeel, searchCat)
+	dafLog.InfoContext(ctx, "D+AF 篩選條件", "orderby", params.OrderBy, "sizes", params.Sizes, "colors", params.Colors, "heels", params.Heels, "cats", params.Cats)
+
+	// D+AF 每個欄位只能帶一個值，多選時展開成所有組合分別查詢，同一欄位為 OR、不同欄位為 AND
+	sizes := dafQueryValues(params.Sizes)
+	colors := dafQueryValues(params.Colors)
+	heels := dafQueryValues(params.Heels)
+	cats := dafQueryValues(params.Cats)
+	totalQueries := len(sizes) * len(colors) * len(heels) * len(cats)
+	// 發起搜尋時已檢查過，直接呼叫時仍不可超過上限
+	if err := dafCheckQueryCount(params, daf.config.MaxQueries); err != nil {
+		return shoes, err
+	}
 
-	// 靴類要打另一個URL
-	if _, exists := bootCategory[searchCat]; exists {
-		url = fmt.Sprintf("%sproduct/list/303/%d?%s", rootURL, pagecount, fliterQuery)
-		isBoot = true
-	} else {
-		// 其他品項則手動組裝篩選 URL
-		url = fmt.Sprintf("%sproduct/list/all/%d?%s", rootURL, pagecount, fliterQuery)
-	}
-	log.Println("url:" + url)
-
-	if enviroment == "release" {
-		// 正式環境，要設定自訂的帶有 CA 憑證的 HTTP 客戶端
-		client, err := createHTTPClientWithCACert("/etc/ssl/certs/ca-certificates.crt")
-		if err != nil {
-			log.Println("D+AF 無法創建 HTTP 客戶端:", err)
-			return shoes, err
+	// 爬列表與爬商品頁同時進行: 列表每找到一雙新鞋就交給商品頁 worker 取得尺碼與顏色
+	pipeline := newDetailPipeline(ctx, "daf", daf.config.DetailWorkers, daf.getDAFSizeAndColor)
+
+	seen := map[string]struct{}{}
+	var order []string
+	err := func() error {
+		doneQueries := 0
+		reportProgress(ctx, "list", doneQueries, totalQueries)
+		for _, searchSize := range sizes {
+			for _, searchColor := range colors {
+				for _, searchHeel := range heels {
+					for _, searchCat := range cats {
+						listShoes, err := daf.getDAFShoeList(ctx, params.OrderBy, searchSize, searchColor, searchHeel, searchCat)
+						if err != nil {
+							return err
+						}
+						// 不同組合可能查到同一雙鞋
+						for _, shoe := range listShoes {
+							if _, exists := seen[shoe.ListID]; exists {
+								continue
+							}
+							seen[shoe.ListID] = struct{}{}
+							order = append(order, shoe.ListID)
+							pipeline.add(shoe)
+						}
+						doneQueries++
+						reportProgress(ctx, "list", doneQueries, totalQueries)
+					}
+				}
+			}
 		}
+		return nil
+	}()
+	dafLog.InfoContext(ctx, "已拿取全部篩選組合的鞋子", "shoes", len(order))
 
-		// 帶有 CA 憑證的 HTTP 客戶端向 D+AF 打 Fliter HTTP GET 請求
-		resp, err = client.Get(url)
-	} else {
-		// 本地端，不用設定 CA 憑證
-		// 直接向 D+AF 打 Fliter HTTP GET 請求
-		resp, err = http.Get(url)
-	}
-
+	// 等商品頁 worker 做完，依列表順序排回去
+	enriched := pipeline.wait()
 	if err != nil {
-		log.Println("D+AF 商品列表初始請求錯誤:", err)
 		return shoes, err
 	}
-	defer resp.Body.Close()
+	for _, listID := range order {
+		shoes = append(shoes, enriched[listID])
+	}
 
-	// 讀取回應內容
-	body, err := io.ReadAll(resp.Body)
+	return shoes, nil
+}
+
+// D+AF 一次只能帶一個尺碼/顏色/跟高/款式，展開的上游查詢數有上限
+func dafCheckQueryCount(params SearchParams, maxQueries int) error {
+	count := len(dafQueryValues(params.Sizes)) * len(dafQueryValues(params.Colors)) *
+		len(dafQueryValues(params.Heels)) * len(dafQueryValues(params.Cats))
+	if count > maxQueries {
+		return newParamError("", fmt.Errorf("D+AF 篩選條件組合過多(%d 組)，最多 %d 組，請減少尺碼、顏色、跟高或款式的選擇", count, maxQueries))
+	}
+	return nil
+}
+
+// D+AF 未選擇的欄位帶空值；選了「不限」("0") 就不用再展開其他值
+func dafQueryValues(values []string) []string {
+	if len(values) == 0 {
+		return []string{""}
+	}
+	for _, value := range values {
+		if value == "0" {
+			return []string{"0"}
+		}
+	}
+	return values
+}
+
+// 取得 D+AF 單一篩選組合下的所有鞋子(尚未取得尺碼與顏色)
+func (daf *dafCrawler) getDAFShoeList(ctx context.Context, orderby, searchSize, searchColor, searchHeel, searchCat string) ([]Shoe, error) {
+
+	// 記錄參數
+	dafLog.DebugContext(ctx, "D+AF 篩選組合", "orderby", orderby, "size", searchSize, "color", searchColor, "heel", searchHeel, "cat", searchCat)
+
+	// 參數值都要經過編碼再帶給 D+AF
+	var fliterQuery = url.Values{
+		"orderby":     {orderby},
+		"searchSize":  {searchSize},
+		"searchColor": {searchColor},
+		"searchHeel":  {searchHeel},
+		"searchCat":   {searchCat},
+	}.Encode()
+
+	// 靴類要打另一個URL
+	_, isBoot := bootCategory[searchCat]
+
+	body, err := daf.getDAFPage(ctx, daf.dafListPageURL(isBoot, 1, fliterQuery))
 	if err != nil {
-		log.Println("D+AF 商品列表初始讀取回應錯誤:", err)
-		return shoes, err
+		dafLog.ErrorContext(ctx, "D+AF 商品列表初始請求錯誤", "error", err)
+		return nil, err
 	}
 
 	// 取出totalPage
 	totalPage, err := getTotalPage(body)
 	if err != nil {
-		log.Println("D+AF 取得totalPage錯誤:", err)
-		return shoes, err
+		dafLog.ErrorContext(ctx, "D+AF 取得 totalPage 錯誤", "error", err)
+		return nil, err
 	}
 
-	log.Printf("已拿到totalpage，要取全部篩選的鞋子，D+AF 總頁數: %d", totalPage)
-	// 拿到totalPage後，遍歷每一頁的商品將之加入shoes
-	err = getTotalShoes(totalPage, rootURL, pagecount, fliterQuery, isBoot, &shoes)
+	dafLog.DebugContext(ctx, "已拿到 totalPage，要取全部篩選的鞋子", "totalPage", totalPage)
+	// 第一頁已經拿到了，直接解析；其餘頁面並行取得
+	shoes, err := daf.parseDAFListPage(body)
 	if err != nil {
-		log.Println("D+AF 取得所有鞋子錯誤:", err)
-		return shoes, err
+		dafLog.ErrorContext(ctx, "D+AF 解析商品列表錯誤", "error", err)
+		return nil, err
 	}
-	log.Printf("已拿取全部篩選的鞋子，總鞋子數: %d", len(shoes))
-
-	// 傳遞結果的 channel
-	ch := make(chan struct {
-		index int
-		size  []string
-		color []string
-	})
+	restShoes, err := daf.getTotalShoes(ctx, totalPage, fliterQuery, isBoot)
+	if err != nil {
+		dafLog.ErrorContext(ctx, "D+AF 取得所有鞋子錯誤", "error", err)
+		return nil, err
+	}
+	shoes = append(shoes, restShoes...)
+	dafLog.DebugContext(ctx, "已拿取全部篩選的鞋子", "shoes", len(shoes))
 
-	// 遍歷訪問shoes.URL，取得每個頁面的內容
-	for i := range shoes {
-		// 增加 WaitGroup 計數
-		wg.Add(1)
-		go func(i int) {
-			// 當 goroutine 完成時減少 WaitGroup 計數
-			defer wg.Done()
-			// 發送shoes.URL HTTP GET 請求
-			childresp, err := http.Get(shoes[i].URL)
-			if err != nil {
-				log.Println("D+AF 遍歷訪問各商品時請求錯誤:", err)
-				return
-			}
-			defer childresp.Body.Close()
+	return shoes, nil
+}
 
-			// 讀取回應內容
-			childbody, err := io.ReadAll(childresp.Body)
-			if err != nil {
-				log.Println("D+AF 遍歷訪問各商品時讀取回應錯誤:", err)
-				return
-			}
+// D+AF 列表頁網址，靴類要打另一個URL
+func (daf *dafCrawler) dafListPageURL(isBoot bool, page int, fliterQuery string) string {
+	if isBoot {
+		return fmt.Sprintf("%sproduct/list/303/%d?%s", daf.config.BaseURL, page, fliterQuery)
+	}
+	return fmt.Sprintf("%sproduct/list/all/%d?%s", daf.config.BaseURL, page, fliterQuery)
+}
 
-			// 尺碼
-			getSize(childbody, &shoes[i])
-			// 顏色
-			getColor(childbody, &shoes[i])
-
-			// 將結果發送到 channel
-			ch <- struct {
-				index int
-				size  []string
-				color []string
-			}{index: i, size: shoes[i].Size, color: shoes[i].Color}
-		}(i)
-	}
-
-	// 啟動一個 goroutine 來等待所有工作完成並關閉 channel
-	go func() {
-		// 等待所有 goroutines 完成
-		wg.Wait()
-		close(ch)
-	}()
+// 向 D+AF 發 GET 請求並讀取 Body，請求本身的 log 由 upstream 子系統記錄
+func (daf *dafCrawler) getDAFPage(ctx context.Context, url string) ([]byte, error) {
 
-	// 從 channel 接收結果並更新鞋子的尺寸和顏色
-	for result := range ch {
-		// 鎖定 mutex 以保護共享資源
-		mu.Lock()
-		shoes[result.index].Size = result.size
-		shoes[result.index].Color = result.color
-		mu.Unlock()
+	resp, err := getWithContext(ctx, daf.client, url)
+	if err != nil {
+		return nil, err
 	}
+	defer resp.Body.Close()
+
+	// 讀取回應內容
+	return io.ReadAll(resp.Body)
+}
 
+// 從列表頁取出這一頁的鞋子: ListID、名稱、價格、URL、圖檔
+func (daf *dafCrawler) parseDAFListPage(body []byte) ([]Shoe, error) {
+	shoes := []Shoe{}
+	if err := getListIDAndNameAndPrize(body, &shoes); err != nil {
+		return shoes, err
+	}
+	getURL(body, daf.config.BaseURL, &shoes, len(shoes))
+	getImage(body, &shoes, len(shoes))
 	return shoes, nil
 }
 
+// 訪問商品頁，取得一雙鞋的尺碼和顏色
+func (daf *dafCrawler) getDAFSizeAndColor(ctx context.Context, shoe *Shoe) error {
+
+	mode := daf.config.Render.Detail
+	// getSize 找不到尺碼時會標記售罄，渲染後重新解析前先還原列表上的狀態
+	listStatus := shoe.Status
+	var childbody []byte
+	if mode != renderAlways {
+		// 發送shoe.URL HTTP GET 請求
+		var err error
+		childbody, err = daf.getDAFPage(ctx, shoe.URL)
+		if err != nil {
+			dafLog.WarnContext(ctx, "D+AF 遍歷訪問各商品時請求錯誤", "listID", shoe.ListID, "url", shoe.URL, "error", err)
+			return err
+		}
+		// 尺碼
+		getSize(childbody, shoe)
+	}
+
+	// 一律渲染，或 HTTP 取得的頁面解析不到尺碼時改用瀏覽器渲染後再解析一次
+	if shouldRender(mode, len(shoe.Size) > 0) {
+		html, err := daf.renderer.fetch(ctx, "daf", shoe.URL)
+		if err != nil {
+			// fallback 時保留 HTTP 的結果
+			if mode == renderAlways {
+				return err
+			}
+		} else {
+			childbody = []byte(html)
+			shoe.Status = listStatus
+			shoe.Size = nil
+			getSize(childbody, shoe)
+			dafLog.DebugContext(ctx, "D+AF 以瀏覽器渲染商品頁", "listID", shoe.ListID, "mode", mode, "sizes", len(shoe.Size))
+		}
+	}
+
+	// 顏色
+	getColor(childbody, shoe)
+	// 款式屬性，商品頁描述補充標題沒寫到的部分
+	shoe.Attributes = mergeStyleAttributes(shoe.Attributes, extractStyleAttributes(getMetaDescription(childbody)))
+	return nil
+}
+
 // 從吐回來的Body中取出totalPage
 func getTotalPage(body []byte) (int, error) {
 
@@ -168,111 +253,115 @@ func getTotalPage(body []byte) (int, error) {
 	re := regexp.MustCompile(`<input[^>]+type=['"]hidden['"][^>]+name=['"]totalpage['"][^>]+value=['"](\d+)['"][^>]*>`)
 	matches := re.FindStringSubmatch(string(body))
 	if len(matches) == 0 {
-		log.Println("D+AF 未找到匹配的 totalpage")
-		return 0, errors.New("D+AF 未找到匹配的 totalpage")
+		return 0, newParseError("D+AF", "totalpage", errors.New("未找到匹配的 totalpage"))
 	}
 
 	// 轉型成int
 	totalpage, err = strconv.Atoi(matches[1])
 	if err != nil {
-		return 0, errors.New("D+AF totalpage轉換錯誤")
+		return 0, newParseError("D+AF", "totalpage", err)
 	}
 	return totalpage, nil
 }
 
-// 依totalPage去取出所有鞋
-func getTotalShoes(totalPage int, rootURL string, pagecount int, fliterQuery string, isBoot bool, shoes *[]Shoe) error {
+// 依totalPage並行取出第 2 頁之後的所有鞋，結果依頁碼排序
+func (daf *dafCrawler) getTotalShoes(ctx context.Context, totalPage int, fliterQuery string, isBoot bool) ([]Shoe, error) {
 
-	// 依totalPage去取出所有鞋
-	for count := pagecount; count <= totalPage; count++ {
-
-		var resp *http.Response
-		var err error
-		var url string
+	if totalPage < 2 {
+		return nil, nil
+	}
 
-		if isBoot {
-			url = fmt.Sprintf("%sproduct/list/303/%d?%s", rootURL, count, fliterQuery)
-		} else {
-			url = fmt.Sprintf("%sproduct/list/all/%d?%s", rootURL, count, fliterQuery)
-		}
+	pages := make([][]Shoe, totalPage+1)
+	errs := make([]error, totalPage+1)
 
-		log.Println("url:" + url)
+	var wg sync.WaitGroup
+	var sem = make(chan struct{}, daf.config.ListPageWorkers) // 限制同時最多 X 個列表頁請求
+	for page := 2; page <= totalPage; page++ {
+		wg.Add(1)
+		go func(page int) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			// 異常的列表頁只讓這個篩選組合失敗
+			defer recoverPanic("D+AF 列表頁", &errs[page])
 
-		if enviroment == "release" {
-			// 正式環境，要設定自訂的帶有 CA 憑證的 HTTP 客戶端
-			client, err := createHTTPClientWithCACert("/etc/ssl/certs/ca-certificates.crt")
+			body, err := daf.getDAFPage(ctx, daf.dafListPageURL(isBoot, page, fliterQuery))
 			if err != nil {
-				log.Println("D+AF totalPage去取出所有鞋無法創建 HTTP 客戶端:", err)
-				return err
+				dafLog.ErrorContext(ctx, "D+AF 列表頁請求錯誤", "page", page, "error", err)
+				errs[page] = err
+				return
 			}
+			pages[page], errs[page] = daf.parseDAFListPage(body)
+		}(page)
+	}
+	wg.Wait()
 
-			// 帶有 CA 憑證的 HTTP 客戶端向 D+AF 打 Fliter HTTP GET 請求
-			resp, err = client.Get(url)
-		} else {
-			// 本地端，不用設定 CA 憑證
-			// 直接向 D+AF 打 Fliter HTTP GET 請求
-			resp, err = http.Get(url)
-		}
-
-		// 向 D+AF 打 Fliter HTTP GET 請求
-		resp, err = http.Get(url)
-		if err != nil {
-			log.Println("D+AF totalPage去取出所有鞋請求錯誤:", err)
-			return err
-		}
-		defer resp.Body.Close()
-
-		// 讀取回應內容
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			log.Println("D+AF totalPage去取出所有鞋讀取Body錯誤:", err)
-			return err
+	var shoes []Shoe
+	for page := 2; page <= totalPage; page++ {
+		if errs[page] != nil {
+			return nil, errs[page]
 		}
-
-		// ListID、名稱、價格
-		getListIDAndNameAndPrize(body, shoes)
-		// URL
-		getURL(body, shoes, len(*shoes))
-		// 圖檔
-		getImage(body, shoes, len(*shoes))
+		shoes = append(shoes, pages[page]...)
 	}
-
-	return nil
+	return shoes, nil
 }
 
 // 從吐回來的Body中取出所有鞋的名稱、價格、數量
-func getListIDAndNameAndPrize(body []byte, shoes *[]Shoe) []Shoe {
-
-	// 使用正則表達式提取 JavaScript 物件
-	re := regexp.MustCompile(`gtag\('event', 'view_item_list', {[\s\S]+?}\);`)
-	matches := re.FindStringSubmatch(string(body))
-	if len(matches) == 0 {
-		log.Println("D+AF 未找到匹配的 JavaScript 物件")
-		return *shoes
-	}
+func getListIDAndNameAndPrize(body []byte, shoes *[]Shoe) error {
 
-	// 提取 items 部分
-	reItems := regexp.MustCompile(`"items": \[([^\]]+)\]`)
-	itemsMatch := reItems.FindStringSubmatch(matches[0])
-	if len(itemsMatch) == 0 {
-		log.Println("D+AF 未找到 items 部分")
-		return *shoes
+	items, err := getGtagItems(body, "view_item_list")
+	if err != nil {
+		return err
 	}
 
-	// 解析 items 部分
-	var items []map[string]interface{}
-	_ = json.Unmarshal([]byte(fmt.Sprintf("[%s]", itemsMatch[1])), &items)
-
-	// 將 items 轉換為 Shoe 結構體
+	// 將 items 轉換為 Shoe 結構體，缺少編號或名稱的略過
 	for _, item := range items {
+		name, ok := item["name"].(string)
+		if !ok || item["id"] == nil {
+			dafLog.Warn("D+AF 商品列表項目缺少 id 或 name", "item", item)
+			continue
+		}
 		shoe := Shoe{
 			ListID: fmt.Sprintf("%v", item["id"]),
-			Name:   item["name"].(string),
+			Name:   name,
 			Price:  fmt.Sprintf("%v", item["price"]),
+			Store:  "daf",
+			Status: shoeStatusAvailable,
 		}
+		// 從標題解析款式屬性
+		shoe.Attributes = extractStyleAttributes(shoe.Name)
+		// ListID 為 "商品編號_顏色編號"，同一商品編號即為同款
+		shoe.FamilyID = strings.SplitN(shoe.ListID, "_", 2)[0]
 		*shoes = append(*shoes, shoe)
 	}
-	return *shoes
+	return nil
+}
+
+// 從吐回來的Body中取出 gtag 指定事件的 items
+var gtagItemsRe = regexp.MustCompile(`"items":\s*\[`)
+
+func getGtagItems(body []byte, event string) ([]map[string]interface{}, error) {
+
+	// 使用正則表達式找到 JavaScript 物件的開頭
+	re := regexp.MustCompile(`gtag\('event', '` + regexp.QuoteMeta(event) + `', {`)
+	start := re.FindIndex(body)
+	if start == nil {
+		return nil, newParseError("D+AF", "gtag "+event, errors.New("未找到匹配的 JavaScript 物件"))
+	}
+
+	// 找到 items 陣列的開頭，不可跨到下一個 gtag 呼叫
+	rest := body[start[1]:]
+	itemsStart := gtagItemsRe.FindIndex(rest)
+	if itemsStart == nil || bytes.Contains(rest[:itemsStart[0]], []byte("gtag(")) {
+		return nil, newParseError("D+AF", "gtag "+event, errors.New("未找到 items 部分"))
+	}
+
+	// 從 [ 開始解析一個完整的 JSON 陣列，商品內有巢狀陣列也不會提早結束
+	var items []map[string]interface{}
+	if err := decodeJSONPrefix(rest[itemsStart[1]-1:], &items); err != nil {
+		return nil, newParseError("D+AF", "gtag "+event, err)
+	}
+	return items, nil
 }
 
 // 從吐回來的Body中取出所有鞋的圖檔
@@ -294,22 +383,31 @@ func getImage(body []byte, shoes *[]Shoe, num int) []Shoe {
 	return *shoes
 }
 
-// 從吐回來的Body中取出所有鞋的URL
-func getURL(body []byte, shoes *[]Shoe, num int) []Shoe {
+// 從吐回來的Body中取出所有鞋的URL，baseURL 為 D+AF 網站根目錄
+func getURL(body []byte, baseURL string, shoes *[]Shoe, num int) []Shoe {
 
 	// 使用正則表達式提取 <a> 標籤中的 href 屬性值
 	re := regexp.MustCompile(`<a[^>]*alt="[^"]*"[^>]*href="([^"]+)"`)
 	matches := re.FindAllStringSubmatch(string(body), -1)
+	// href 為 / 開頭的路徑，以網站網址解析成絕對網址(直接相接會變成 //product)；網址在設定檢查時已確認格式
+	base, _ := url.Parse(baseURL)
 
 	// 將 href 值存儲到 Shoe 結構體的 URL 字段
 	for _, match := range matches {
 		// 使用正則表達式提取 href 中的後面兩段
 		hrefRe := regexp.MustCompile(`/product/show/(\d+)/(\d+)/`)
 		hrefMatches := hrefRe.FindStringSubmatch(match[1])
+		if len(hrefMatches) < 3 {
+			continue
+		}
+		shoeURL, err := base.Parse(match[1])
+		if err != nil {
+			continue
+		}
 		listID := fmt.Sprintf("%s_%s", hrefMatches[1], hrefMatches[2])
 		for i := range *shoes {
 			if (*shoes)[i].ListID == listID {
-				(*shoes)[i].URL = rootURL + match[1]
+				(*shoes)[i].URL = shoeURL.String()
 			}
 		}
 	}
@@ -324,7 +422,8 @@ func getSize(body []byte, shoe *Shoe) *Shoe {
 	re := regexp.MustCompile(`<div[^>]+class=['"][^'"]*mini-box\s+sizeSel[^'"]*['"][^>]+btn=['"]ok['"][^>]*>.*?</div>`)
 	matches := re.FindAllStringSubmatch(string(body), -1)
 	if len(matches) == 0 {
-		log.Printf("D+AF 未找到匹配的 <div class='mini-box sizeSel'> 標籤，應為售罄，商品名稱: %s", shoe.Name)
+		dafLog.Debug("D+AF 未找到尺碼區塊，應為售罄", "name", shoe.Name)
+		shoe.Status = shoeStatusSoldOut
 		return shoe
 	}
 
@@ -342,6 +441,16 @@ func getSize(body []byte, shoe *Shoe) *Shoe {
 	return shoe
 }
 
+// 從商品頁中取出 <meta name="description"> 的內容
+func getMetaDescription(body []byte) string {
+	re := regexp.MustCompile(`<meta[^>]+name=['"]description['"][^>]+content=['"]([^'"]*)['"]`)
+	match := re.FindSubmatch(body)
+	if match == nil {
+		return ""
+	}
+	return strings.TrimSpace(string(match[1]))
+}
+
 // 遍歷每個產品後，從吐回來的Body中取出一雙鞋的顏色List
 func getColor(body []byte, shoe *Shoe) *Shoe {
 
@@ -349,7 +458,7 @@ func getColor(body []byte, shoe *Shoe) *Shoe {
 	re := regexp.MustCompile(`<div[^>]+class=['"][^'"]*mini-box\s+color\s+colorSel[^'"]*['"][^>]+title=['"]([^'"]+)['"][^>]*>`)
 	matches := re.FindAllStringSubmatch(string(body), -1)
 	if len(matches) == 0 {
-		log.Printf("D+AF 未找到匹配的 <div class='mini-box colorSel'> 標籤，商品名稱: %s", shoe.Name)
+		dafLog.Debug("D+AF 未找到顏色區塊", "name", shoe.Name)
 		return shoe
 	}
 
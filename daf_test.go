@@ -0,0 +1,363 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// dafTestServer 以錄下來的 D+AF 列表頁(3 頁)與商品頁回應請求，並記錄每個路徑被請求的次數
+type dafTestServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests map[string]int
+}
+
+var (
+	dafTestListPathRe   = regexp.MustCompile(`^/+product/list/(?:all|303)/(\d+)$`)
+	dafTestDetailPathRe = regexp.MustCompile(`^/+product/show/\d+/\d+/$`)
+)
+
+// latency 模擬商店的回應時間
+func newDAFTestServer(t testing.TB, latency time.Duration) *dafTestServer {
+	t.Helper()
+	pages := map[string][]byte{}
+	for page := 1; page <= 3; page++ {
+		pages[fmt.Sprint(page)] = readTestdata(t, fmt.Sprintf("daf/list_%d.html", page))
+	}
+	detail := readTestdata(t, "daf/detail.html")
+
+	server := &dafTestServer{requests: map[string]int{}}
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		server.mu.Lock()
+		server.requests[r.URL.Path]++
+		server.mu.Unlock()
+		time.Sleep(latency)
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if match := dafTestListPathRe.FindStringSubmatch(r.URL.Path); match != nil && pages[match[1]] != nil {
+			w.Write(pages[match[1]])
+			return
+		}
+		if dafTestDetailPathRe.MatchString(r.URL.Path) {
+			w.Write(detail)
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// 列表頁 page 被請求的次數
+func (server *dafTestServer) listRequests(page int) int {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	return server.requests[fmt.Sprintf("/product/list/all/%d", page)]
+}
+
+func (server *dafTestServer) totalRequests() int {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	total := 0
+	for _, count := range server.requests {
+		total += count
+	}
+	return total
+}
+
+func (server *dafTestServer) crawler() *dafCrawler {
+	return &dafCrawler{
+		client: server.Client(),
+		config: DAFConfig{
+			BaseURL:         server.URL + "/",
+			MaxQueries:      20,
+			ListPageWorkers: 4,
+			DetailWorkers:   8,
+			Render:          StoreRenderConfig{Detail: renderOff},
+		},
+	}
+}
+
+// 改版前的做法: 列表從第 1 頁起逐頁取得(第 1 頁取得兩次)，列表全部完成後才取得商品頁
+func getDAFShoesSequential(ctx context.Context, daf *dafCrawler, fliterQuery string) ([]Shoe, error) {
+	body, err := daf.getDAFPage(ctx, daf.dafListPageURL(false, 1, fliterQuery))
+	if err != nil {
+		return nil, err
+	}
+	totalPage, err := getTotalPage(body)
+	if err != nil {
+		return nil, err
+	}
+	var shoes []Shoe
+	for page := 1; page <= totalPage; page++ {
+		body, err := daf.getDAFPage(ctx, daf.dafListPageURL(false, page, fliterQuery))
+		if err != nil {
+			return nil, err
+		}
+		pageShoes, err := daf.parseDAFListPage(body)
+		if err != nil {
+			return nil, err
+		}
+		shoes = append(shoes, pageShoes...)
+	}
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, daf.config.DetailWorkers)
+	for i := range shoes {
+		wg.Add(1)
+		go func(shoe *Shoe) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			daf.getDAFSizeAndColor(ctx, shoe)
+		}(&shoes[i])
+	}
+	wg.Wait()
+	return shoes, nil
+}
+
+func TestGetDAFFliterResponse(t *testing.T) {
+	server := newDAFTestServer(t, 0)
+	shoes, err := server.crawler().getDAFFliterResponse(context.Background(), SearchParams{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 3 頁各 4 雙，第 1 頁只取得一次
+	if len(shoes) != 12 {
+		t.Fatalf("取得 %d 雙鞋，應為 12 雙", len(shoes))
+	}
+	for page := 1; page <= 3; page++ {
+		if count := server.listRequests(page); count != 1 {
+			t.Errorf("第 %d 頁請求了 %d 次，應為 1 次", page, count)
+		}
+	}
+	if total := server.totalRequests(); total != 3+12 {
+		t.Errorf("共請求 %d 次，應為 15 次(3 個列表頁、12 個商品頁)", total)
+	}
+
+	// 依列表順序排列，並帶有列表與商品頁的資訊
+	first := shoes[0]
+	want := Shoe{
+		ListID:   "31000_2",
+		Name:     "MIT真皮尖頭瑪莉珍跟鞋",
+		Image:    "https://img.daf-shoes.com/product/31000/2/m.webp",
+		URL:      server.URL + "/product/show/31000/2/",
+		Price:    "2380",
+		Size:     []string{"40", "41", "43"},
+		Color:    []string{"黑", "杏"},
+		Status:   shoeStatusAvailable,
+		Store:    "daf",
+		FamilyID: "31000",
+		Attributes: StyleAttributes{
+			Toe:      "pointed",
+			Closure:  []string{"buckle"},
+			Material: []string{"leather"},
+			HeelCm:   float64Pointer(5),
+		},
+	}
+	if !reflect.DeepEqual(first, want) {
+		t.Errorf("第一雙鞋\n%+v\n應為\n%+v", first, want)
+	}
+	if shoes[11].ListID != "31077_4" {
+		t.Errorf("最後一雙鞋 %s，應為第 3 頁最後一雙 31077_4", shoes[11].ListID)
+	}
+
+	// 與改版前逐頁取得的結果相同
+	sequential, err := getDAFShoesSequential(context.Background(), server.crawler(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sortShoesByListID(sequential)
+	sortShoesByListID(shoes)
+	if !reflect.DeepEqual(shoes, sequential) {
+		t.Error("並行取得的結果與逐頁取得的結果不同")
+	}
+}
+
+func sortShoesByListID(shoes []Shoe) {
+	sort.Slice(shoes, func(i, j int) bool { return shoes[i].ListID < shoes[j].ListID })
+}
+
+// 比較改版前逐頁取得與並行、列表與商品頁同時進行的速度，以及每次搜尋的請求數
+func BenchmarkGetTotalShoes(b *testing.B) {
+	const latency = 5 * time.Millisecond
+
+	b.Run("sequential", func(b *testing.B) {
+		server := newDAFTestServer(b, latency)
+		daf := server.crawler()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if _, err := getDAFShoesSequential(context.Background(), daf, ""); err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.ReportMetric(float64(server.totalRequests())/float64(b.N), "requests/op")
+		b.ReportMetric(float64(server.listRequests(1))/float64(b.N), "page1/op")
+	})
+
+	b.Run("pipelined", func(b *testing.B) {
+		server := newDAFTestServer(b, latency)
+		daf := server.crawler()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if _, err := daf.getDAFFliterResponse(context.Background(), SearchParams{}); err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.ReportMetric(float64(server.totalRequests())/float64(b.N), "requests/op")
+		b.ReportMetric(float64(server.listRequests(1))/float64(b.N), "page1/op")
+	})
+}
+
+// 解析失敗一律回傳 *ParseError，不可 panic
+func requireParseError(t *testing.T, err error) {
+	t.Helper()
+	var parseError *ParseError
+	if err != nil && !errors.As(err, &parseError) {
+		t.Fatalf("錯誤應為 *ParseError，得到 %T: %v", err, err)
+	}
+}
+
+func FuzzGetTotalPage(f *testing.F) {
+	f.Add(readTestdata(f, "daf/list_1.html"))
+	f.Add([]byte(`<input type="hidden" name="totalpage" value="99999999999999999999">`))
+	f.Add([]byte(`<input type='hidden' name='totalpage' value='0'>`))
+	f.Add([]byte(`<input type="hidden" name="totalpage">`))
+	f.Add([]byte{})
+	f.Fuzz(func(t *testing.T, body []byte) {
+		totalPage, err := getTotalPage(body)
+		requireParseError(t, err)
+		if err == nil && totalPage < 0 {
+			t.Fatalf("totalPage 不可為負數: %d", totalPage)
+		}
+	})
+}
+
+func FuzzGetGtagItems(f *testing.F) {
+	f.Add(readTestdata(f, "daf/list_1.html"))
+	f.Add(readTestdata(f, "daf/detail.html"))
+	f.Add([]byte(`gtag('event', 'view_item_list', {"items": [{"id": "1_2", "sizes": [40, 41]}]});`))
+	f.Add([]byte(`gtag('event', 'view_item_list', {"items": [}]});`))
+	f.Add([]byte(`gtag('event', 'view_item', {"items": []});`))
+	f.Fuzz(func(t *testing.T, body []byte) {
+		// 事件名稱由程式固定帶入，只有頁面內容來自商店
+		for _, event := range []string{"view_item_list", "view_item"} {
+			_, err := getGtagItems(body, event)
+			requireParseError(t, err)
+		}
+	})
+}
+
+func TestDAFMalformedPages(t *testing.T) {
+	for _, body := range []string{
+		"",
+		`<input type="hidden" name="totalpage">`,
+		`<input type="hidden" name="totalpage" value="99999999999999999999">`,
+	} {
+		_, err := getTotalPage([]byte(body))
+		var parseError *ParseError
+		if !errors.As(err, &parseError) {
+			t.Errorf("getTotalPage(%q) 應回傳 *ParseError，得到 %v", body, err)
+		}
+	}
+	for _, body := range []string{
+		"",
+		`gtag('event', 'view_item_list', {"list": 1});`,
+		`gtag('event', 'view_item_list', {"items": [{"id": }]});`,
+	} {
+		_, err := getGtagItems([]byte(body), "view_item_list")
+		var parseError *ParseError
+		if !errors.As(err, &parseError) {
+			t.Errorf("getGtagItems(%q) 應回傳 *ParseError，得到 %v", body, err)
+		}
+	}
+}
+
+// 內建爬蟲與 stores/daf.json 的商店定義爬取同一批頁面，結果應相同
+func TestDAFEnginesMatch(t *testing.T) {
+	server := newDAFTestServer(t, 0)
+	daf := server.crawler()
+
+	config := defaultConfig("release")
+	config.DAF = daf.config
+	config.DAF.Engine = storeEngineDefinition
+	scrapers, err := newHTMLScrapers(config, server.Client(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, params := range []SearchParams{
+		{Store: "daf"},
+		{Store: "daf", Sizes: []string{"13", "15"}, Colors: []string{"49"}},
+	} {
+		builtin, err := daf.getDAFFliterResponse(context.Background(), params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		definition, err := scrapers["daf"].search(context.Background(), params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(builtin) == 0 {
+			t.Fatalf("%+v: 內建爬蟲沒有取得任何鞋子", params)
+		}
+		sortShoesByListID(builtin)
+		sortShoesByListID(definition)
+		if !reflect.DeepEqual(builtin, definition) {
+			t.Errorf("%+v: 兩種爬蟲結果不同\n內建: %+v\n商店定義: %+v", params, builtin, definition)
+		}
+	}
+}
+
+func TestGetGtagItemsNestedArrays(t *testing.T) {
+	body := []byte(`gtag('event', 'view_item_list', {"items": [{"id": "1_2", "variants": [["黑", [40, 41]]]}, {"id": "3_4"}]});
+gtag('event', 'view_item', {"items": [{"id": "9_9"}]});`)
+	items, err := getGtagItems(body, "view_item_list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 || items[0]["id"] != "1_2" || items[1]["id"] != "3_4" {
+		t.Errorf("取得 %v", items)
+	}
+
+	// items 不可取到下一個 gtag 呼叫的
+	if _, err := getGtagItems([]byte(`gtag('event', 'view_item_list', {"list": 1}); gtag('event', 'view_item', {"items": []});`), "view_item_list"); err == nil {
+		t.Error("應回傳錯誤")
+	}
+}
+
+func TestScraperCheckQueryCount(t *testing.T) {
+	config := defaultConfig("release")
+	config.DAF.Engine = storeEngineDefinition
+	scrapers, err := newHTMLScrapers(config, http.DefaultClient, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &Server{config: config, scrapers: scrapers}
+
+	// 5 個尺碼 × 5 個顏色 = 25 組，超過 20 組
+	params := SearchParams{Store: "daf", Sizes: []string{"11", "12", "13", "14", "15"}, Colors: []string{"49", "84", "82", "79", "61"}}
+	err = server.checkQueryCount(params)
+	var paramError *ParamError
+	if !errors.As(err, &paramError) {
+		t.Fatalf("應回傳 *ParamError，得到 %v", err)
+	}
+	if _, err := scrapers["daf"].search(context.Background(), params); !errors.As(err, &paramError) {
+		t.Errorf("search 應回傳 *ParamError，得到 %v", err)
+	}
+
+	params.Colors = params.Colors[:4]
+	if err := server.checkQueryCount(params); err != nil {
+		t.Errorf("20 組應可查詢，得到 %v", err)
+	}
+}
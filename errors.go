@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+)
+
+// ParseError 商店回應的格式與預期不符，Store 為商店、Field 為正在解析的資料
+type ParseError struct {
+	Store string
+	Field string
+	Err   error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s 解析 %s 失敗: %v", e.Store, e.Field, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// 建立解析錯誤並計入指標；不是鞋子的商品不算解析失敗
+func newParseError(store, field string, err error) *ParseError {
+	if !errors.Is(err, errAnnsNotShoe) {
+		metrics.parseFailures.add(1, store, field)
+	}
+	return &ParseError{Store: store, Field: field, Err: err}
+}
+
+// UpstreamStatusError 商店回應 200、404 以外的狀態碼(5xx、429 等)，表示商店異常而不是找不到商品
+type UpstreamStatusError struct {
+	Store      string
+	StatusCode int
+}
+
+func (e *UpstreamStatusError) Error() string {
+	return fmt.Sprintf("%s 回應 HTTP %d", e.Store, e.StatusCode)
+}
+
+// errPanic 執行過程中發生 panic，已被 recover
+var errPanic = errors.New("發生非預期錯誤")
+
+// 在 goroutine 或可能因異常資料 panic 的函式開頭 defer，避免單一商品拖垮整個伺服器。
+// err 不為 nil 時把 panic 轉成錯誤回傳
+func recoverPanic(name string, err *error) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	serverLog.Error("發生 panic", "name", name, "panic", fmt.Sprint(r), "stack", string(debug.Stack()))
+	if err != nil {
+		*err = fmt.Errorf("%w(%s): %v", errPanic, name, r)
+	}
+}
+
+// recoverHandler 處理器 panic 時回傳 500，而不是中斷連線
+func recoverHandler(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if rec := recover(); rec != nil {
+				if rec == http.ErrAbortHandler {
+					panic(rec)
+				}
+				serverLog.ErrorContext(r.Context(), "處理請求時發生 panic", "method", r.Method, "url", r.URL.String(), "panic", fmt.Sprint(rec), "stack", string(debug.Stack()))
+				writeError(w, r, errPanic)
+			}
+		}()
+		next(w, r)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import "strings"
+
+// 結果分組方式
+const (
+	groupNone   = "none"
+	groupFamily = "family"
+)
+
+// ShoeFamily 同款不同色的商品合併後的結果
+type ShoeFamily struct {
+	Store    string        `json:"store"`
+	FamilyID string        `json:"familyID"`
+	Name     string        `json:"name"`
+	Image    string        `json:"image"`
+	URL      string        `json:"url"`
+	Price    string        `json:"price"`
+	Size     []string      `json:"size"`
+	Status   string        `json:"status"`
+	Colors   []FamilyColor `json:"colors"`
+}
+
+// FamilyColor 同款商品中的一個顏色
+type FamilyColor struct {
+	ListID string   `json:"listID"`
+	Color  string   `json:"color"`
+	Image  string   `json:"image"`
+	URL    string   `json:"url"`
+	Price  string   `json:"price"`
+	Size   []string `json:"size"`
+	Status string   `json:"status"`
+	// 此顏色的庫存未確認
+	StockUnverified bool `json:"stockUnverified,omitempty"`
+}
+
+// 依店鋪與 FamilyID 把同款商品合併，保留第一次出現的順序；沒有 FamilyID 的商品自成一組。
+// 不指定店鋪搜尋時結果混有多家店，各店的編號可能相同，因此以 store/familyID 為 key
+func groupShoesByFamily(shoes []Shoe) []ShoeFamily {
+
+	families := []ShoeFamily{}
+	indexes := map[string]int{}
+
+	for _, shoe := range shoes {
+		familyID := shoe.FamilyID
+		if familyID == "" {
+			familyID = shoe.ListID
+		}
+
+		// 沒有群組顏色名稱時，用頁面上的顏色代替
+		color := shoe.FamilyColor
+		if color == "" {
+			color = strings.Join(shoe.Color, "/")
+		}
+
+		familyColor := FamilyColor{
+			ListID:          shoe.ListID,
+			Color:           color,
+			Image:           shoe.Image,
+			URL:             shoe.URL,
+			Price:           shoe.Price,
+			Size:            shoe.Size,
+			Status:          shoe.Status,
+			StockUnverified: shoe.StockUnverified,
+		}
+
+		key := shoe.Store + "/" + familyID
+		index, exists := indexes[key]
+		if !exists {
+			indexes[key] = len(families)
+			families = append(families, ShoeFamily{
+				Store:    shoe.Store,
+				FamilyID: familyID,
+				Name:     shoe.Name,
+				Image:    shoe.Image,
+				URL:      shoe.URL,
+				Price:    shoe.Price,
+				Size:     mergeUnique(shoe.Size),
+				Status:   shoe.Status,
+				Colors:   []FamilyColor{familyColor},
+			})
+			continue
+		}
+
+		family := &families[index]
+		family.Colors = append(family.Colors, familyColor)
+		family.Size = mergeUnique(family.Size, shoe.Size)
+		// 任一顏色有貨，整款即為有貨
+		if shoe.Status == shoeStatusAvailable && family.Status != shoeStatusAvailable {
+			family.Status = shoeStatusAvailable
+			family.Image = shoe.Image
+			family.URL = shoe.URL
+			family.Price = shoe.Price
+		}
+	}
+
+	return families
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGroupShoesByFamily(t *testing.T) {
+	tests := []struct {
+		name  string
+		shoes []Shoe
+		want  []ShoeFamily
+	}{
+		{
+			name: "同款不同色合併",
+			shoes: []Shoe{
+				{Store: "anns", ListID: "1", Name: "尖頭跟鞋", FamilyID: "1", FamilyColor: "黑", Price: "1980", Size: []string{"25.5"}, Status: shoeStatusSoldOut},
+				{Store: "anns", ListID: "2", Name: "尖頭跟鞋", FamilyID: "1", FamilyColor: "白", Price: "1880", Size: []string{"26", "25.5"}, Status: shoeStatusAvailable},
+			},
+			want: []ShoeFamily{{
+				Store: "anns", FamilyID: "1", Name: "尖頭跟鞋", Price: "1880", Size: []string{"25.5", "26"}, Status: shoeStatusAvailable,
+				Colors: []FamilyColor{
+					{ListID: "1", Color: "黑", Price: "1980", Size: []string{"25.5"}, Status: shoeStatusSoldOut},
+					{ListID: "2", Color: "白", Price: "1880", Size: []string{"26", "25.5"}, Status: shoeStatusAvailable},
+				},
+			}},
+		},
+		{
+			name: "不同店鋪的相同編號不合併",
+			shoes: []Shoe{
+				{Store: "daf", ListID: "31035_4", Name: "麂皮中筒靴", FamilyID: "31035", Color: []string{"咖"}, Status: shoeStatusAvailable},
+				{Store: "shoebox", ListID: "31035_1", Name: "帆布鞋", FamilyID: "31035", Color: []string{"白"}, Status: shoeStatusAvailable},
+				{Store: "daf", ListID: "31035_5", Name: "麂皮中筒靴", FamilyID: "31035", Color: []string{"黑", "灰"}, Status: shoeStatusAvailable},
+			},
+			want: []ShoeFamily{
+				{Store: "daf", FamilyID: "31035", Name: "麂皮中筒靴", Size: []string{}, Status: shoeStatusAvailable, Colors: []FamilyColor{
+					{ListID: "31035_4", Color: "咖", Status: shoeStatusAvailable},
+					{ListID: "31035_5", Color: "黑/灰", Status: shoeStatusAvailable},
+				}},
+				{Store: "shoebox", FamilyID: "31035", Name: "帆布鞋", Size: []string{}, Status: shoeStatusAvailable, Colors: []FamilyColor{
+					{ListID: "31035_1", Color: "白", Status: shoeStatusAvailable},
+				}},
+			},
+		},
+		{
+			name: "沒有 FamilyID 自成一組",
+			shoes: []Shoe{
+				{Store: "anns", ListID: "7", Name: "樂福鞋", Status: shoeStatusComingSoon, StockUnverified: true},
+				{Store: "daf", ListID: "7", Name: "涼鞋", Status: shoeStatusAvailable},
+			},
+			want: []ShoeFamily{
+				{Store: "anns", FamilyID: "7", Name: "樂福鞋", Size: []string{}, Status: shoeStatusComingSoon, Colors: []FamilyColor{
+					{ListID: "7", Status: shoeStatusComingSoon, StockUnverified: true},
+				}},
+				{Store: "daf", FamilyID: "7", Name: "涼鞋", Size: []string{}, Status: shoeStatusAvailable, Colors: []FamilyColor{
+					{ListID: "7", Status: shoeStatusAvailable},
+				}},
+			},
+		},
+		{name: "沒有商品", shoes: nil, want: []ShoeFamily{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := groupShoesByFamily(test.shoes); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("得到 %+v\n應為 %+v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SearchParams /filter 的查詢條件，尺碼、顏色、跟高、款式皆可多選，同一欄位為 OR、不同欄位為 AND
+type SearchParams struct {
+	Store   string
+	OrderBy string
+	Sizes   []string
+	Colors  []string
+	Heels   []string
+	Cats    []string
+	Query   string
+}
+
+// 從 query string 取出查詢條件，多選可用重複參數(searchSize=41&searchSize=42)或逗號分隔(searchSize=41,42)
+func parseSearchParams(query url.Values) SearchParams {
+	return SearchParams{
+		Store:   query.Get("store"),
+		OrderBy: query.Get("orderby"),
+		Sizes:   splitMultiValue(query["searchSize"]),
+		Colors:  splitMultiValue(query["searchColor"]),
+		Heels:   splitMultiValue(query["searchHeel"]),
+		Cats:    splitMultiValue(query["searchCat"]),
+		Query:   strings.TrimSpace(query.Get("q")),
+	}
+}
+
+// SearchRequest 一次搜尋的完整條件，包含爬取條件與爬取後的篩選、分組方式
+type SearchRequest struct {
+	Params          SearchParams
+	Profile         FootProfile
+	HasProfile      bool
+	IncludeSoldOut  bool
+	NewSince        time.Time
+	Group           string
+	AttributeFilter AttributeFilter
+}
+
+// 從 query string 取出並檢查搜尋條件，格式錯誤時回傳的 error 可直接給使用者看；未啟用的店鋪視同未知的商店
+func (s *Server) parseSearchRequest(query url.Values) (SearchRequest, error) {
+
+	var err error
+	request := SearchRequest{Params: parseSearchParams(query)}
+	params := &request.Params
+
+	if params.Store == "" {
+		// 沒指定店鋪但有關鍵字時，直接搜尋所有店鋪已爬過的商品
+		if params.Query == "" {
+			return request, errUnknownStore
+		}
+	} else if !s.config.storeEnabled(params.Store) {
+		return request, errUnknownStore
+	}
+
+	// 尺碼、顏色、跟高、款式、排序必須是該店可接受的值
+	if err := validateSearchParams(*params, s.storeParams(params.Store)); err != nil {
+		return request, err
+	}
+
+	// 有帶腳型且沒指定尺碼時，自動以建議尺碼篩選
+	request.Profile, request.HasProfile, err = parseFootProfile(query)
+	if err != nil {
+		return request, newParamError("footLength", err)
+	}
+	if request.HasProfile && len(params.Sizes) == 0 && params.Store != "" {
+		params.Sizes, err = recommendedSizeCodes(params.Store, params.Cats, request.Profile)
+		if err != nil {
+			return request, err
+		}
+		if len(params.Sizes) == 0 {
+			return request, newParamError("footLength", fmt.Errorf("此腳長沒有合適的尺碼"))
+		}
+		searchLog.Debug("依腳長建議尺碼", "footLength", request.Profile.Length, "sizes", params.Sizes)
+	}
+
+	// 多選展開的上游查詢數有上限，在佔用頻率限制與工作佇列之前就拒絕
+	if err := s.checkQueryCount(*params); err != nil {
+		return request, err
+	}
+
+	// 是否包含售罄/尚未開賣的鞋子，預設不包含
+	if value := query.Get("includeSoldOut"); value != "" {
+		request.IncludeSoldOut, err = strconv.ParseBool(value)
+		if err != nil {
+			return request, newParamError("includeSoldOut", fmt.Errorf("includeSoldOut 應為 true 或 false"))
+		}
+	}
+	// 結果分組方式: none(預設，每個商品一筆) 或 family(同款不同色合併為一筆)
+	request.Group = query.Get("group")
+	if request.Group != "" && request.Group != groupNone && request.Group != groupFamily {
+		return request, newParamError("group", fmt.Errorf("group 應為 family 或 none"))
+	}
+	// 款式屬性篩選，例如 heelCm<=3&toe=round
+	request.AttributeFilter, err = parseAttributeFilter(query)
+	if err != nil {
+		return request, newParamError("", err)
+	}
+	// 只看某日期(含)之後上架的新品
+	if value := query.Get("newSince"); value != "" {
+		request.NewSince, err = parseDateParam(value)
+		if err != nil {
+			return request, newParamError("newSince", fmt.Errorf("newSince 應為 YYYY-MM-DD 格式的日期"))
+		}
+		// 沒有上架時間的商店帶 newSince 一定沒有結果，直接告知
+		if params.Store != "" && !containsString(storesWithListingDate, params.Store) {
+			return request, newParamError("newSince", fmt.Errorf("此商店未提供上架時間，不支援 newSince"))
+		}
+	}
+
+	return request, nil
+}
+
+// 商店一次只能帶一個值時，多選展開的上游查詢數不可超過該店的上限
+func (s *Server) checkQueryCount(params SearchParams) error {
+	if scraper, ok := s.scrapers[params.Store]; ok {
+		return scraper.checkQueryCount(params)
+	}
+	if params.Store == "daf" {
+		return dafCheckQueryCount(params, s.config.DAF.MaxQueries)
+	}
+	return nil
+}
+
+// 依搜尋條件爬取並篩選鞋子，ctx 用來回報進度
+func (s *Server) runSearch(ctx context.Context, request SearchRequest) ([]Shoe, error) {
+
+	var shoes []Shoe
+	var err error
+	params := request.Params
+
+	searchLog.InfoContext(ctx, "開始搜尋", "store", params.Store, "q", params.Query)
+	scraper, hasDefinition := s.scrapers[params.Store]
+	switch {
+	case hasDefinition:
+		shoes, err = scraper.search(ctx, params)
+	case params.Store == "daf":
+		shoes, err = s.daf.getDAFFliterResponse(ctx, params)
+	case params.Store == "anns":
+		shoes, err = s.anns.getAnnsFliterResponse(ctx, params)
+	case params.Store == "":
+		shoes = s.index.searchIndexedShoes(params, request.Profile, request.HasProfile)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// 爬到的鞋子加入搜尋索引，再依關鍵字篩選
+	if params.Store != "" {
+		s.index.addShoes(shoes)
+		shoes = s.index.filterShoesByQuery(shoes, params.Query)
+	}
+
+	shoes = postFilterShoes(request, shoes)
+	searchLog.InfoContext(ctx, "結束狀態篩選", "shoes", len(shoes))
+	metrics.searchProducts.observe(float64(len(shoes)), s.storeLabel(params.Store))
+	return shoes, nil
+}
+
+// 爬取後的屬性與狀態篩選，搜尋工作的部分結果也會套用
+func postFilterShoes(request SearchRequest, shoes []Shoe) []Shoe {
+	shoes = filterShoesByAttributes(shoes, request.AttributeFilter)
+	return filterShoesByStatus(shoes, request.IncludeSoldOut, request.NewSince)
+}
+
+// 依分組方式整理要回傳的結果
+func searchResult(request SearchRequest, shoes []Shoe) interface{} {
+	if request.Group == groupFamily {
+		return groupShoesByFamily(shoes)
+	}
+	return shoes
+}
+
+// 把重複參數與逗號分隔的值攤平，去除空白、空值與重複值
+func splitMultiValue(values []string) []string {
+	var parts []string
+	for _, value := range values {
+		value = strings.ReplaceAll(value, "，", ",")
+		for _, part := range strings.Split(value, ",") {
+			parts = append(parts, strings.TrimSpace(part))
+		}
+	}
+	return mergeUnique(parts)
+}
+
+// 商店提供的時間沒有時區時，一律視為台灣時間
+var taipeiLocation = time.FixedZone("Asia/Taipei", 8*60*60)
+
+// 商店可能回傳的時間格式
+var storeTimeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006/01/02 15:04:05",
+	"2006/01/02 15:04",
+	"2006-01-02",
+	"2006/01/02",
+}
+
+// 解析商店回傳的時間字串，解析不了回傳 false
+func parseStoreTime(value string) (time.Time, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, false
+	}
+	for _, layout := range storeTimeLayouts {
+		if parsed, err := time.ParseInLocation(layout, value, taipeiLocation); err == nil {
+			return parsed, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// 將商店回傳的時間統一轉成 RFC3339，解析不了則回傳空字串
+func normalizeStoreTime(value string) string {
+	parsed, ok := parseStoreTime(value)
+	if !ok {
+		return ""
+	}
+	return parsed.Format(time.RFC3339)
+}
+
+// 有提供上架時間(ListedAt)的店鋪，其他店鋪不支援 newSince
+var storesWithListingDate = []string{"anns"}
+
+// 解析 YYYY-MM-DD 格式的日期參數(台灣時間當天 00:00)
+func parseDateParam(value string) (time.Time, error) {
+	return time.ParseInLocation("2006-01-02", value, taipeiLocation)
+}
+
+// 狀態篩選: 預設排除售罄與尚未開賣的鞋子；newSince 非零值時只保留該時間(含)之後上架的鞋子，沒有上架時間的一併排除
+func filterShoesByStatus(shoes []Shoe, includeSoldOut bool, newSince time.Time) []Shoe {
+	filteredShoes := []Shoe{}
+	for _, shoe := range shoes {
+		if !includeSoldOut && shoe.Status != shoeStatusAvailable {
+			continue
+		}
+		if !newSince.IsZero() {
+			listedAt, ok := parseStoreTime(shoe.ListedAt)
+			if !ok || listedAt.Before(newSince) {
+				continue
+			}
+		}
+		filteredShoes = append(filteredShoes, shoe)
+	}
+	return filteredShoes
+}
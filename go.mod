@@ -3,10 +3,17 @@ module large-size-woman-shoes
 go 1.23.4
 
 require (
+	github.com/PuerkitoBio/goquery v1.9.3
+	github.com/andybalholm/brotli v1.1.1
+	github.com/andybalholm/cascadia v1.3.2
 	github.com/go-rod/rod v0.116.2
+)
+
+require (
 	github.com/ysmood/fetchup v0.2.3 // indirect
 	github.com/ysmood/goob v0.4.0 // indirect
 	github.com/ysmood/got v0.40.0 // indirect
 	github.com/ysmood/gson v0.7.3 // indirect
 	github.com/ysmood/leakless v0.9.0 // indirect
+	golang.org/x/net v0.29.0 // indirect
 )
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+var errNotReady = errors.New("伺服器尚未就緒")
+
+// ReadinessCheck /readyz 的單項檢查，失敗時 Message 說明原因
+type ReadinessCheck struct {
+	Name    string `json:"name"`
+	OK      bool   `json:"ok"`
+	Message string `json:"message,omitempty"`
+}
+
+// healthzHandler 存活檢查，只要程序能回應就是 200: GET /healthz
+func (s *Server) healthzHandler(w http.ResponseWriter, r *http.Request) {
+	writeData(w, r, http.StatusOK, map[string]string{"status": "ok"}, nil)
+}
+
+// readyzHandler 就緒檢查，任一項失敗或正在關閉時回傳 503: GET /readyz
+func (s *Server) readyzHandler(w http.ResponseWriter, r *http.Request) {
+
+	checks := s.readinessChecks()
+	for _, check := range checks {
+		if !check.OK {
+			w.Header().Set("Retry-After", "10")
+			writeAPIResponse(w, http.StatusServiceUnavailable, APIResponse{
+				RequestID: requestIDFrom(r.Context()),
+				Data:      checks,
+				Error:     &APIError{Code: errCodeBusy, Message: errNotReady.Error()},
+			})
+			return
+		}
+	}
+	writeData(w, r, http.StatusOK, checks, nil)
+}
+
+// 設定已載入、快取與商品目錄已建立、啟用的店鋪都有爬蟲、搜尋工作佇列可接受工作
+func (s *Server) readinessChecks() []ReadinessCheck {
+
+	check := func(name string, ok bool, message string) ReadinessCheck {
+		if ok {
+			message = ""
+		}
+		return ReadinessCheck{Name: name, OK: ok, Message: message}
+	}
+
+	checks := []ReadinessCheck{
+		check("config", len(s.config.EnabledStores) > 0, "設定未載入"),
+		check("templates", s.indexTemplate != nil && s.searchTemplate != nil, "頁面模板未解析"),
+		check("productCache", s.productDetails != nil, "商品詳細資訊快取未建立"),
+		check("catalog", s.catalog != nil && s.index != nil, "商品目錄或搜尋索引未建立"),
+	}
+
+	var missing []string
+	for _, store := range s.config.EnabledStores {
+		if !s.storeRegistered(store) {
+			missing = append(missing, store)
+		}
+	}
+	checks = append(checks, check("stores", len(missing) == 0, "店鋪沒有爬蟲: "+strings.Join(missing, "、")))
+
+	// 有店鋪設定要渲染商品頁時，必須找得到瀏覽器
+	if s.config.renderEnabled() {
+		err := s.renderer.available()
+		message := ""
+		if err != nil {
+			message = err.Error()
+		}
+		checks = append(checks, check("renderer", err == nil, message))
+	}
+	checks = append(checks, check("searchJobs", s.jobs != nil && s.jobs.accepting(), "搜尋工作佇列未啟動或正在關閉"))
+	checks = append(checks, check("shutdown", !s.shuttingDown.Load(), "伺服器正在關閉"))
+	return checks
+}
+
+// 店鋪是否有對應的爬蟲，商店定義的店鋪要有 htmlScraper
+func (s *Server) storeRegistered(store string) bool {
+	switch store {
+	case "daf":
+		return s.daf != nil
+	case "anns":
+		return s.anns != nil
+	}
+	_, ok := s.scrapers[store]
+	return ok
+}
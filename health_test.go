@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestReadinessDuringShutdown(t *testing.T) {
+	// 每個商店請求 20ms，搜尋要一段時間才會完成
+	server, _ := newTestAPIServer(t, 20*time.Millisecond)
+	httpServer := httptest.NewUnstartedServer(server.routes())
+	httpServer.Start()
+	defer httpServer.Close()
+
+	if recorder := serveTestRequest(httpServer.Config.Handler, http.MethodGet, "/readyz", nil); recorder.Code != http.StatusOK {
+		t.Fatalf("啟動後 /readyz 應為 200，得到 %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	// 背景搜尋工作在關閉時應做完
+	response, err := http.Post(httpServer.URL+"/searches?store=daf", "application/x-www-form-urlencoded", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var created APIResponse
+	json.NewDecoder(response.Body).Decode(&created)
+	response.Body.Close()
+	if response.StatusCode != http.StatusAccepted {
+		t.Fatalf("建立搜尋工作狀態碼 %d", response.StatusCode)
+	}
+	jobID := created.Data.(map[string]interface{})["id"].(string)
+
+	stopped := make(chan error, 1)
+	go func() { stopped <- server.shutdown(httpServer.Config) }()
+	for server.jobs.accepting() {
+		time.Sleep(time.Millisecond)
+	}
+
+	// 關閉中仍存活，但不再就緒
+	handler := httpServer.Config.Handler
+	if recorder := serveTestRequest(handler, http.MethodGet, "/healthz", nil); recorder.Code != http.StatusOK {
+		t.Errorf("關閉中 /healthz 應為 200，得到 %d", recorder.Code)
+	}
+	recorder := serveTestRequest(handler, http.MethodGet, "/readyz", nil)
+	var readiness struct {
+		Data  []ReadinessCheck `json:"data"`
+		Error *APIError        `json:"error"`
+	}
+	json.NewDecoder(recorder.Body).Decode(&readiness)
+	if recorder.Code != http.StatusServiceUnavailable || recorder.Header().Get("Retry-After") == "" || readiness.Error == nil {
+		t.Errorf("關閉中 /readyz 應為 503 並帶 Retry-After，得到 %d", recorder.Code)
+	}
+	failed := map[string]bool{}
+	for _, check := range readiness.Data {
+		failed[check.Name] = !check.OK
+	}
+	if !failed["shutdown"] || !failed["searchJobs"] || failed["stores"] {
+		t.Errorf("未通過的檢查不符: %v", failed)
+	}
+
+	if err := <-stopped; err != nil {
+		t.Fatalf("關閉應等搜尋工作完成，得到 %v", err)
+	}
+	job, ok := server.jobs.get(jobID)
+	if !ok {
+		t.Fatalf("找不到搜尋工作 %s", jobID)
+	}
+	if view, _ := job.view(PageRequest{}); view.Status != jobStatusDone || view.Result == nil {
+		t.Errorf("關閉前搜尋工作應完成，得到 %s", view.Status)
+	}
+}
@@ -0,0 +1,598 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 搜尋工作狀態
+const (
+	jobStatusQueued  = "queued"
+	jobStatusRunning = "running"
+	jobStatusDone    = "done"
+	jobStatusFailed  = "failed"
+)
+
+var errSearchQueueFull = errors.New("目前查詢的人太多，請稍後再試")
+
+var errShuttingDown = errors.New("伺服器正在關閉，請稍後再試")
+
+// 佇列滿時 Retry-After 的上限，以及還沒有工作完成時估計的每個工作執行時間
+const (
+	maxQueueRetryAfter     = time.Minute
+	defaultJobDurationHint = 10 * time.Second
+)
+
+var errSearchJobNotFound = errors.New("找不到搜尋工作，可能已過期")
+
+// JobProgress 搜尋工作進度，Stage 為 list(爬列表)、detail(爬商品頁) 或 stock(查庫存)
+type JobProgress struct {
+	Stage string `json:"stage"`
+	Done  int    `json:"done"`
+	Total int    `json:"total"`
+}
+
+// searchJob 一個排進佇列的搜尋工作
+type searchJob struct {
+	ID string
+	// 建立工作的請求
+	requestID string
+	request   SearchRequest
+
+	mu         sync.Mutex
+	status     string
+	progress   JobProgress
+	partial    []Shoe
+	partialIDs map[string]int
+	warnings   []Warning
+	shoes      []Shoe
+	err        error
+	createdAt  time.Time
+	startedAt  time.Time
+	finishedAt time.Time
+
+	// 工作完成(成功或失敗)時關閉
+	done chan struct{}
+}
+
+// SearchJobView GET /searches/{id} 回傳的內容
+type SearchJobView struct {
+	ID         string      `json:"id"`
+	Status     string      `json:"status"`
+	Progress   JobProgress `json:"progress"`
+	Partial    interface{} `json:"partial,omitempty"`
+	Result     interface{} `json:"result,omitempty"`
+	Error      *APIError   `json:"error,omitempty"`
+	CreatedAt  time.Time   `json:"createdAt"`
+	StartedAt  *time.Time  `json:"startedAt,omitempty"`
+	FinishedAt *time.Time  `json:"finishedAt,omitempty"`
+}
+
+// searchRunner 執行一次搜尋，ctx 帶有回報進度用的搜尋工作
+type searchRunner func(ctx context.Context, request SearchRequest) ([]Shoe, error)
+
+// jobManager 以固定數量的 worker 執行搜尋工作，完成的工作保留一段時間供查詢
+type jobManager struct {
+	mu    sync.Mutex
+	jobs  map[string]*searchJob
+	queue chan *searchJob
+	// 搜尋條件對應最近一次的工作，相同條件的搜尋沿用執行中或 resultTTL 內完成的工作
+	cached    map[string]*searchJob
+	workers   int
+	retention time.Duration
+	resultTTL time.Duration
+	runSearch searchRunner
+	// 限制每個客戶端發起新搜尋的頻率，沿用既有工作不計
+	limiter   *rateLimiter
+	startOnce sync.Once
+	// 最近完成的工作平均執行時間，用來估計佇列滿時要客戶端等多久
+	averageDuration time.Duration
+	// 搜尋工作的 context，關閉時超過期限就取消，中斷還在爬的工作
+	ctx    context.Context
+	cancel context.CancelFunc
+	// 關閉時停止清除過期工作的 goroutine
+	stopCleanup chan struct{}
+	// started 在 start 後為 true，closed 在 shutdown 後為 true，不再接受新工作
+	started bool
+	closed  bool
+	working sync.WaitGroup
+}
+
+// 依設定的 worker 數、佇列大小與保留時間建立工作佇列，run 為實際執行搜尋的函式
+func newJobManager(config SearchConfig, limiter *rateLimiter, run searchRunner) *jobManager {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &jobManager{
+		jobs:        map[string]*searchJob{},
+		queue:       make(chan *searchJob, config.QueueSize),
+		cached:      map[string]*searchJob{},
+		workers:     config.Workers,
+		retention:   config.JobRetention.Duration,
+		resultTTL:   config.ResultTTL.Duration,
+		runSearch:   run,
+		limiter:     limiter,
+		ctx:         ctx,
+		cancel:      cancel,
+		stopCleanup: make(chan struct{}),
+	}
+}
+
+// 啟動 worker 與清除過期工作的 goroutine
+func (manager *jobManager) start() {
+	manager.startOnce.Do(func() {
+		searchLog.Info("搜尋工作佇列啟動", "workers", manager.workers, "retention", manager.retention.String(), "resultTTL", manager.resultTTL.String())
+		manager.mu.Lock()
+		manager.started = true
+		manager.mu.Unlock()
+		manager.working.Add(manager.workers)
+		for i := 0; i < manager.workers; i++ {
+			go manager.work()
+		}
+		go manager.cleanup()
+	})
+}
+
+// 建立搜尋工作並排進佇列，相同條件的工作還在執行或結果未過期時直接沿用。
+// 佇列滿了回傳 errSearchQueueFull，客戶端太頻繁回傳 errRateLimited(兩者都包在 RetryAfterError 中)，關閉中回傳 errShuttingDown。
+// ctx 為發起的請求，工作執行時的 log 會帶上它的 request ID
+func (manager *jobManager) submit(ctx context.Context, request SearchRequest) (*searchJob, error) {
+
+	key := searchCacheKey(request)
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	job, ok := manager.cached[key]
+	reuse := ok && manager.reusable(job)
+	metrics.cacheLookup("searchResult", reuse)
+	if reuse {
+		searchLog.InfoContext(ctx, "沿用搜尋工作", "jobId", job.ID)
+		return job, nil
+	}
+	if manager.closed {
+		metrics.searchRejected.add(1, "shutting_down")
+		return nil, errShuttingDown
+	}
+	// 先確認佇列有空位，免得佇列滿時還用掉客戶端的次數；只有持有 mu 時會送進佇列
+	if len(manager.queue) == cap(manager.queue) {
+		metrics.searchRejected.add(1, "queue_full")
+		searchLog.WarnContext(ctx, "搜尋工作佇列已滿", "queued", len(manager.queue))
+		return nil, manager.queueFullError()
+	}
+	if err := manager.limiter.allow(ctx); err != nil {
+		metrics.searchRejected.add(1, "rate_limited")
+		searchLog.InfoContext(ctx, "客戶端發起搜尋太頻繁", "clientIp", clientIPFrom(ctx).String())
+		return nil, err
+	}
+
+	job = &searchJob{
+		ID:         newRandomID(),
+		requestID:  requestIDFrom(ctx),
+		request:    request,
+		status:     jobStatusQueued,
+		partialIDs: map[string]int{},
+		createdAt:  time.Now(),
+		done:       make(chan struct{}),
+	}
+
+	manager.queue <- job
+	manager.jobs[job.ID] = job
+	manager.cached[key] = job
+
+	searchLog.InfoContext(ctx, "搜尋工作已排入佇列", "jobId", job.ID)
+	return job, nil
+}
+
+// 工作是否可給相同條件的搜尋沿用: 排隊或執行中，或成功完成且未超過 resultTTL；呼叫時須持有 manager.mu
+func (manager *jobManager) reusable(job *searchJob) bool {
+	if _, ok := manager.jobs[job.ID]; !ok {
+		return false
+	}
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	switch job.status {
+	case jobStatusQueued, jobStatusRunning:
+		return true
+	case jobStatusDone:
+		return time.Since(job.finishedAt) <= manager.resultTTL
+	}
+	return false
+}
+
+// 搜尋條件的快取 key；不分組與預設分組的結果相同，視為同一種條件
+func searchCacheKey(request SearchRequest) string {
+	if request.Group == "" {
+		request.Group = groupNone
+	}
+	b, _ := json.Marshal(request)
+	return string(b)
+}
+
+// 取出搜尋工作，不存在或已過期回傳 false
+func (manager *jobManager) get(id string) (*searchJob, bool) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+	job, ok := manager.jobs[id]
+	return job, ok
+}
+
+func (manager *jobManager) work() {
+	defer manager.working.Done()
+	for job := range manager.queue {
+		job.run(manager.ctx, manager.runSearch)
+		manager.recordDuration(job)
+	}
+}
+
+// 以指數移動平均記下工作的執行時間
+func (manager *jobManager) recordDuration(job *searchJob) {
+	job.mu.Lock()
+	duration := job.finishedAt.Sub(job.startedAt)
+	job.mu.Unlock()
+
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+	if manager.averageDuration == 0 {
+		manager.averageDuration = duration
+		return
+	}
+	manager.averageDuration = (manager.averageDuration*4 + duration) / 5
+}
+
+// 佇列滿時的錯誤，依平均執行時間估計排在前面的工作輪完需要多久；呼叫時須持有 manager.mu
+func (manager *jobManager) queueFullError() error {
+	average := manager.averageDuration
+	if average == 0 {
+		average = defaultJobDurationHint
+	}
+	wait := average * time.Duration(len(manager.queue)/manager.workers+1)
+	return &RetryAfterError{Err: errSearchQueueFull, RetryAfter: min(wait, maxQueueRetryAfter)}
+}
+
+// 是否可接受新的搜尋工作
+func (manager *jobManager) accepting() bool {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+	return manager.started && !manager.closed
+}
+
+// shutdown 不再接受新工作，等排隊與執行中的工作完成；ctx 到期時取消剩下的工作並回傳 ctx 的錯誤
+func (manager *jobManager) shutdown(ctx context.Context) error {
+
+	manager.mu.Lock()
+	if !manager.closed {
+		manager.closed = true
+		// submit 持有 mu 才會送進佇列，關閉後不會再有人送
+		close(manager.queue)
+		close(manager.stopCleanup)
+	}
+	remaining := len(manager.queue)
+	manager.mu.Unlock()
+
+	searchLog.Info("等待搜尋工作完成", "queued", remaining)
+	drained := make(chan struct{})
+	go func() {
+		manager.working.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		searchLog.Info("搜尋工作已全部完成")
+		return nil
+	case <-ctx.Done():
+		manager.cancel()
+		searchLog.Warn("等待搜尋工作逾時，取消剩下的工作", "queued", len(manager.queue))
+		return ctx.Err()
+	}
+}
+
+// 定期清除完成超過保留時間的工作，shutdown 後結束
+func (manager *jobManager) cleanup() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-manager.stopCleanup:
+			return
+		case <-ticker.C:
+		}
+		manager.removeExpired()
+	}
+}
+
+// 移除完成超過保留時間的工作，以及不能再沿用的快取
+func (manager *jobManager) removeExpired() {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+	for id, job := range manager.jobs {
+		job.mu.Lock()
+		expired := !job.finishedAt.IsZero() && time.Since(job.finishedAt) > manager.retention
+		job.mu.Unlock()
+		if expired {
+			delete(manager.jobs, id)
+		}
+	}
+	for key, job := range manager.cached {
+		if !manager.reusable(job) {
+			delete(manager.cached, key)
+		}
+	}
+}
+
+// parent 為 jobManager 的 context，關閉逾時時會被取消
+func (job *searchJob) run(parent context.Context, runSearch searchRunner) {
+	job.mu.Lock()
+	job.status = jobStatusRunning
+	job.startedAt = time.Now()
+	job.mu.Unlock()
+
+	// 工作可能被多個請求沿用，log 帶的是建立工作的 request ID
+	ctx := context.WithValue(parent, progressKey{}, job)
+	ctx = context.WithValue(ctx, requestIDKey{}, job.requestID)
+	searchLog.InfoContext(ctx, "搜尋工作開始執行")
+	shoes, err := job.execute(ctx, runSearch)
+
+	job.mu.Lock()
+	job.finishedAt = time.Now()
+	if err != nil {
+		job.status = jobStatusFailed
+		job.err = err
+	} else {
+		job.status = jobStatusDone
+		job.shoes = shoes
+	}
+	// 結果出來後就不需要部分結果了
+	job.partial = nil
+	job.partialIDs = nil
+	job.mu.Unlock()
+	close(job.done)
+
+	attrs := []any{"status", job.status, "durationMs", job.finishedAt.Sub(job.startedAt).Milliseconds()}
+	if err != nil {
+		// 回傳給客戶端的 internal_error 只有通用訊息，完整錯誤記在這裡
+		searchLog.WarnContext(ctx, "搜尋工作結束", append(attrs, "error", err)...)
+		return
+	}
+	searchLog.InfoContext(ctx, "搜尋工作結束", attrs...)
+}
+
+// 執行搜尋，panic 時工作標記為失敗而不影響其他工作
+func (job *searchJob) execute(ctx context.Context, runSearch searchRunner) (shoes []Shoe, err error) {
+	defer recoverPanic("搜尋工作 "+job.ID, &err)
+	return runSearch(ctx, job.request)
+}
+
+// 工作完成後依排序、分頁方式切出的結果與個別商品的警告
+func (job *searchJob) result(page PageRequest) (interface{}, *PageInfo, []Warning, error) {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	if job.err != nil {
+		return nil, nil, job.warnings, job.err
+	}
+	result, info := pageResult(job.request, job.shoes, page, job.ID)
+	return result, &info, job.warnings, nil
+}
+
+// 工作完成後未分組的鞋子，給需要自行分頁、排版的頁面使用
+func (job *searchJob) shoesResult() ([]Shoe, []Warning, error) {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	return job.shoes, job.warnings, job.err
+}
+
+// 目前為止的警告
+func (job *searchJob) currentWarnings() []Warning {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	return append([]Warning(nil), job.warnings...)
+}
+
+// 工作目前的狀態，完成時結果依 page 排序、分頁
+func (job *searchJob) view(page PageRequest) (SearchJobView, *PageInfo) {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+
+	view := SearchJobView{
+		ID:        job.ID,
+		Status:    job.status,
+		Progress:  job.progress,
+		CreatedAt: job.createdAt,
+	}
+	if !job.startedAt.IsZero() {
+		startedAt := job.startedAt
+		view.StartedAt = &startedAt
+	}
+	if !job.finishedAt.IsZero() {
+		finishedAt := job.finishedAt
+		view.FinishedAt = &finishedAt
+	}
+
+	switch job.status {
+	case jobStatusRunning:
+		// 部分結果只套用爬取後的篩選，尚未依關鍵字排序
+		view.Partial = searchResult(job.request, postFilterShoes(job.request, job.partial))
+	case jobStatusDone:
+		result, info := pageResult(job.request, job.shoes, page, job.ID)
+		view.Result = result
+		return view, &info
+	case jobStatusFailed:
+		_, apiError := classifyError(job.err)
+		view.Error = &apiError
+	}
+	return view, nil
+}
+
+// 搜尋工作透過 context 接收爬蟲回報的進度
+type progressKey struct{}
+
+// 回報目前階段的進度
+func reportProgress(ctx context.Context, stage string, done, total int) {
+	job, ok := ctx.Value(progressKey{}).(*searchJob)
+	if !ok {
+		return
+	}
+	job.mu.Lock()
+	job.progress = JobProgress{Stage: stage, Done: done, Total: total}
+	job.mu.Unlock()
+}
+
+// 回報已取得尺碼、顏色的鞋子，作為部分結果
+func reportPartial(ctx context.Context, shoes ...Shoe) {
+	job, ok := ctx.Value(progressKey{}).(*searchJob)
+	if !ok {
+		return
+	}
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	if job.partialIDs == nil {
+		return
+	}
+	for _, shoe := range shoes {
+		if index, exists := job.partialIDs[shoe.ListID]; exists {
+			job.partial[index] = shoe
+			continue
+		}
+		job.partialIDs[shoe.ListID] = len(job.partial)
+		job.partial = append(job.partial, shoe)
+	}
+}
+
+// 回報個別商品取得資料失敗
+func reportWarning(ctx context.Context, warning Warning) {
+	job, ok := ctx.Value(progressKey{}).(*searchJob)
+	if !ok {
+		return
+	}
+	job.mu.Lock()
+	job.warnings = append(job.warnings, warning)
+	job.mu.Unlock()
+}
+
+func newRandomID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(b)
+}
+
+// createSearchHandler 建立搜尋工作: POST /searches，條件與 /filter 相同，可放在 query string、表單或 JSON body
+func (s *Server) createSearchHandler(w http.ResponseWriter, r *http.Request) {
+
+	query, err := searchFormValues(w, r)
+	if err != nil {
+		writeError(w, r, newParamError("", err))
+		return
+	}
+
+	request, err := s.parseSearchRequest(query)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	job, err := s.jobs.submit(r.Context(), request)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// 返回工作編號與查詢狀態的網址；沿用已完成的工作時 view 直接帶有結果
+	w.Header().Set("Location", "/searches/"+job.ID)
+	view, page := job.view(PageRequest{})
+	writePage(w, r, http.StatusAccepted, view, page, nil)
+}
+
+// searchStatusHandler 查詢搜尋工作的狀態、進度與(部分)結果: GET /searches/{id}，完成的結果可帶 sort、page、pageSize
+func (s *Server) searchStatusHandler(w http.ResponseWriter, r *http.Request) {
+
+	job, ok := s.jobs.get(r.PathValue("id"))
+	if !ok {
+		writeError(w, r, errSearchJobNotFound)
+		return
+	}
+
+	// cursor 只用來翻頁，必須屬於同一個工作
+	pageRequest, jobID, err := parsePageRequest(r.URL.Query(), 0)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if jobID != "" && jobID != job.ID {
+		writeError(w, r, newParamError("cursor", errors.New("cursor 不屬於此搜尋工作")))
+		return
+	}
+
+	// 返回 JSON 結果
+	view, page := job.view(pageRequest)
+	writePage(w, r, http.StatusOK, view, page, job.currentWarnings())
+}
+
+// POST /searches 的 body 上限，搜尋條件不會超過幾 KB
+const maxSearchBodyBytes = 64 << 10
+
+var errSearchBodyTooLarge = fmt.Errorf("搜尋條件超過 %d KB", maxSearchBodyBytes>>10)
+
+// 取出 POST /searches 的搜尋條件: query string 加上表單，或 JSON 物件(值可為字串、數字、布林或字串陣列)
+func searchFormValues(w http.ResponseWriter, r *http.Request) (url.Values, error) {
+
+	// 公開的路由，表單與 JSON 都只讀到上限為止
+	r.Body = http.MaxBytesReader(w, r.Body, maxSearchBodyBytes)
+	var tooLarge *http.MaxBytesError
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "application/json" {
+		if err := r.ParseForm(); err != nil {
+			if errors.As(err, &tooLarge) {
+				return nil, errSearchBodyTooLarge
+			}
+			return nil, errors.New("無法解析表單")
+		}
+		return r.Form, nil
+	}
+
+	var body map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		if errors.As(err, &tooLarge) {
+			return nil, errSearchBodyTooLarge
+		}
+		return nil, errors.New("JSON 格式錯誤")
+	}
+	query := r.URL.Query()
+	for key, value := range body {
+		switch v := value.(type) {
+		case []interface{}:
+			for _, item := range v {
+				query.Add(key, jsonScalar(item))
+			}
+		default:
+			query.Set(key, jsonScalar(v))
+		}
+	}
+	return query, nil
+}
+
+// JSON 的純量值轉成 query string 的值
+func jsonScalar(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case nil:
+		return ""
+	}
+	b, _ := json.Marshal(value)
+	return string(b)
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 測試用的搜尋: 收到 release 前不會結束，ctx 被取消時回傳 ctx 的錯誤
+type blockingRunner struct {
+	release chan struct{}
+	started chan SearchRequest
+	calls   atomic.Int32
+}
+
+func newBlockingRunner() *blockingRunner {
+	return &blockingRunner{release: make(chan struct{}), started: make(chan SearchRequest, 16)}
+}
+
+func (runner *blockingRunner) run(ctx context.Context, request SearchRequest) ([]Shoe, error) {
+	runner.calls.Add(1)
+	runner.started <- request
+	reportProgress(ctx, "list", 1, 2)
+	reportPartial(ctx, Shoe{ListID: "1_1", Store: request.Params.Store})
+	select {
+	case <-runner.release:
+		return []Shoe{{ListID: "1_1", Store: request.Params.Store}}, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func newTestJobManager(config SearchConfig, run searchRunner) *jobManager {
+	return newJobManager(config, newRateLimiter(RateLimitConfig{}), run)
+}
+
+func searchRequestFor(sizes ...string) SearchRequest {
+	return SearchRequest{Params: SearchParams{Store: "daf", Sizes: sizes}}
+}
+
+func waitJob(t *testing.T, job *searchJob) {
+	t.Helper()
+	select {
+	case <-job.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("搜尋工作沒有結束")
+	}
+}
+
+func TestJobManagerSubmitAndReuse(t *testing.T) {
+	runner := newBlockingRunner()
+	manager := newTestJobManager(SearchConfig{Workers: 2, QueueSize: 4, JobRetention: Duration{time.Hour}, ResultTTL: Duration{time.Hour}}, runner.run)
+	manager.start()
+	defer manager.shutdown(context.Background())
+
+	first, err := manager.submit(context.Background(), searchRequestFor("13"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-runner.started
+
+	// 執行中的相同條件沿用同一個工作，不分組與預設分組視為相同
+	request := searchRequestFor("13")
+	request.Group = groupNone
+	second, err := manager.submit(context.Background(), request)
+	if err != nil || second != first {
+		t.Fatalf("執行中應沿用工作 %s，得到 %v %v", first.ID, second, err)
+	}
+	view, _ := first.view(PageRequest{})
+	if view.Status != jobStatusRunning || view.Progress.Stage != "list" || view.Partial == nil {
+		t.Errorf("執行中的狀態 %+v", view)
+	}
+
+	// 不同條件另外建立工作
+	other, err := manager.submit(context.Background(), searchRequestFor("14"))
+	if err != nil || other == first {
+		t.Fatalf("不同條件應建立新工作，得到 %v %v", other, err)
+	}
+	<-runner.started
+
+	close(runner.release)
+	waitJob(t, first)
+	waitJob(t, other)
+	view, page := first.view(PageRequest{})
+	if view.Status != jobStatusDone || view.Result == nil || page == nil || page.Total != 1 {
+		t.Errorf("完成的狀態 %+v %+v", view, page)
+	}
+
+	// 完成且在 resultTTL 內也沿用
+	third, err := manager.submit(context.Background(), searchRequestFor("13"))
+	if err != nil || third != first {
+		t.Fatalf("結果未過期應沿用工作，得到 %v %v", third, err)
+	}
+	if calls := runner.calls.Load(); calls != 2 {
+		t.Errorf("執行了 %d 次搜尋，應為 2 次", calls)
+	}
+	if job, ok := manager.get(first.ID); !ok || job != first {
+		t.Error("應可用編號取得工作")
+	}
+}
+
+func TestJobManagerResultTTL(t *testing.T) {
+	runner := newBlockingRunner()
+	close(runner.release)
+	// resultTTL 為 0 時只合併執行中的搜尋
+	manager := newTestJobManager(SearchConfig{Workers: 1, QueueSize: 4, JobRetention: Duration{time.Hour}}, runner.run)
+	manager.start()
+	defer manager.shutdown(context.Background())
+
+	first, err := manager.submit(context.Background(), searchRequestFor("13"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitJob(t, first)
+	time.Sleep(time.Millisecond)
+	second, err := manager.submit(context.Background(), searchRequestFor("13"))
+	if err != nil || second == first {
+		t.Fatalf("結果過期後應建立新工作，得到 %v %v", second, err)
+	}
+	waitJob(t, second)
+}
+
+func TestJobManagerQueueFull(t *testing.T) {
+	runner := newBlockingRunner()
+	// 沒有啟動 worker，排進去的工作不會被取走
+	manager := newTestJobManager(SearchConfig{Workers: 1, QueueSize: 1, JobRetention: Duration{time.Hour}}, runner.run)
+
+	if _, err := manager.submit(context.Background(), searchRequestFor("13")); err != nil {
+		t.Fatal(err)
+	}
+	// 相同條件沿用排隊中的工作，不受佇列大小限制
+	if _, err := manager.submit(context.Background(), searchRequestFor("13")); err != nil {
+		t.Fatalf("應沿用排隊中的工作，得到 %v", err)
+	}
+
+	_, err := manager.submit(context.Background(), searchRequestFor("14"))
+	var retryError *RetryAfterError
+	if !errors.Is(err, errSearchQueueFull) || !errors.As(err, &retryError) {
+		t.Fatalf("佇列滿時應回傳 errSearchQueueFull，得到 %v", err)
+	}
+	// 還沒有完成的工作時以預設的執行時間估計
+	if retryError.RetryAfter != 2*defaultJobDurationHint {
+		t.Errorf("Retry-After %v，應為 %v", retryError.RetryAfter, 2*defaultJobDurationHint)
+	}
+	if status, _ := classifyError(err); status != http.StatusServiceUnavailable {
+		t.Errorf("狀態碼 %d，應為 503", status)
+	}
+}
+
+func TestJobManagerRemoveExpired(t *testing.T) {
+	runner := newBlockingRunner()
+	close(runner.release)
+	manager := newTestJobManager(SearchConfig{Workers: 1, QueueSize: 4, JobRetention: Duration{time.Hour}, ResultTTL: Duration{time.Hour}}, runner.run)
+	manager.start()
+	defer manager.shutdown(context.Background())
+
+	old, _ := manager.submit(context.Background(), searchRequestFor("13"))
+	recent, _ := manager.submit(context.Background(), searchRequestFor("14"))
+	waitJob(t, old)
+	waitJob(t, recent)
+
+	// old 在保留時間之前就完成
+	old.mu.Lock()
+	old.finishedAt = time.Now().Add(-2 * time.Hour)
+	old.mu.Unlock()
+	manager.removeExpired()
+
+	if _, ok := manager.get(old.ID); ok {
+		t.Error("超過保留時間的工作應被移除")
+	}
+	if _, ok := manager.get(recent.ID); !ok {
+		t.Error("保留時間內的工作不應被移除")
+	}
+	if len(manager.cached) != 1 {
+		t.Errorf("快取剩 %d 筆，應為 1 筆", len(manager.cached))
+	}
+	// 移除後相同條件重新搜尋
+	again, err := manager.submit(context.Background(), searchRequestFor("13"))
+	if err != nil || again == old {
+		t.Errorf("移除後應建立新工作，得到 %v %v", again, err)
+	}
+}
+
+func TestJobManagerShutdownDrains(t *testing.T) {
+	runner := newBlockingRunner()
+	manager := newTestJobManager(SearchConfig{Workers: 1, QueueSize: 4, JobRetention: Duration{time.Hour}}, runner.run)
+	manager.start()
+
+	running, _ := manager.submit(context.Background(), searchRequestFor("13"))
+	<-runner.started
+	queued, _ := manager.submit(context.Background(), searchRequestFor("14"))
+
+	stopped := make(chan error)
+	go func() { stopped <- manager.shutdown(context.Background()) }()
+
+	// 關閉中不接受新工作，但排隊與執行中的工作會做完
+	for manager.accepting() {
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := manager.submit(context.Background(), searchRequestFor("15")); !errors.Is(err, errShuttingDown) {
+		t.Errorf("關閉中應回傳 errShuttingDown，得到 %v", err)
+	}
+	select {
+	case err := <-stopped:
+		t.Fatalf("工作還沒完成 shutdown 就回傳了: %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(runner.release)
+	if err := <-stopped; err != nil {
+		t.Fatal(err)
+	}
+	for _, job := range []*searchJob{running, queued} {
+		if view, _ := job.view(PageRequest{}); view.Status != jobStatusDone {
+			t.Errorf("工作 %s 狀態 %s，應為完成", job.ID, view.Status)
+		}
+	}
+}
+
+func TestJobManagerShutdownTimeout(t *testing.T) {
+	runner := newBlockingRunner()
+	manager := newTestJobManager(SearchConfig{Workers: 1, QueueSize: 4, JobRetention: Duration{time.Hour}}, runner.run)
+	manager.start()
+
+	job, _ := manager.submit(context.Background(), searchRequestFor("13"))
+	<-runner.started
+
+	// 期限到了就取消還在執行的工作
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := manager.shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("應回傳 DeadlineExceeded，得到 %v", err)
+	}
+	waitJob(t, job)
+	if _, _, err := job.shoesResult(); !errors.Is(err, context.Canceled) {
+		t.Errorf("工作應被取消，得到 %v", err)
+	}
+}
+
+func TestJobManagerRecoversPanic(t *testing.T) {
+	manager := newTestJobManager(SearchConfig{Workers: 1, QueueSize: 4, JobRetention: Duration{time.Hour}}, func(ctx context.Context, request SearchRequest) ([]Shoe, error) {
+		panic("壞掉的商品資料")
+	})
+	manager.start()
+	defer manager.shutdown(context.Background())
+
+	job, _ := manager.submit(context.Background(), searchRequestFor("13"))
+	waitJob(t, job)
+	view, _ := job.view(PageRequest{})
+	if view.Status != jobStatusFailed || view.Error == nil || view.Error.Code != errCodeInternal {
+		t.Errorf("panic 的工作應標記為失敗，得到 %+v", view)
+	}
+}
+
+func TestSearchFormValues(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		want        map[string][]string
+		wantErr     error
+	}{
+		{
+			name:        "JSON",
+			contentType: "application/json",
+			body:        `{"store": "daf", "searchSize": ["13", "14"], "includeSoldOut": true, "footLength": 25.5}`,
+			want:        map[string][]string{"store": {"daf"}, "searchSize": {"13", "14"}, "includeSoldOut": {"true"}, "footLength": {"25.5"}},
+		},
+		{
+			name:        "表單",
+			contentType: "application/x-www-form-urlencoded",
+			body:        "store=daf&searchSize=13",
+			want:        map[string][]string{"store": {"daf"}, "searchSize": {"13"}},
+		},
+		{name: "JSON 太大", contentType: "application/json", body: `{"q": "` + strings.Repeat("鞋", maxSearchBodyBytes) + `"}`, wantErr: errSearchBodyTooLarge},
+		{name: "表單太大", contentType: "application/x-www-form-urlencoded", body: "q=" + strings.Repeat("a", maxSearchBodyBytes), wantErr: errSearchBodyTooLarge},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPost, "/searches", strings.NewReader(test.body))
+			request.Header.Set("Content-Type", test.contentType)
+			query, err := searchFormValues(httptest.NewRecorder(), request)
+			if test.wantErr != nil {
+				if !errors.Is(err, test.wantErr) {
+					t.Fatalf("應回傳 %v，得到 %v", test.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for key, values := range test.want {
+				if got := query[key]; strings.Join(got, ",") != strings.Join(values, ",") {
+					t.Errorf("%s = %v，應為 %v", key, got, values)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// log 子系統，各自可設定等級
+const (
+	// 啟動、設定與每個進來的 HTTP 請求
+	logServer = "server"
+	// 搜尋工作與爬取後的篩選
+	logSearch = "search"
+	// 各店爬蟲
+	logDAF  = "daf"
+	logAnns = "anns"
+	// 以商店定義爬取的商店，log 帶有 store
+	logScraper = "scraper"
+	// 單一商品詳細資訊
+	logProduct = "product"
+	// 每個向商店發出的請求
+	logUpstream = "upstream"
+	// 無頭瀏覽器渲染
+	logRender = "render"
+)
+
+var logSubsystems = []string{logServer, logSearch, logDAF, logAnns, logScraper, logProduct, logUpstream, logRender}
+
+// 各子系統目前的等級，configureLogging 依設定調整
+var logLevels = map[string]*slog.LevelVar{}
+
+// 所有子系統共用的 JSON 輸出，等級由 subsystemHandler 判斷
+var logOutput = slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{
+	Level:       slog.LevelDebug,
+	ReplaceAttr: redactLogAttr,
+})
+
+var (
+	serverLog   = newSubsystemLogger(logServer)
+	searchLog   = newSubsystemLogger(logSearch)
+	dafLog      = newSubsystemLogger(logDAF)
+	annsLog     = newSubsystemLogger(logAnns)
+	scraperLog  = newSubsystemLogger(logScraper)
+	productLog  = newSubsystemLogger(logProduct)
+	upstreamLog = newSubsystemLogger(logUpstream)
+	renderLog   = newSubsystemLogger(logRender)
+)
+
+func newSubsystemLogger(subsystem string) *slog.Logger {
+	level := &slog.LevelVar{}
+	logLevels[subsystem] = level
+	return slog.New(subsystemHandler{level: level, next: logOutput}).With("subsystem", subsystem)
+}
+
+// 依設定調整各子系統的等級，並讓其他套件的 log 也輸出成 JSON
+func configureLogging(config LogConfig) {
+	defaultLevel, _ := parseLogLevel(config.Level)
+	for subsystem, level := range logLevels {
+		level.Set(defaultLevel)
+		if value, ok := config.Levels[subsystem]; ok {
+			subsystemLevel, _ := parseLogLevel(value)
+			level.Set(subsystemLevel)
+		}
+	}
+	slog.SetDefault(serverLog)
+}
+
+// 等級名稱轉成 slog.Level，接受 debug、info、warn、error(不分大小寫)
+func parseLogLevel(value string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(value)); err != nil {
+		return slog.LevelInfo, fmt.Errorf("log 等級應為 debug、info、warn 或 error: %q", value)
+	}
+	return level, nil
+}
+
+// subsystemHandler 依子系統的等級過濾，並從 context 帶入 request ID 與搜尋工作編號
+type subsystemHandler struct {
+	level *slog.LevelVar
+	next  slog.Handler
+}
+
+func (h subsystemHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h subsystemHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id := requestIDFrom(ctx); id != "" {
+		record.AddAttrs(slog.String("requestId", id))
+	}
+	if job, ok := ctx.Value(progressKey{}).(*searchJob); ok {
+		record.AddAttrs(slog.String("jobId", job.ID))
+	}
+	return h.next.Handle(ctx, record)
+}
+
+func (h subsystemHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return subsystemHandler{level: h.level, next: h.next.WithAttrs(attrs)}
+}
+
+func (h subsystemHandler) WithGroup(name string) slog.Handler {
+	return subsystemHandler{level: h.level, next: h.next.WithGroup(name)}
+}
+
+// log 中沒有意義的查詢參數: 追蹤碼與快取破壞參數
+var noisyQueryParams = map[string]bool{"fbclid": true, "gclid": true, "_": true, "v": true}
+
+// 超過這個長度的參數值(例如 cursor)只保留開頭
+const maxLoggedQueryValue = 32
+
+// 名為 url 的欄位一律去掉查詢參數的雜訊
+func redactLogAttr(_ []string, attr slog.Attr) slog.Attr {
+	if attr.Key == "url" && attr.Value.Kind() == slog.KindString {
+		attr.Value = slog.StringValue(redactURL(attr.Value.String()))
+	}
+	return attr
+}
+
+// 去掉追蹤碼、快取破壞參數，過長的值只保留開頭
+func redactURL(raw string) string {
+	parsed, err := url.Parse(raw)
+	if err != nil || parsed.RawQuery == "" {
+		return raw
+	}
+	query := parsed.Query()
+	for key, values := range query {
+		if noisyQueryParams[key] || strings.HasPrefix(key, "utm_") {
+			query.Del(key)
+			continue
+		}
+		for i, value := range values {
+			if len(value) > maxLoggedQueryValue {
+				values[i] = value[:maxLoggedQueryValue] + "..."
+			}
+		}
+	}
+	parsed.RawQuery = query.Encode()
+	return parsed.String()
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestRequestLogRedaction(t *testing.T) {
+	server, _ := newTestAPIServer(t, 0)
+	serverLogs := captureLog(t, &serverLog, logServer)
+	upstreamLogs := captureLog(t, &upstreamLog, logUpstream)
+
+	header := http.Header{"X-Request-Id": {"req-log-1"}}
+	target := "/filter?store=daf&searchSize=13&fbclid=IwAR0tracking&utm_source=line&_=1712345678&ref=" + strings.Repeat("c", 64)
+	if recorder := serveTestRequest(server.routes(), http.MethodGet, target, header); recorder.Code != http.StatusOK {
+		t.Fatalf("/filter 狀態碼 %d", recorder.Code)
+	}
+
+	var requestLog map[string]interface{}
+	for _, line := range strings.Split(serverLogs.String(), "\n") {
+		if strings.Contains(line, `"msg":"HTTP 請求"`) {
+			json.Unmarshal([]byte(line), &requestLog)
+		}
+	}
+	if requestLog == nil {
+		t.Fatalf("沒有 HTTP 請求的 log: %s", serverLogs.String())
+	}
+	// 追蹤碼與快取破壞參數去掉，過長的值只保留開頭
+	wantURL := "/filter?ref=" + strings.Repeat("c", maxLoggedQueryValue) + "...&searchSize=13&store=daf"
+	if requestLog["url"] != wantURL || requestLog["requestId"] != "req-log-1" || requestLog["route"] != "/filter" {
+		t.Errorf("HTTP 請求的 log 不符: %v", requestLog)
+	}
+
+	// 向商店的請求帶上發起的 request ID 與搜尋工作編號
+	lines := strings.Split(strings.TrimSpace(upstreamLogs.String()), "\n")
+	if len(lines) != 15 {
+		t.Fatalf("商店請求有 %d 行 log，應為 15 行", len(lines))
+	}
+	for _, line := range lines {
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatal(err)
+		}
+		if entry["requestId"] != "req-log-1" || entry["jobId"] == nil || entry["subsystem"] != logUpstream {
+			t.Errorf("商店請求的 log 應帶上 request ID 與搜尋工作編號: %s", line)
+		}
+	}
+}
+
+func TestRedactURL(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want string
+	}{
+		{"/filter?store=daf", "/filter?store=daf"},
+		{"/filter", "/filter"},
+		{"https://www.daf-shoes.com/product/list/all/1?v=3&gclid=x&searchSize=13", "https://www.daf-shoes.com/product/list/all/1?searchSize=13"},
+		{"/search?utm_campaign=spring&utm_medium=social", "/search"},
+		{"/searches/abc?cursor=" + strings.Repeat("x", 40), "/searches/abc?cursor=" + strings.Repeat("x", maxLoggedQueryValue) + "..."},
+	}
+	for _, test := range tests {
+		if got := redactURL(test.raw); got != test.want {
+			t.Errorf("redactURL(%q) = %q，應為 %q", test.raw, got, test.want)
+		}
+	}
+}
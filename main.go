@@ -1,15 +1,14 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"crypto/tls"
 	"crypto/x509"
-	"encoding/json"
 	"fmt"
-	"log"
 	"net/http"
 	"os"
 	"strconv"
-	"text/template"
 )
 
 type Shoe struct {
@@ -21,113 +20,126 @@ type Shoe struct {
 	Price  string   `json:"price"`
 	Size   []string `json:"size"`
 	Color  []string `json:"color"`
+	//原價(商店未提供則為空)，用來計算折扣
+	SuggestPrice string `json:"suggestPrice,omitempty"`
+	//商品狀態、開賣時間、上架時間(RFC3339，商店未提供則為空)
+	Status         string `json:"status"`
+	SellingStartAt string `json:"sellingStartAt,omitempty"`
+	ListedAt       string `json:"listedAt,omitempty"`
+	//店鋪
+	Store string `json:"store"`
+	//從標題、描述解析出的款式屬性
+	Attributes StyleAttributes `json:"attributes"`
+	//同款商品編號、本商品在同款中的顏色
+	FamilyID    string `json:"familyID,omitempty"`
+	FamilyColor string `json:"familyColor,omitempty"`
+	//商品頁或庫存查詢失敗，尺碼可能不完整，也不會因為沒有尺碼而標記為售罄
+	StockUnverified bool `json:"stockUnverified,omitempty"`
 }
 
-var enviroment string
+// 商品狀態
+const (
+	shoeStatusAvailable  = "available"
+	shoeStatusSoldOut    = "soldOut"
+	shoeStatusComingSoon = "comingSoon"
+)
 
 func main() {
 
 	// Terminal啟動: $env:GO_ENV = "debug"
-	// >> go run main.go anns.go daf.go
-
-	// 設定環境變數
-	enviroment = os.Getenv("GO_ENV")
-	log.Println("GO_ENV:" + enviroment)
-
-	if enviroment == "debug" {
-		// 設定靜態文件伺服器(Local)
-		log.Println("Debug enviroment")
-		staticFs := http.FileServer(http.Dir("./statics"))
-		scriptFs := http.FileServer(http.Dir("./scripts"))
-		http.Handle("/statics/", http.StripPrefix("/statics/", staticFs))
-		http.Handle("/scripts/", http.StripPrefix("/scripts/", scriptFs))
-
-	} else if enviroment == "release" {
-		// 設定靜態文件伺服器 (PRD)
-		// 處理靜態文件，設置了一個路由來處理以 statics 開頭的請求。http.StripPrefix("/statics/", staticFs) 創建了一個新的處理器，這個處理器會去掉請求 URL 中的 statics 前綴，然後將剩餘部分交給 staticFs 處理。例如，當請求 URL 是 index.html 時，實際上會從 index.html 提供文件。
-		log.Println("Release enviroment")
-		staticFs := http.FileServer(http.Dir("/app/static"))
-		scriptFs := http.FileServer(http.Dir("/app/script"))
-		cssFs := http.FileServer(http.Dir("/app/css"))
-
-		http.Handle("/statics/", http.StripPrefix("/statics", staticFs))
-		http.Handle("/scripts/", http.StripPrefix("/scripts", scriptFs))
-		http.Handle("/css/", http.StripPrefix("/css", cssFs))
+	// >> go run .
+	// 其他設定見 README，可用 CONFIG_FILE 指定設定檔
 
+	config, err := loadConfig()
+	if err != nil {
+		serverLog.Error("設定載入失敗", "error", err)
+		os.Exit(1)
 	}
+	configureLogging(config.Log)
+	serverLog.Info("設定已載入", "env", config.Environment)
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080" // 預設值
+	server, err := newServer(config)
+	if err != nil {
+		serverLog.Error("伺服器建立失敗", "error", err)
+		os.Exit(1)
+	}
+	if err := server.run(); err != nil {
+		serverLog.Error("伺服器停止", "error", err)
+		os.Exit(1)
 	}
-
-	// 動態生成首頁主頁面
-	http.HandleFunc("/", indexHandler)
-	// 處理器來處理爬女鞋資訊主請求
-	http.HandleFunc("/filter", filterHandler)
-	log.Println("伺服器啟動於 http://localhost:" + port)
-	log.Fatal(http.ListenAndServe(":"+port, nil))
 }
 
-func filterHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) filterHandler(w http.ResponseWriter, r *http.Request) {
 
-	//允許跨域請求(CORS)
-	w.Header().Set("Access-Control-Allow-Origin", "*") // 允許所有來源
 	if r.Method != http.MethodGet {
-		http.Error(w, "只接受 GET 請求", http.StatusMethodNotAllowed)
+		writeMethodNotAllowed(w, r, http.MethodGet)
 		return
 	}
 
-	orderby := r.URL.Query().Get("orderby")
-	searchSize := r.URL.Query().Get("searchSize")
-	searchColor := r.URL.Query().Get("searchColor")
-	searchHeel := r.URL.Query().Get("searchHeel")
-	searchCat := r.URL.Query().Get("searchCat")
-	store := r.URL.Query().Get("store")
-
-	var shoes []Shoe
-	var err error
-
-	log.Println("查詢店鋪:" + store)
-	switch store {
-	case "daf":
-		shoes, err = getDAFFliterResponse(orderby, searchSize, searchColor, searchHeel, searchCat)
-	case "anns":
-		shoes, err = getAnnsFliterResponse(orderby, searchSize, searchColor, searchHeel, searchCat)
-	default:
-		http.Error(w, "未知的商店", http.StatusBadRequest)
+	// 排序與分頁，沒帶 page、pageSize、cursor 時回傳全部結果
+	pageRequest, jobID, err := parsePageRequest(r.URL.Query(), 0)
+	if err != nil {
+		writeError(w, r, err)
 		return
 	}
 
+	var job *searchJob
+	if jobID != "" {
+		// 下一頁直接從同一個搜尋工作的結果切，不重新爬取
+		var ok bool
+		if job, ok = s.jobs.get(jobID); !ok {
+			writeError(w, r, errCursorExpired)
+			return
+		}
+	} else {
+		request, err := s.parseSearchRequest(r.URL.Query())
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		// 同步查詢也排進搜尋工作佇列，等工作完成後直接回傳結果；相同條件的近期結果直接沿用
+		job, err = s.jobs.submit(r.Context(), request)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+	}
+	select {
+	case <-job.done:
+	case <-r.Context().Done():
+		serverLog.InfoContext(r.Context(), "查詢請求已中斷，工作仍會在背景完成", "jobId", job.ID)
+		return
+	}
+
+	result, page, warnings, err := job.result(pageRequest)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
-	// 返回 JSON 結果
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(shoes)
 
+	// 返回 JSON 結果
+	writePage(w, r, http.StatusOK, result, page, warnings)
 }
 
-// indexHandler 動態生成 HTML 頁面
-func indexHandler(w http.ResponseWriter, r *http.Request) {
+// indexHandler 以啟動時解析好的模板生成 HTML 頁面
+func (s *Server) indexHandler(w http.ResponseWriter, r *http.Request) {
 
-	var tmpl *template.Template
-	if enviroment == "release" {
-		tmpl = template.Must(template.ParseFiles("/app/static/index.html"))
-	} else {
-		tmpl = template.Must(template.ParseFiles("index.html"))
-	}
 	data := struct {
 		Environment string
 	}{
-		Environment: enviroment,
+		Environment: s.config.Environment,
 	}
-	tmpl.Execute(w, data)
-
-	if enviroment == "release" {
-		http.Redirect(w, r, "/statics/index.html", http.StatusFound)
+	// 先寫進 buffer，模板執行失敗時才能回傳 500 而不是半頁 HTML
+	var page bytes.Buffer
+	if err := s.indexTemplate.Execute(&page, data); err != nil {
+		serverLog.ErrorContext(r.Context(), "首頁模板執行錯誤", "error", err)
+		http.Error(w, "頁面產生失敗", http.StatusInternalServerError)
+		return
 	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	// 頁面內的靜態檔案網址帶有內容雜湊，頁面本身不快取才能拿到最新的網址
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(page.Bytes())
 }
 
 // createHTTPClientWithCACert 創建一個帶有 CA 憑證的 HTTP 客戶端
@@ -149,6 +161,41 @@ func createHTTPClientWithCACert(caCertPath string) (*http.Client, error) {
 	return client, nil
 }
 
+// newHTTPClient 依設定建立向商店發請求用的 HTTP 客戶端，有設定 CA 憑證時改用該憑證，並記錄請求指標
+func newHTTPClient(config Config) (*http.Client, error) {
+	client := &http.Client{Transport: http.DefaultTransport}
+	if config.CABundle != "" {
+		var err error
+		client, err = createHTTPClientWithCACert(config.CABundle)
+		if err != nil {
+			return nil, err
+		}
+	}
+	client.Timeout = config.HTTPTimeout.Duration
+	// 記錄每個商店主機的請求數、狀態碼與耗時
+	client.Transport = instrumentedTransport{next: client.Transport}
+	return client, nil
+}
+
+// 帶 ctx 向商店發 GET 請求，ctx 中的 request ID 會出現在 upstream log，請求取消時也會中斷
+func getWithContext(ctx context.Context, client *http.Client, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return client.Do(req)
+}
+
+// 帶 ctx 向商店 POST JSON
+func postJSONWithContext(ctx context.Context, client *http.Client, url string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return client.Do(req)
+}
+
 // 檢查切片中是否包含數字的輔助函數
 func containsDigit(sizes []string) bool {
 	for _, size := range sizes {
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"os"
+	"sync"
+	"testing"
+)
+
+// 測試時只輸出錯誤等級的 log，避免爬蟲的進度訊息蓋過測試結果
+func TestMain(m *testing.M) {
+	configureLogging(LogConfig{Level: "error"})
+	os.Exit(m.Run())
+}
+
+// logBuffer 收集 log 輸出，搜尋工作在其他 goroutine 寫 log，需要加鎖
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *logBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// 測試期間把子系統的 log 以與正式環境相同的格式與遮蔽規則寫到 buffer，結束後還原
+func captureLog(t *testing.T, logger **slog.Logger, subsystem string) *logBuffer {
+	t.Helper()
+	buffer := &logBuffer{}
+	level := &slog.LevelVar{}
+	level.Set(slog.LevelDebug)
+	handler := slog.NewJSONHandler(buffer, &slog.HandlerOptions{Level: slog.LevelDebug, ReplaceAttr: redactLogAttr})
+	original := *logger
+	*logger = slog.New(subsystemHandler{level: level, next: handler}).With("subsystem", subsystem)
+	t.Cleanup(func() { *logger = original })
+	return buffer
+}
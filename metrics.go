@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 指標種類，對應 Prometheus 的 TYPE
+const (
+	metricCounter   = "counter"
+	metricGauge     = "gauge"
+	metricHistogram = "histogram"
+)
+
+// 請求耗時(秒)的 bucket
+var latencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// 每次搜尋回傳商品數的 bucket
+var productCountBuckets = []float64{0, 1, 5, 10, 25, 50, 100, 250, 500, 1000}
+
+// metricSeries 一組標籤值的數值；histogram 的 buckets 為各 bucket 自己的次數，輸出時再累加
+type metricSeries struct {
+	labelValues []string
+	value       float64
+	buckets     []uint64
+	sum         float64
+	count       uint64
+}
+
+// metricVec 同名、同標籤的一組指標
+type metricVec struct {
+	name    string
+	help    string
+	kind    string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*metricSeries
+}
+
+func newMetricVec(kind, name, help string, labels ...string) *metricVec {
+	return &metricVec{name: name, help: help, kind: kind, labels: labels, series: map[string]*metricSeries{}}
+}
+
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *metricVec {
+	vec := newMetricVec(metricHistogram, name, help, labels...)
+	vec.buckets = buckets
+	return vec
+}
+
+// 取出(或建立)標籤值對應的數值，呼叫時須持有 vec.mu
+func (vec *metricVec) with(labelValues []string) *metricSeries {
+	key := strings.Join(labelValues, "\xff")
+	series, ok := vec.series[key]
+	if !ok {
+		series = &metricSeries{labelValues: labelValues}
+		if vec.kind == metricHistogram {
+			series.buckets = make([]uint64, len(vec.buckets))
+		}
+		vec.series[key] = series
+	}
+	return series
+}
+
+// counter 加上 delta，gauge 可為負數
+func (vec *metricVec) add(delta float64, labelValues ...string) {
+	vec.mu.Lock()
+	vec.with(labelValues).value += delta
+	vec.mu.Unlock()
+}
+
+// histogram 記錄一個觀測值
+func (vec *metricVec) observe(value float64, labelValues ...string) {
+	vec.mu.Lock()
+	defer vec.mu.Unlock()
+	series := vec.with(labelValues)
+	series.sum += value
+	series.count++
+	for i, bound := range vec.buckets {
+		if value <= bound {
+			series.buckets[i]++
+			break
+		}
+	}
+}
+
+// 依標籤值排序的快照，輸出時不必持有鎖
+func (vec *metricVec) snapshot() []metricSeries {
+	vec.mu.Lock()
+	defer vec.mu.Unlock()
+	var list []metricSeries
+	for _, series := range vec.series {
+		copied := *series
+		copied.buckets = append([]uint64(nil), series.buckets...)
+		list = append(list, copied)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return strings.Join(list[i].labelValues, "\xff") < strings.Join(list[j].labelValues, "\xff")
+	})
+	return list
+}
+
+// 以 Prometheus 文字格式輸出
+func (vec *metricVec) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", vec.name, vec.help, vec.name, vec.kind)
+	for _, series := range vec.snapshot() {
+		if vec.kind != metricHistogram {
+			fmt.Fprintf(w, "%s%s %s\n", vec.name, formatLabels(vec.labels, series.labelValues, "", ""), formatFloat(series.value))
+			continue
+		}
+		var cumulative uint64
+		for i, bound := range vec.buckets {
+			cumulative += series.buckets[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", vec.name, formatLabels(vec.labels, series.labelValues, "le", formatFloat(bound)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", vec.name, formatLabels(vec.labels, series.labelValues, "le", "+Inf"), series.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", vec.name, formatLabels(vec.labels, series.labelValues, "", ""), formatFloat(series.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", vec.name, formatLabels(vec.labels, series.labelValues, "", ""), series.count)
+	}
+}
+
+// {name="value",...}，extraName 不為空時加在最後(histogram 的 le)
+func formatLabels(names, values []string, extraName, extraValue string) string {
+	var pairs []string
+	for i, name := range names {
+		pairs = append(pairs, name+`="`+escapeLabelValue(values[i])+`"`)
+	}
+	if extraName != "" {
+		pairs = append(pairs, extraName+`="`+extraValue+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(value string) string {
+	return labelValueEscaper.Replace(value)
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// serverMetrics 伺服器的所有指標
+type serverMetrics struct {
+	requests         *metricVec
+	requestDuration  *metricVec
+	upstreamRequests *metricVec
+	upstreamDuration *metricVec
+	detailInFlight   *metricVec
+	cacheRequests    *metricVec
+	parseFailures    *metricVec
+	searchProducts   *metricVec
+	searchRejected   *metricVec
+	renderRequests   *metricVec
+	renderDuration   *metricVec
+}
+
+// 整個行程只有一份，newParseError、上游請求的 transport 這些不經過 Server 的地方也要記錄，因此以全域變數共用
+var metrics = newServerMetrics()
+
+func newServerMetrics() *serverMetrics {
+	return &serverMetrics{
+		requests: newMetricVec(metricCounter, "shoes_http_requests_total",
+			"處理的 HTTP 請求數", "route", "store", "status"),
+		requestDuration: newHistogramVec("shoes_http_request_duration_seconds",
+			"HTTP 請求處理時間(秒)", latencyBuckets, "route", "store"),
+		upstreamRequests: newMetricVec(metricCounter, "shoes_upstream_requests_total",
+			"向商店發出的請求數，status 為 HTTP 狀態碼或 error", "host", "status"),
+		upstreamDuration: newHistogramVec("shoes_upstream_request_duration_seconds",
+			"向商店發請求到收到回應標頭的時間(秒)", latencyBuckets, "host"),
+		detailInFlight: newMetricVec(metricGauge, "shoes_detail_fetches_in_flight",
+			"正在取得商品尺碼、顏色的 goroutine 數", "store"),
+		cacheRequests: newMetricVec(metricCounter, "shoes_cache_requests_total",
+			"快取查詢次數，result 為 hit 或 miss", "cache", "result"),
+		parseFailures: newMetricVec(metricCounter, "shoes_parse_failures_total",
+			"解析商店回應失敗的次數", "store", "field"),
+		searchProducts: newHistogramVec("shoes_search_products_returned",
+			"每次搜尋回傳的商品數", productCountBuckets, "store"),
+		searchRejected: newMetricVec(metricCounter, "shoes_search_rejected_total",
+			"拒絕發起的搜尋數，reason 為 rate_limited、queue_full 或 shutting_down", "reason"),
+		renderRequests: newMetricVec(metricCounter, "shoes_render_requests_total",
+			"以無頭瀏覽器渲染的頁面數，status 為 ok、error 或 timeout", "store", "status"),
+		renderDuration: newHistogramVec("shoes_render_duration_seconds",
+			"無頭瀏覽器渲染一頁的時間(秒)，包含等待空的分頁", latencyBuckets, "store"),
+	}
+}
+
+// 記錄一次快取查詢
+func (m *serverMetrics) cacheLookup(cache string, hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	m.cacheRequests.add(1, cache, result)
+}
+
+// 取得一個商品的尺碼、顏色前呼叫，回傳的函式在完成時呼叫
+func (m *serverMetrics) detailFetchStarted(store string) func() {
+	m.detailInFlight.add(1, store)
+	return func() { m.detailInFlight.add(-1, store) }
+}
+
+// 以 Prometheus 文字格式輸出所有指標
+func (m *serverMetrics) write(w io.Writer) {
+	for _, vec := range []*metricVec{
+		m.requests, m.requestDuration, m.upstreamRequests, m.upstreamDuration,
+		m.detailInFlight, m.cacheRequests, m.parseFailures, m.searchProducts, m.searchRejected,
+		m.renderRequests, m.renderDuration,
+	} {
+		vec.write(w)
+	}
+
+	// 命中率由 shoes_cache_requests_total 算出，方便不寫 PromQL 直接看
+	hits, totals := map[string]float64{}, map[string]float64{}
+	for _, series := range m.cacheRequests.snapshot() {
+		cache := series.labelValues[0]
+		totals[cache] += series.value
+		if series.labelValues[1] == "hit" {
+			hits[cache] += series.value
+		}
+	}
+	ratio := newMetricVec(metricGauge, "shoes_cache_hit_ratio", "快取命中率(啟動以來)", "cache")
+	for cache, total := range totals {
+		ratio.add(hits[cache]/total, cache)
+	}
+	ratio.write(w)
+}
+
+// 請求的店鋪標籤，限制為已知的店鋪(包含啟用的商店定義)以免標籤數量失控
+func (s *Server) storeLabel(store string) string {
+	_, defined := s.scrapers[store]
+	switch {
+	case store == "":
+		return "all"
+	case containsString(knownStores, store), defined:
+		return store
+	}
+	return "other"
+}
+
+// statusRecorder 記下處理器回傳的狀態碼
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (recorder *statusRecorder) WriteHeader(status int) {
+	if recorder.status == 0 {
+		recorder.status = status
+	}
+	recorder.ResponseWriter.WriteHeader(status)
+}
+
+func (recorder *statusRecorder) Write(b []byte) (int, error) {
+	if recorder.status == 0 {
+		recorder.status = http.StatusOK
+	}
+	return recorder.ResponseWriter.Write(b)
+}
+
+func (recorder *statusRecorder) Unwrap() http.ResponseWriter {
+	return recorder.ResponseWriter
+}
+
+// withRequestLog 記錄每個請求的 log 與各路由的請求數、狀態碼、處理時間，路由以註冊的 pattern 區分
+func (s *Server) withRequestLog(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w}
+		next(recorder, r)
+
+		store := r.PathValue("store")
+		if store == "" {
+			store = r.URL.Query().Get("store")
+		}
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+		duration := time.Since(start)
+		metrics.requests.add(1, r.Pattern, s.storeLabel(store), strconv.Itoa(recorder.status))
+		metrics.requestDuration.observe(duration.Seconds(), r.Pattern, s.storeLabel(store))
+
+		level := slog.LevelInfo
+		if recorder.status >= http.StatusInternalServerError {
+			level = slog.LevelWarn
+		}
+		serverLog.Log(r.Context(), level, "HTTP 請求",
+			"method", r.Method, "route", r.Pattern, "url", r.URL.String(),
+			"status", recorder.status, "durationMs", duration.Milliseconds())
+	}
+}
+
+// instrumentedTransport 記錄向商店發出的每個請求
+type instrumentedTransport struct {
+	next http.RoundTripper
+}
+
+func (transport instrumentedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := transport.next.RoundTrip(req)
+	status := "error"
+	if err == nil {
+		status = strconv.Itoa(resp.StatusCode)
+	}
+	duration := time.Since(start)
+	metrics.upstreamRequests.add(1, req.URL.Host, status)
+	metrics.upstreamDuration.observe(duration.Seconds(), req.URL.Host)
+
+	// ctx 帶有發起的 request ID 與搜尋工作編號
+	attrs := []any{"method", req.Method, "url", req.URL.String(), "status", status, "durationMs", duration.Milliseconds()}
+	switch {
+	case err != nil:
+		upstreamLog.WarnContext(req.Context(), "商店請求失敗", append(attrs, "error", err)...)
+	case resp.StatusCode >= http.StatusInternalServerError:
+		upstreamLog.WarnContext(req.Context(), "商店回應錯誤", attrs...)
+	default:
+		upstreamLog.DebugContext(req.Context(), "商店請求", attrs...)
+	}
+	return resp, err
+}
+
+// metricsHandler 以 Prometheus 文字格式輸出指標: GET /metrics
+func (s *Server) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	buffered := bufio.NewWriter(w)
+	metrics.write(buffered)
+	buffered.Flush()
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Prometheus 文字格式: 註解行與「名稱{標籤} 數值」
+var (
+	metricsCommentRe = regexp.MustCompile(`^# (HELP|TYPE) [a-z_]+ .+$`)
+	metricsSampleRe  = regexp.MustCompile(`^[a-z_]+(\{([a-zA-Z_]+="(?:[^"\\]|\\.)*",?)*\})? -?[0-9.e+-]+$|^[a-z_]+(\{.*\})? \+Inf$`)
+)
+
+// 把 /metrics 的樣本整理成「名稱{標籤}」對應數值
+func scrapeMetrics(t *testing.T, handler http.Handler) (string, map[string]float64) {
+	t.Helper()
+	recorder := serveTestRequest(handler, http.MethodGet, "/metrics", nil)
+	if recorder.Code != http.StatusOK || recorder.Header().Get("Content-Type") != "text/plain; version=0.0.4; charset=utf-8" {
+		t.Fatalf("狀態碼 %d、Content-Type %q", recorder.Code, recorder.Header().Get("Content-Type"))
+	}
+	body := recorder.Body.String()
+	samples := map[string]float64{}
+	for _, line := range strings.Split(strings.TrimSuffix(body, "\n"), "\n") {
+		if index := strings.LastIndexByte(line, ' '); !strings.HasPrefix(line, "#") && index > 0 {
+			samples[line[:index]], _ = strconv.ParseFloat(line[index+1:], 64)
+		}
+	}
+	return body, samples
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	server, daf := newTestAPIServer(t, 0)
+	handler := server.routes()
+
+	// 指標是全域的，其他測試也會累加，只比對這次請求前後的差
+	_, before := scrapeMetrics(t, handler)
+	if recorder := serveTestRequest(handler, http.MethodGet, "/filter?store=daf&searchSize=13", nil); recorder.Code != http.StatusOK {
+		t.Fatalf("/filter 狀態碼 %d", recorder.Code)
+	}
+	body, after := scrapeMetrics(t, handler)
+	types := map[string]string{}
+	for _, line := range strings.Split(strings.TrimSuffix(body, "\n"), "\n") {
+		switch {
+		case strings.HasPrefix(line, "#"):
+			if !metricsCommentRe.MatchString(line) {
+				t.Errorf("註解格式錯誤: %s", line)
+			}
+			if fields := strings.Fields(line); fields[1] == "TYPE" {
+				types[fields[2]] = fields[3]
+			}
+		case !metricsSampleRe.MatchString(line):
+			t.Errorf("樣本格式錯誤: %s", line)
+		}
+	}
+	if types["shoes_http_requests_total"] != "counter" || types["shoes_http_request_duration_seconds"] != "histogram" {
+		t.Errorf("指標類型不符: %v", types)
+	}
+
+	host := strings.TrimPrefix(daf.URL, "http://")
+	for sample, want := range map[string]float64{
+		`shoes_http_requests_total{route="/filter",store="daf",status="200"}`:               1,
+		`shoes_http_request_duration_seconds_bucket{route="/filter",store="daf",le="+Inf"}`: 1,
+		`shoes_http_request_duration_seconds_count{route="/filter",store="daf"}`:            1,
+		`shoes_upstream_requests_total{host="` + host + `",status="200"}`:                   15,
+	} {
+		if _, ok := after[sample]; !ok {
+			t.Errorf("/metrics 應包含 %s", sample)
+		} else if got := after[sample] - before[sample]; got != want {
+			t.Errorf("%s 增加了 %v，應為 %v", sample, got, want)
+		}
+	}
+	if _, ok := after[`shoes_http_request_duration_seconds_sum{route="/filter",store="daf"}`]; !ok {
+		t.Error("/metrics 應包含 /filter 的耗時總和")
+	}
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+)
+
+// middleware 包住處理器，在它之前或之後做共同的處理
+type middleware func(http.HandlerFunc) http.HandlerFunc
+
+// chain 依序套用 middleware，第一個在最外層
+func chain(handler http.HandlerFunc, middlewares ...middleware) http.HandlerFunc {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
+
+// 預設的 CSP: 頁面用到的 Bootstrap、SweetAlert2、Font Awesome、Chart.js、simple-datatables 都來自 CDN；
+// 首頁有 inline script 與 onclick，SweetAlert2、Font Awesome 會插入 inline style；商品圖片來自各店的網域
+const defaultContentSecurityPolicy = "default-src 'self'; " +
+	"script-src 'self' 'unsafe-inline' https://cdn.jsdelivr.net https://cdnjs.cloudflare.com https://use.fontawesome.com; " +
+	"style-src 'self' 'unsafe-inline' https://stackpath.bootstrapcdn.com https://cdn.jsdelivr.net; " +
+	"img-src 'self' data: https:; " +
+	"font-src 'self' data: https://use.fontawesome.com; " +
+	"connect-src 'self'; " +
+	"object-src 'none'; base-uri 'self'; form-action 'self'; frame-ancestors 'none'"
+
+// withSecurityHeaders 所有回應都加上的安全標頭
+func withSecurityHeaders(config SecurityConfig) middleware {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			header := w.Header()
+			header.Set("X-Content-Type-Options", "nosniff")
+			header.Set("X-Frame-Options", "DENY")
+			if config.ReferrerPolicy != "" {
+				header.Set("Referrer-Policy", config.ReferrerPolicy)
+			}
+			if config.ContentSecurityPolicy != "" {
+				header.Set("Content-Security-Policy", config.ContentSecurityPolicy)
+			}
+			next(w, r)
+		}
+	}
+}
+
+// 跨域請求可讀取的回應標頭
+const corsExposedHeaders = "X-Request-ID, Retry-After, Location"
+
+// corsPolicy 依設定的來源、方法回應跨域請求與預檢
+type corsPolicy struct {
+	allowAll bool
+	origins  map[string]bool
+	methods  map[string]bool
+	// 預檢回應用的標頭值
+	allowMethods string
+	allowHeaders string
+	maxAge       string
+}
+
+func newCORSPolicy(config CORSConfig) *corsPolicy {
+	policy := &corsPolicy{
+		origins:      map[string]bool{},
+		methods:      map[string]bool{},
+		allowMethods: strings.Join(config.AllowedMethods, ", "),
+		allowHeaders: strings.Join(config.AllowedHeaders, ", "),
+		maxAge:       strconv.Itoa(int(config.MaxAge.Seconds())),
+	}
+	for _, origin := range config.AllowedOrigins {
+		if origin == "*" {
+			policy.allowAll = true
+		}
+		policy.origins[strings.ToLower(origin)] = true
+	}
+	for _, method := range config.AllowedMethods {
+		policy.methods[method] = true
+	}
+	return policy
+}
+
+// handle 允許的來源加上 Access-Control-Allow-Origin；預檢請求直接回應，不進到路由
+func (policy *corsPolicy) handle(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next(w, r)
+			return
+		}
+		header := w.Header()
+		allowed := policy.allowAll || policy.origins[strings.ToLower(origin)]
+		if !policy.allowAll {
+			header.Add("Vary", "Origin")
+		}
+		if allowed {
+			if policy.allowAll {
+				header.Set("Access-Control-Allow-Origin", "*")
+			} else {
+				header.Set("Access-Control-Allow-Origin", origin)
+			}
+		}
+
+		requestMethod := r.Header.Get("Access-Control-Request-Method")
+		if r.Method != http.MethodOptions || requestMethod == "" {
+			if allowed {
+				header.Set("Access-Control-Expose-Headers", corsExposedHeaders)
+			}
+			next(w, r)
+			return
+		}
+
+		// 預檢: 不允許的來源或方法回傳 403，瀏覽器就不會送出真正的請求
+		header.Add("Vary", "Access-Control-Request-Method")
+		header.Add("Vary", "Access-Control-Request-Headers")
+		if !allowed || !policy.methods[requestMethod] {
+			header.Del("Access-Control-Allow-Origin")
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		header.Set("Access-Control-Allow-Methods", policy.allowMethods)
+		if policy.allowHeaders != "" {
+			header.Set("Access-Control-Allow-Headers", policy.allowHeaders)
+		}
+		header.Set("Access-Control-Max-Age", policy.maxAge)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// 檢查 CORS 設定: 來源為 * 或 scheme://host[:port]，方法為 HTTP 方法名稱
+func validateCORS(config CORSConfig) []error {
+	var errs []error
+	for _, origin := range config.AllowedOrigins {
+		if origin == "*" {
+			continue
+		}
+		parsed, err := url.Parse(origin)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" ||
+			parsed.Path != "" || parsed.RawQuery != "" || parsed.Fragment != "" {
+			errs = append(errs, fmt.Errorf("cors.allowedOrigins 應為 * 或 scheme://host[:port]: %q", origin))
+		}
+	}
+	for _, method := range config.AllowedMethods {
+		if method == "" || method != strings.ToUpper(method) || strings.ContainsAny(method, " ,") {
+			errs = append(errs, fmt.Errorf("cors.allowedMethods 應為大寫的 HTTP 方法: %q", method))
+		}
+	}
+	if config.MaxAge.Duration < 0 {
+		errs = append(errs, fmt.Errorf("cors.maxAge 不可為負數"))
+	}
+	return errs
+}
+
+// 支援的壓縮方式，q 值相同時依此順序優先
+var compressEncodings = []string{"br", "gzip"}
+
+var (
+	gzipWriters = sync.Pool{
+		New: func() interface{} { return gzip.NewWriter(nil) },
+	}
+	brotliWriters = sync.Pool{
+		New: func() interface{} { return brotli.NewWriter(nil) },
+	}
+)
+
+// withCompression 依 Accept-Encoding 以 brotli 或 gzip 壓縮 JSON 回應；其他內容(頁面、靜態檔案)不壓縮
+func withCompression(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		writer := &compressWriter{ResponseWriter: w, encoding: negotiateEncoding(r.Header.Get("Accept-Encoding"))}
+		defer writer.close()
+		next(writer, r)
+	}
+}
+
+// 從 Accept-Encoding 選出 q 值最高的壓縮方式，沒有可接受的時回傳空字串。
+// q=0(含 0.0、0.000)表示不接受，沒列出的壓縮方式依 * 的 q 值
+func negotiateEncoding(acceptEncoding string) string {
+	qualities := map[string]float64{}
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding == "" {
+			continue
+		}
+		quality, ok := parseQuality(params)
+		if !ok {
+			continue
+		}
+		qualities[coding] = quality
+	}
+
+	best, bestQuality := "", 0.0
+	for _, encoding := range compressEncodings {
+		quality, listed := qualities[encoding]
+		if !listed {
+			quality = qualities["*"]
+		}
+		if quality > bestQuality {
+			best, bestQuality = encoding, quality
+		}
+	}
+	return best
+}
+
+// 解析 ;q=0.8 這類參數，沒有 q 時為 1；格式錯誤時回傳 false
+func parseQuality(params string) (float64, bool) {
+	for _, param := range strings.Split(params, ";") {
+		name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+		if !strings.EqualFold(strings.TrimSpace(name), "q") {
+			continue
+		}
+		quality, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || quality < 0 || quality > 1 {
+			return 0, false
+		}
+		return quality, true
+	}
+	return 1, true
+}
+
+// compressWriter 在寫出標頭時依 Content-Type 決定是否壓縮
+type compressWriter struct {
+	http.ResponseWriter
+	// 協商出的壓縮方式，空字串表示不壓縮
+	encoding    string
+	wroteHeader bool
+	gzip        *gzip.Writer
+	brotli      *brotli.Writer
+}
+
+func (w *compressWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+
+	header := w.Header()
+	mediaType, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	if mediaType == "application/json" {
+		header.Add("Vary", "Accept-Encoding")
+		if w.encoding != "" && header.Get("Content-Encoding") == "" &&
+			status != http.StatusNoContent && status != http.StatusNotModified {
+			header.Set("Content-Encoding", w.encoding)
+			header.Del("Content-Length")
+			switch w.encoding {
+			case "br":
+				w.brotli = brotliWriters.Get().(*brotli.Writer)
+				w.brotli.Reset(w.ResponseWriter)
+			case "gzip":
+				w.gzip = gzipWriters.Get().(*gzip.Writer)
+				w.gzip.Reset(w.ResponseWriter)
+			}
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	switch {
+	case w.brotli != nil:
+		return w.brotli.Write(b)
+	case w.gzip != nil:
+		return w.gzip.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *compressWriter) close() {
+	if w.brotli != nil {
+		w.brotli.Close()
+		brotliWriters.Put(w.brotli)
+		w.brotli = nil
+	}
+	if w.gzip != nil {
+		w.gzip.Close()
+		gzipWriters.Put(w.gzip)
+		w.gzip = nil
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andybalholm/brotli"
+)
+
+func TestCORSPreflight(t *testing.T) {
+	policy := newCORSPolicy(CORSConfig{
+		AllowedOrigins: []string{"https://shoes.example.com"},
+		AllowedMethods: []string{http.MethodGet, http.MethodPost},
+		AllowedHeaders: []string{"Content-Type", "X-Request-ID"},
+		MaxAge:         Duration{10 * time.Minute},
+	})
+	reached := false
+	handler := policy.handle(func(w http.ResponseWriter, r *http.Request) { reached = true })
+
+	tests := []struct {
+		name        string
+		origin      string
+		method      string
+		status      int
+		allowOrigin string
+	}{
+		{name: "允許的來源與方法", origin: "https://shoes.example.com", method: http.MethodPost, status: http.StatusNoContent, allowOrigin: "https://shoes.example.com"},
+		{name: "來源不分大小寫", origin: "HTTPS://SHOES.EXAMPLE.COM", method: http.MethodGet, status: http.StatusNoContent, allowOrigin: "HTTPS://SHOES.EXAMPLE.COM"},
+		{name: "不允許的來源", origin: "https://evil.example.com", method: http.MethodGet, status: http.StatusForbidden},
+		{name: "不允許的方法", origin: "https://shoes.example.com", method: http.MethodDelete, status: http.StatusForbidden},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reached = false
+			request := httptest.NewRequest(http.MethodOptions, "/filter", nil)
+			request.Header.Set("Origin", test.origin)
+			request.Header.Set("Access-Control-Request-Method", test.method)
+			recorder := httptest.NewRecorder()
+			handler(recorder, request)
+
+			if recorder.Code != test.status {
+				t.Errorf("狀態碼 %d，應為 %d", recorder.Code, test.status)
+			}
+			if reached {
+				t.Error("預檢請求不應進到路由")
+			}
+			header := recorder.Header()
+			if got := header.Get("Access-Control-Allow-Origin"); got != test.allowOrigin {
+				t.Errorf("Access-Control-Allow-Origin %q，應為 %q", got, test.allowOrigin)
+			}
+			if test.status == http.StatusNoContent {
+				if header.Get("Access-Control-Allow-Methods") != "GET, POST" || header.Get("Access-Control-Allow-Headers") != "Content-Type, X-Request-ID" ||
+					header.Get("Access-Control-Max-Age") != "600" {
+					t.Errorf("預檢標頭 %v", header)
+				}
+			}
+			if !strings.Contains(strings.Join(header.Values("Vary"), ","), "Origin") {
+				t.Errorf("沒有 Vary: Origin，得到 %v", header.Values("Vary"))
+			}
+		})
+	}
+}
+
+func TestCORSSimpleRequest(t *testing.T) {
+	tests := []struct {
+		name        string
+		origins     []string
+		origin      string
+		allowOrigin string
+	}{
+		{name: "允許所有來源", origins: []string{"*"}, origin: "https://any.example.com", allowOrigin: "*"},
+		{name: "清單內的來源", origins: []string{"https://shoes.example.com"}, origin: "https://shoes.example.com", allowOrigin: "https://shoes.example.com"},
+		{name: "清單外的來源", origins: []string{"https://shoes.example.com"}, origin: "https://evil.example.com"},
+		{name: "沒有 Origin", origins: []string{"https://shoes.example.com"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			policy := newCORSPolicy(CORSConfig{AllowedOrigins: test.origins, AllowedMethods: []string{http.MethodGet}})
+			reached := false
+			request := httptest.NewRequest(http.MethodGet, "/filter", nil)
+			if test.origin != "" {
+				request.Header.Set("Origin", test.origin)
+			}
+			recorder := httptest.NewRecorder()
+			policy.handle(func(w http.ResponseWriter, r *http.Request) { reached = true })(recorder, request)
+
+			// 不允許的來源仍會進到路由，由瀏覽器擋下回應
+			if !reached {
+				t.Error("一般請求應進到路由")
+			}
+			header := recorder.Header()
+			if got := header.Get("Access-Control-Allow-Origin"); got != test.allowOrigin {
+				t.Errorf("Access-Control-Allow-Origin %q，應為 %q", got, test.allowOrigin)
+			}
+			wantExpose := ""
+			if test.allowOrigin != "" {
+				wantExpose = corsExposedHeaders
+			}
+			if got := header.Get("Access-Control-Expose-Headers"); got != wantExpose {
+				t.Errorf("Access-Control-Expose-Headers %q，應為 %q", got, wantExpose)
+			}
+		})
+	}
+}
+
+func TestValidateCORS(t *testing.T) {
+	errs := validateCORS(CORSConfig{
+		AllowedOrigins: []string{"*", "https://shoes.example.com", "https://shoes.example.com/path", "ftp://shoes.example.com", "shoes.example.com"},
+		AllowedMethods: []string{"GET", "post", "PUT,DELETE"},
+		MaxAge:         Duration{-time.Second},
+	})
+	if len(errs) != 6 {
+		t.Errorf("應有 6 個錯誤，得到 %d: %v", len(errs), errs)
+	}
+}
+
+func TestSecurityHeaders(t *testing.T) {
+	handler := withSecurityHeaders(SecurityConfig{ContentSecurityPolicy: defaultContentSecurityPolicy, ReferrerPolicy: "no-referrer"})(
+		func(w http.ResponseWriter, r *http.Request) {})
+	recorder := httptest.NewRecorder()
+	handler(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	for name, want := range map[string]string{
+		"X-Content-Type-Options":  "nosniff",
+		"X-Frame-Options":         "DENY",
+		"Referrer-Policy":         "no-referrer",
+		"Content-Security-Policy": defaultContentSecurityPolicy,
+	} {
+		if got := recorder.Header().Get(name); got != want {
+			t.Errorf("%s = %q，應為 %q", name, got, want)
+		}
+	}
+
+	// 設為空字串時不加
+	handler = withSecurityHeaders(SecurityConfig{})(func(w http.ResponseWriter, r *http.Request) {})
+	recorder = httptest.NewRecorder()
+	handler(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+	if recorder.Header().Get("Content-Security-Policy") != "" || recorder.Header().Get("Referrer-Policy") != "" {
+		t.Errorf("不應加上 CSP 與 Referrer-Policy: %v", recorder.Header())
+	}
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		acceptEncoding string
+		want           string
+	}{
+		{acceptEncoding: "", want: ""},
+		{acceptEncoding: "gzip", want: "gzip"},
+		{acceptEncoding: "br", want: "br"},
+		{acceptEncoding: "gzip, deflate, br", want: "br"},
+		{acceptEncoding: "gzip;q=1.0, br;q=0.5", want: "gzip"},
+		{acceptEncoding: "GZIP", want: "gzip"},
+		{acceptEncoding: "gzip;q=0", want: ""},
+		{acceptEncoding: "gzip;q=0.0", want: ""},
+		{acceptEncoding: "gzip; q=0.000, br", want: "br"},
+		{acceptEncoding: "br;q=0, gzip;q=0.1", want: "gzip"},
+		{acceptEncoding: "*", want: "br"},
+		{acceptEncoding: "*;q=0.5, br;q=0", want: "gzip"},
+		{acceptEncoding: "gzip;q=abc", want: ""},
+		{acceptEncoding: "gzip;q=2", want: ""},
+		{acceptEncoding: "identity, deflate", want: ""},
+	}
+	for _, test := range tests {
+		if got := negotiateEncoding(test.acceptEncoding); got != test.want {
+			t.Errorf("negotiateEncoding(%q) = %q，應為 %q", test.acceptEncoding, got, test.want)
+		}
+	}
+}
+
+func TestCompression(t *testing.T) {
+	const body = `{"data":"大尺碼女鞋大尺碼女鞋大尺碼女鞋"}`
+	jsonHandler := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(body))
+	}
+	htmlHandler := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(body))
+	}
+	decoders := map[string]func(io.Reader) (io.Reader, error){
+		"":     func(r io.Reader) (io.Reader, error) { return r, nil },
+		"gzip": func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+		"br":   func(r io.Reader) (io.Reader, error) { return brotli.NewReader(r), nil },
+	}
+
+	tests := []struct {
+		name           string
+		handler        http.HandlerFunc
+		acceptEncoding string
+		encoding       string
+	}{
+		{name: "JSON 以 brotli 壓縮", handler: jsonHandler, acceptEncoding: "gzip, br", encoding: "br"},
+		{name: "JSON 以 gzip 壓縮", handler: jsonHandler, acceptEncoding: "gzip", encoding: "gzip"},
+		{name: "不接受壓縮", handler: jsonHandler, acceptEncoding: "gzip;q=0.0"},
+		{name: "頁面不壓縮", handler: htmlHandler, acceptEncoding: "gzip, br"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/filter", nil)
+			request.Header.Set("Accept-Encoding", test.acceptEncoding)
+			recorder := httptest.NewRecorder()
+			withCompression(test.handler)(recorder, request)
+
+			if got := recorder.Header().Get("Content-Encoding"); got != test.encoding {
+				t.Fatalf("Content-Encoding %q，應為 %q", got, test.encoding)
+			}
+			reader, err := decoders[test.encoding](recorder.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded, err := io.ReadAll(reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(decoded) != body {
+				t.Errorf("解壓縮後 %q，應為 %q", decoded, body)
+			}
+		})
+	}
+
+	// 204 沒有內容，不壓縮
+	request := httptest.NewRequest(http.MethodGet, "/filter", nil)
+	request.Header.Set("Accept-Encoding", "gzip")
+	recorder := httptest.NewRecorder()
+	withCompression(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNoContent)
+	})(recorder, request)
+	if recorder.Header().Get("Content-Encoding") != "" || recorder.Body.Len() != 0 {
+		t.Errorf("204 不應壓縮: %v %q", recorder.Header(), recorder.Body.Bytes())
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 排序方式，空字串為商店原本的順序(有關鍵字時為相關度)
+const (
+	sortPriceAsc  = "priceAsc"
+	sortPriceDesc = "priceDesc"
+	sortNewest    = "newest"
+	sortName      = "name"
+	sortDiscount  = "discount"
+	sortSizes     = "sizes"
+)
+
+var sortOptions = []ParamOption{
+	{Value: sortPriceAsc, Label: "價格低到高"},
+	{Value: sortPriceDesc, Label: "價格高到低"},
+	{Value: sortNewest, Label: "最新上架"},
+	{Value: sortName, Label: "名稱"},
+	{Value: sortDiscount, Label: "折扣最多"},
+	{Value: sortSizes, Label: "現貨尺碼最多"},
+}
+
+const (
+	// 有帶 page 但沒帶 pageSize 時每頁筆數
+	defaultPageSize = 50
+	// pageSize 上限
+	maxPageSize = 200
+)
+
+var errCursorExpired = errors.New("cursor 已過期，請重新查詢")
+
+// PageRequest 結果的排序與分頁方式，Paged 為 false 時回傳全部結果
+type PageRequest struct {
+	Sort     string
+	Offset   int
+	PageSize int
+	Paged    bool
+}
+
+// PageInfo 分頁資訊，放在回應的 page；NextCursor 為空表示沒有下一頁
+type PageInfo struct {
+	Total      int    `json:"total"`
+	Page       int    `json:"page"`
+	PageSize   int    `json:"pageSize"`
+	TotalPages int    `json:"totalPages"`
+	Sort       string `json:"sort,omitempty"`
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+// pageCursor 編進 cursor 的內容: 搜尋工作編號、排序、起始位置與每頁筆數
+type pageCursor struct {
+	JobID    string `json:"j"`
+	Sort     string `json:"s,omitempty"`
+	Offset   int    `json:"o"`
+	PageSize int    `json:"n"`
+}
+
+// 從 query string 取出排序與分頁方式；帶 cursor 時忽略其他參數，並回傳 cursor 所屬的搜尋工作編號。
+// 沒帶 page、pageSize 時依 fallbackPageSize 分頁，為 0 則不分頁
+func parsePageRequest(query url.Values, fallbackPageSize int) (PageRequest, string, error) {
+
+	if value := query.Get("cursor"); value != "" {
+		cursor, err := decodeCursor(value)
+		if err != nil {
+			return PageRequest{}, "", newParamError("cursor", errors.New("cursor 格式錯誤"))
+		}
+		request := PageRequest{Sort: cursor.Sort, Offset: cursor.Offset, PageSize: cursor.PageSize, Paged: true}
+		return request, cursor.JobID, nil
+	}
+
+	request := PageRequest{Sort: query.Get("sort")}
+	if request.Sort != "" && !hasOption(sortOptions, request.Sort) {
+		return request, "", newParamError("sort", errors.New("sort 應為 "+optionValues(sortOptions)))
+	}
+
+	page, pageSize := 1, fallbackPageSize
+	if value := query.Get("page"); value != "" {
+		number, err := strconv.Atoi(value)
+		if err != nil || number < 1 {
+			return request, "", newParamError("page", errors.New("page 應為正整數"))
+		}
+		page = number
+		if pageSize == 0 {
+			pageSize = defaultPageSize
+		}
+	}
+	if value := query.Get("pageSize"); value != "" {
+		number, err := strconv.Atoi(value)
+		if err != nil || number < 1 || number > maxPageSize {
+			return request, "", newParamError("pageSize", errors.New("pageSize 應為 1 到 "+strconv.Itoa(maxPageSize)+" 的整數"))
+		}
+		pageSize = number
+	}
+	if pageSize > 0 {
+		request.Paged = true
+		request.PageSize = pageSize
+		request.Offset = (page - 1) * pageSize
+	}
+	return request, "", nil
+}
+
+func encodeCursor(cursor pageCursor) string {
+	b, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(value string) (pageCursor, error) {
+	var cursor pageCursor
+	b, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return cursor, err
+	}
+	if err := json.Unmarshal(b, &cursor); err != nil {
+		return cursor, err
+	}
+	if cursor.JobID == "" || cursor.Offset < 0 || cursor.PageSize < 1 || cursor.PageSize > maxPageSize ||
+		(cursor.Sort != "" && !hasOption(sortOptions, cursor.Sort)) {
+		return cursor, errors.New("cursor 內容錯誤")
+	}
+	return cursor, nil
+}
+
+// 依排序方式排出結果並切出這一頁，jobID 用來產生下一頁的 cursor
+func pageResult(request SearchRequest, shoes []Shoe, page PageRequest, jobID string) (interface{}, PageInfo) {
+	shoes = sortShoes(shoes, page.Sort)
+	if request.Group == groupFamily {
+		return paginate(groupShoesByFamily(shoes), page, jobID)
+	}
+	return paginate(shoes, page, jobID)
+}
+
+// 切出 page 指定的範圍，超出範圍時回傳空的一頁
+func paginate[T any](items []T, page PageRequest, jobID string) ([]T, PageInfo) {
+
+	info := PageInfo{Total: len(items), Page: 1, PageSize: len(items), TotalPages: 1, Sort: page.Sort}
+	if !page.Paged {
+		return items, info
+	}
+
+	info.PageSize = page.PageSize
+	info.Page = page.Offset/page.PageSize + 1
+	info.TotalPages = max(1, (len(items)+page.PageSize-1)/page.PageSize)
+
+	start := min(page.Offset, len(items))
+	end := min(start+page.PageSize, len(items))
+	if end < len(items) {
+		info.NextCursor = encodeCursor(pageCursor{JobID: jobID, Sort: page.Sort, Offset: end, PageSize: page.PageSize})
+	}
+	return items[start:end], info
+}
+
+// 依排序方式排序，回傳新的切片不影響原本的結果；排序值相同或缺少時維持原本順序
+func sortShoes(shoes []Shoe, sortBy string) []Shoe {
+
+	if sortBy == "" {
+		return shoes
+	}
+	sorted := append([]Shoe(nil), shoes...)
+
+	switch sortBy {
+	case sortPriceAsc, sortPriceDesc:
+		sort.SliceStable(sorted, func(i, j int) bool {
+			first, firstOK := parsePrice(sorted[i].Price)
+			second, secondOK := parsePrice(sorted[j].Price)
+			if firstOK != secondOK {
+				// 沒有價格的排最後
+				return firstOK
+			}
+			if sortBy == sortPriceAsc {
+				return first < second
+			}
+			return first > second
+		})
+	case sortNewest:
+		sort.SliceStable(sorted, func(i, j int) bool {
+			first, firstOK := parseStoreTime(sorted[i].ListedAt)
+			second, secondOK := parseStoreTime(sorted[j].ListedAt)
+			if firstOK != secondOK {
+				return firstOK
+			}
+			return first.After(second)
+		})
+	case sortName:
+		sort.SliceStable(sorted, func(i, j int) bool {
+			return sorted[i].Name < sorted[j].Name
+		})
+	case sortDiscount:
+		sort.SliceStable(sorted, func(i, j int) bool {
+			return shoeDiscount(sorted[i]) > shoeDiscount(sorted[j])
+		})
+	case sortSizes:
+		sort.SliceStable(sorted, func(i, j int) bool {
+			return len(sorted[i].Size) > len(sorted[j].Size)
+		})
+	}
+	return sorted
+}
+
+// 商店的價格字串轉成數字，例如 "1,280"
+func parsePrice(price string) (float64, bool) {
+	value, err := strconv.ParseFloat(strings.ReplaceAll(strings.TrimSpace(price), ",", ""), 64)
+	return value, err == nil
+}
+
+// 相對原價的折扣比例，沒有原價時為 0
+func shoeDiscount(shoe Shoe) float64 {
+	price, priceOK := parsePrice(shoe.Price)
+	suggestPrice, suggestOK := parsePrice(shoe.SuggestPrice)
+	if !priceOK || !suggestOK || suggestPrice <= 0 || price >= suggestPrice {
+		return 0
+	}
+	return 1 - price/suggestPrice
+}
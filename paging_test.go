@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	items := []int{1, 2, 3, 4, 5, 6, 7}
+	request, _, err := parsePageRequest(url.Values{"sort": {sortPriceAsc}, "pageSize": {"3"}}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 依 nextCursor 逐頁取得，直到沒有下一頁
+	var got [][]int
+	for page := 1; ; page++ {
+		items, info := paginate(items, request, "job1")
+		got = append(got, items)
+		if info.Page != page || info.TotalPages != 3 || info.Total != 7 || info.Sort != sortPriceAsc {
+			t.Errorf("第 %d 頁的分頁資訊 %+v", page, info)
+		}
+		if info.NextCursor == "" {
+			break
+		}
+		var jobID string
+		request, jobID, err = parsePageRequest(url.Values{"cursor": {info.NextCursor}, "sort": {sortName}, "pageSize": {"1"}}, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// cursor 帶著搜尋工作編號，並忽略同時帶的 sort、pageSize
+		if jobID != "job1" || request.Sort != sortPriceAsc || request.PageSize != 3 {
+			t.Fatalf("cursor 解出 %+v、%q", request, jobID)
+		}
+	}
+	if want := [][]int{{1, 2, 3}, {4, 5, 6}, {7}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("逐頁取得 %v，應為 %v", got, want)
+	}
+}
+
+func TestTamperedCursor(t *testing.T) {
+	valid := encodeCursor(pageCursor{JobID: "job1", Sort: sortNewest, Offset: 50, PageSize: 50})
+	encode := func(raw string) string { return base64.RawURLEncoding.EncodeToString([]byte(raw)) }
+	for _, cursor := range []string{
+		valid[:len(valid)-3],
+		valid + "!",
+		"not base64 at all",
+		encode(`{"j":"job1","o":0,"n":50`),
+		encode(`{"o":0,"n":50}`),
+		encode(`{"j":"job1","o":-50,"n":50}`),
+		encode(`{"j":"job1","o":0,"n":0}`),
+		encode(`{"j":"job1","o":0,"n":100000}`),
+		encode(`{"j":"job1","s":"random","o":0,"n":50}`),
+		encode(`{"j":"job1","o":"0","n":50}`),
+	} {
+		_, _, err := parsePageRequest(url.Values{"cursor": {cursor}}, 0)
+		var paramError *ParamError
+		if !errors.As(err, &paramError) || paramError.Field != "cursor" {
+			t.Errorf("cursor %q 應回傳 cursor 的參數錯誤，得到 %v", cursor, err)
+		}
+	}
+}
+
+func TestSortShoesStable(t *testing.T) {
+	shoes := []Shoe{
+		{ListID: "a"},
+		{ListID: "b", ListedAt: "2024-03-01T10:00:00"},
+		{ListID: "c", ListedAt: "不是時間"},
+		{ListID: "d", ListedAt: "2024-05-01 10:00:00"},
+		{ListID: "e"},
+		{ListID: "f", ListedAt: "2024-03-01T10:00:00"},
+	}
+	// 有上架時間的由新到舊，時間相同或缺少時維持原本順序，缺少的排最後
+	want := []string{"d", "b", "f", "a", "c", "e"}
+	for i := 0; i < 20; i++ {
+		sorted := sortShoes(shoes, sortNewest)
+		var got []string
+		for _, shoe := range sorted {
+			got = append(got, shoe.ListID)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("排序結果 %v，應為 %v", got, want)
+		}
+	}
+	// 不影響原本的切片
+	if shoes[0].ListID != "a" || shoes[3].ListID != "d" {
+		t.Error("sortShoes 改到了原本的切片")
+	}
+
+	// 沒有價格的排最後，價格相同時維持原本順序
+	priced := sortShoes([]Shoe{{ListID: "a"}, {ListID: "b", Price: "1,280"}, {ListID: "c", Price: "980"}, {ListID: "d", Price: "1280"}}, sortPriceAsc)
+	var got []string
+	for _, shoe := range priced {
+		got = append(got, shoe.ListID)
+	}
+	if want := []string{"c", "b", "d", "a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("價格排序 %v，應為 %v", got, want)
+	}
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ParamOption 查詢參數可接受的值與顯示名稱
+type ParamOption struct {
+	Value string `json:"value"`
+	Label string `json:"label"`
+}
+
+// StoreParams 一家店的查詢參數可接受的值，/filter 帶其他值會回傳 400
+type StoreParams struct {
+	Store string `json:"store"`
+	// 是否至少要選一個款式
+	RequiresCategory bool          `json:"requiresCategory"`
+	OrderBy          []ParamOption `json:"orderby"`
+	Sizes            []ParamOption `json:"searchSize"`
+	Colors           []ParamOption `json:"searchColor"`
+	Heels            []ParamOption `json:"searchHeel"`
+	Cats             []ParamOption `json:"searchCat"`
+	// 結果的排序方式，各店相同
+	Sort []ParamOption `json:"sort"`
+}
+
+// 內建店鋪的參數值，與首頁表單的選項一致；尺碼由 sizeCharts 產生。
+// 商店定義的店鋪由 Server.storeParams 從定義取得
+var builtinStoreParams = map[string]StoreParams{
+	"daf": {
+		Store: "daf",
+		OrderBy: []ParamOption{
+			{Value: "1", Label: "新上市"},
+			{Value: "5", Label: "依銷量"},
+			{Value: "2", Label: "價格低到高"},
+			{Value: "3", Label: "價格高到低"},
+		},
+		Sizes: append([]ParamOption{{Value: "0", Label: "不限"}}, sizeOptions("daf")...),
+		Colors: []ParamOption{
+			{Value: "0", Label: "不限"},
+			{Value: "49", Label: "黑色系"},
+			{Value: "84", Label: "白色系"},
+			{Value: "82", Label: "灰色系"},
+			{Value: "79", Label: "裸色系"},
+			{Value: "61", Label: "大地色系"},
+			{Value: "73", Label: "粉色系"},
+			{Value: "55", Label: "紅色系"},
+			{Value: "52", Label: "黃橘色系"},
+			{Value: "64", Label: "綠色系"},
+			{Value: "58", Label: "藍紫色系"},
+			{Value: "67", Label: "金屬色"},
+			{Value: "76", Label: "動物紋"},
+			{Value: "70", Label: "其他"},
+		},
+		Heels: []ParamOption{
+			{Value: "0", Label: "不限"},
+			{Value: "1", Label: "平底 2.5cm以下"},
+			{Value: "2", Label: "低跟 2.5-4.5cm"},
+			{Value: "3", Label: "中跟 4.5-6.5cm"},
+			{Value: "4", Label: "高跟 6.5cm以上"},
+		},
+		Cats: []ParamOption{
+			{Value: "0", Label: "不限"},
+			{Value: "350", Label: "機能風芭蕾鞋"},
+			{Value: "338", Label: "瑪莉珍鞋"},
+			{Value: "130", Label: "樂福鞋、紳士鞋"},
+			{Value: "325", Label: "牛津鞋、德比鞋"},
+			{Value: "133", Label: "休閒鞋、德訓鞋"},
+			{Value: "139", Label: "平底鞋、娃娃鞋"},
+			{Value: "244", Label: "莫卡辛、豆豆鞋"},
+			{Value: "292", Label: "穆勒鞋"},
+			{Value: "142", Label: "跟鞋"},
+			{Value: "127", Label: "涼鞋、拖鞋"},
+			{Value: "304", Label: "運動鞋、老爹鞋"},
+			{Value: "148", Label: "短靴、中筒靴"},
+			{Value: "199", Label: "長靴、膝下靴"},
+			{Value: "314", Label: "膝上靴、過膝靴"},
+			{Value: "256", Label: "雨靴"},
+			{Value: "259", Label: "雪靴"},
+		},
+	},
+	"anns": {
+		Store:            "anns",
+		RequiresCategory: true,
+		OrderBy: []ParamOption{
+			{Value: "Curator", Label: "店長推薦"},
+			{Value: "Newest", Label: "最新上架"},
+			{Value: "Sales", Label: "熱賣商品"},
+			{Value: "PageView", Label: "最多人看"},
+			{Value: "PriceLowToHigh", Label: "價格低到高"},
+			{Value: "PriceHighToLow", Label: "價格高到低"},
+		},
+		Sizes: sizeOptions("anns"),
+		Colors: []ParamOption{
+			{Value: "K2152", Label: "純白"},
+			{Value: "K2153", Label: "黑色"},
+			{Value: "K2154", Label: "灰色"},
+			{Value: "K2155", Label: "咖色"},
+			{Value: "K2156", Label: "棕色"},
+			{Value: "K2157", Label: "紫色"},
+			{Value: "K2158", Label: "米白、杏色"},
+			{Value: "K2159", Label: "粉紅、桃紅"},
+			{Value: "K2160", Label: "紅色、酒紅"},
+			{Value: "K2161", Label: "黃色、橘色"},
+			{Value: "K2162", Label: "深藍、粉藍"},
+			{Value: "K2163", Label: "Tiffany綠、墨綠"},
+			{Value: "K2164", Label: "金屬色系"},
+		},
+		Heels: []ParamOption{
+			{Value: "K2165", Label: "平底3公分以下"},
+			{Value: "K2166", Label: "低跟3-5.5公分"},
+			{Value: "K2167", Label: "中跟5.6-8公分"},
+			{Value: "K2168", Label: "高跟8公分以上"},
+		},
+		Cats: []ParamOption{
+			{Value: "100076", Label: "短靴"},
+			{Value: "321336", Label: "襪靴"},
+			{Value: "409704", Label: "軍靴"},
+			{Value: "100078", Label: "雪靴"},
+			{Value: "100074", Label: "長靴"},
+			{Value: "407882", Label: "過膝靴"},
+			{Value: "325457", Label: "真皮靴"},
+			{Value: "279377", Label: "樂福鞋"},
+			{Value: "100637", Label: "娃娃鞋、莫卡辛"},
+			{Value: "487996", Label: "瑪莉珍鞋"},
+			{Value: "100055", Label: "休閒鞋、懶人鞋"},
+			{Value: "524244", Label: "牛津鞋"},
+			{Value: "325836", Label: "老爹鞋"},
+			{Value: "293207", Label: "穆勒鞋"},
+			{Value: "100059", Label: "尖頭鞋"},
+			{Value: "358039", Label: "方頭鞋"},
+			{Value: "100048", Label: "婚鞋"},
+			{Value: "100053", Label: "防水雨靴"},
+			{Value: "293206", Label: "涼鞋"},
+			{Value: "382729", Label: "拖鞋"},
+			{Value: "334715", Label: "水洗涼鞋"},
+			{Value: "100041", Label: "一字涼鞋"},
+			{Value: "389202", Label: "透明系"},
+			{Value: "197978", Label: "草編、編織"},
+			{Value: "100039", Label: "寶石、水鑽"},
+			{Value: "389206", Label: "特殊造型跟"},
+			{Value: "376849", Label: "男鞋"},
+			{Value: "211728", Label: "童鞋"},
+			{Value: "390920", Label: "親子鞋、情侶鞋"},
+		},
+	},
+}
+
+// 由尺碼對照表產生尺碼選項，值為向商店篩選時帶的代碼
+func sizeOptions(store string) []ParamOption {
+	var options []ParamOption
+	for _, row := range sizeCharts[store].Rows {
+		options = append(options, ParamOption{Value: row.Code, Label: row.Label})
+	}
+	return options
+}
+
+// FieldError 單一參數的錯誤
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError 多個參數的錯誤，一次回傳讓前端逐欄顯示
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	var messages []string
+	for _, field := range e.Fields {
+		messages = append(messages, field.Message)
+	}
+	return strings.Join(messages, "；")
+}
+
+func (e *ValidationError) add(field, format string, args ...interface{}) {
+	e.Fields = append(e.Fields, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// 檢查查詢條件是否都是該店可接受的值(allowed)；不指定店鋪(搜尋索引)時尺碼為 EU 尺碼，其他條件各店的值不同所以不接受
+func validateSearchParams(params SearchParams, allowed StoreParams) error {
+
+	validation := &ValidationError{}
+
+	if params.Store == "" {
+		labels := append(sizeLabels("daf"), sizeLabels("anns")...)
+		for _, size := range params.Sizes {
+			if !containsString(labels, size) {
+				validation.add("searchSize", "searchSize 應為 EU 尺碼，例如 41")
+				break
+			}
+		}
+		for _, field := range []struct {
+			name string
+			set  bool
+		}{
+			{"orderby", params.OrderBy != ""},
+			{"searchColor", len(params.Colors) > 0},
+			{"searchHeel", len(params.Heels) > 0},
+			{"searchCat", len(params.Cats) > 0},
+		} {
+			if field.set {
+				validation.add(field.name, "不指定店鋪時不支援 %s，請帶 store 指定店鋪", field.name)
+			}
+		}
+	} else {
+		if params.OrderBy != "" && !hasOption(allowed.OrderBy, params.OrderBy) {
+			validation.add("orderby", "orderby 應為 %s", optionValues(allowed.OrderBy))
+		}
+		checkOptions(validation, "searchSize", params.Sizes, allowed.Sizes)
+		checkOptions(validation, "searchColor", params.Colors, allowed.Colors)
+		checkOptions(validation, "searchHeel", params.Heels, allowed.Heels)
+		checkOptions(validation, "searchCat", params.Cats, allowed.Cats)
+		if allowed.RequiresCategory && len(params.Cats) == 0 {
+			validation.add("searchCat", "至少要選擇一個款式")
+		}
+	}
+
+	if len(validation.Fields) > 0 {
+		return validation
+	}
+	return nil
+}
+
+func checkOptions(validation *ValidationError, field string, values []string, options []ParamOption) {
+	for _, value := range values {
+		if !hasOption(options, value) {
+			validation.add(field, "%s 不支援 %q，可用的值: %s", field, value, optionValues(options))
+			return
+		}
+	}
+}
+
+func hasOption(options []ParamOption, value string) bool {
+	for _, option := range options {
+		if option.Value == value {
+			return true
+		}
+	}
+	return false
+}
+
+func optionValues(options []ParamOption) string {
+	var values []string
+	for _, option := range options {
+		values = append(values, option.Value)
+	}
+	return strings.Join(values, "、")
+}
+
+func sizeLabels(store string) []string {
+	var labels []string
+	for _, row := range sizeCharts[store].Rows {
+		labels = append(labels, row.Label)
+	}
+	return labels
+}
+
+// paramsHandler 列出已啟用店鋪的查詢參數可接受的值: /params[?store=daf]
+func (s *Server) paramsHandler(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, r, http.MethodGet)
+		return
+	}
+
+	stores := s.config.EnabledStores
+	if store := r.URL.Query().Get("store"); store != "" {
+		if !s.config.storeEnabled(store) {
+			writeError(w, r, errUnknownStore)
+			return
+		}
+		stores = []string{store}
+	}
+
+	result := []StoreParams{}
+	for _, store := range stores {
+		params := s.storeParams(store)
+		params.Sort = sortOptions
+		result = append(result, params)
+	}
+
+	// 返回 JSON 結果
+	writeData(w, r, http.StatusOK, result, nil)
+}
+
+// 店鋪的查詢參數，以商店定義爬取的店鋪(包含 daf.engine 為 definition 時的 D+AF)以定義為準
+func (s *Server) storeParams(store string) StoreParams {
+	if scraper, ok := s.scrapers[store]; ok {
+		return scraper.params
+	}
+	return builtinStoreParams[store]
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestValidateSearchParams(t *testing.T) {
+	tests := []struct {
+		name   string
+		params SearchParams
+		fields []string
+	}{
+		{name: "店鋪可接受的值", params: SearchParams{Store: "daf", OrderBy: "2", Sizes: []string{"13"}, Colors: []string{"0"}}},
+		{name: "店鋪不支援的值", params: SearchParams{Store: "daf", Sizes: []string{"41"}, Cats: []string{"999"}}, fields: []string{"searchSize", "searchCat"}},
+		{name: "不指定店鋪時以 EU 尺碼查詢", params: SearchParams{Query: "樂福鞋", Sizes: []string{"41", "45"}}},
+		{name: "不指定店鋪時尺碼不是 EU 尺碼", params: SearchParams{Query: "樂福鞋", Sizes: []string{"13"}}, fields: []string{"searchSize"}},
+		{
+			name:   "不指定店鋪時不接受各店的條件",
+			params: SearchParams{Query: "樂福鞋", OrderBy: "price_asc", Colors: []string{"0"}, Heels: []string{"1"}, Cats: []string{"142"}},
+			fields: []string{"orderby", "searchColor", "searchHeel", "searchCat"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateSearchParams(test.params, builtinStoreParams[test.params.Store])
+			var fields []string
+			var validationError *ValidationError
+			if errors.As(err, &validationError) {
+				for _, field := range validationError.Fields {
+					fields = append(fields, field.Field)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(fields, test.fields) {
+				t.Errorf("錯誤欄位 %v，應為 %v (%v)", fields, test.fields, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// detailPipeline 以固定數量的 worker 訪問商品頁，取得每雙鞋的尺碼和顏色；列表還在爬時就開始處理
+type detailPipeline struct {
+	ctx   context.Context
+	store string
+	// 取得一雙鞋的商品頁資訊，失敗時鞋子仍保留列表上的資訊
+	fetch    func(ctx context.Context, shoe *Shoe) error
+	input    chan Shoe
+	output   chan Shoe
+	workers  sync.WaitGroup
+	finished chan struct{}
+
+	mu       sync.Mutex
+	added    int
+	done     int
+	enriched map[string]Shoe
+}
+
+// 啟動商品頁 worker 與收集結果的 goroutine
+func newDetailPipeline(ctx context.Context, store string, workers int, fetch func(context.Context, *Shoe) error) *detailPipeline {
+	pipeline := &detailPipeline{
+		ctx:      ctx,
+		store:    store,
+		fetch:    fetch,
+		input:    make(chan Shoe, workers),
+		output:   make(chan Shoe, workers),
+		finished: make(chan struct{}),
+		enriched: map[string]Shoe{},
+	}
+	for i := 0; i < workers; i++ {
+		pipeline.workers.Add(1)
+		go func() {
+			defer pipeline.workers.Done()
+			for shoe := range pipeline.input {
+				// 商品頁取得失敗或資料異常時仍回傳列表上的資訊，並回報警告
+				err := func() (err error) {
+					defer metrics.detailFetchStarted(pipeline.store)()
+					defer recoverPanic(pipeline.store+" 商品頁 "+shoe.ListID, &err)
+					return pipeline.fetch(pipeline.ctx, &shoe)
+				}()
+				if err != nil {
+					reportWarning(pipeline.ctx, newWarning(pipeline.store, shoe.ListID, err))
+				}
+				pipeline.output <- shoe
+			}
+		}()
+	}
+	go pipeline.collect()
+	return pipeline
+}
+
+// 把列表找到的鞋子交給商品頁 worker
+func (pipeline *detailPipeline) add(shoe Shoe) {
+	pipeline.mu.Lock()
+	pipeline.added++
+	pipeline.mu.Unlock()
+	pipeline.input <- shoe
+}
+
+func (pipeline *detailPipeline) collect() {
+	defer close(pipeline.finished)
+	for shoe := range pipeline.output {
+		pipeline.mu.Lock()
+		pipeline.enriched[shoe.ListID] = shoe
+		pipeline.done++
+		done, total := pipeline.done, pipeline.added
+		pipeline.mu.Unlock()
+
+		// 回報進度與已完成的鞋子
+		reportProgress(pipeline.ctx, "detail", done, total)
+		reportPartial(pipeline.ctx, shoe)
+	}
+}
+
+// 列表爬完後呼叫，等所有商品頁完成並回傳 ListID 對應的結果
+func (pipeline *detailPipeline) wait() map[string]Shoe {
+	close(pipeline.input)
+	pipeline.workers.Wait()
+	close(pipeline.output)
+	<-pipeline.finished
+	return pipeline.enriched
+}
@@ -0,0 +1,411 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ProductDetail 單一商品的完整資訊
+type ProductDetail struct {
+	Store        string           `json:"store"`
+	ListID       string           `json:"listID"`
+	Name         string           `json:"name"`
+	Description  string           `json:"description,omitempty"`
+	URL          string           `json:"url"`
+	Images       []string         `json:"images"`
+	Price        string           `json:"price"`
+	SuggestPrice string           `json:"suggestPrice,omitempty"`
+	Promotions   []Promotion      `json:"promotions,omitempty"`
+	Category     string           `json:"category,omitempty"`
+	Attributes   StyleAttributes  `json:"attributes"`
+	Colors       []string         `json:"colors"`
+	Variants     []Variant        `json:"variants"`
+	Siblings     []SiblingProduct `json:"siblings,omitempty"`
+	RefreshedAt  time.Time        `json:"refreshedAt"`
+}
+
+// Variant 商品的一個規格(顏色+尺碼)與其庫存，Stock 為 nil 表示商店不提供數量
+type Variant struct {
+	Color   string `json:"color,omitempty"`
+	Size    string `json:"size"`
+	Stock   *int   `json:"stock,omitempty"`
+	InStock bool   `json:"inStock"`
+}
+
+// Promotion 商品的促銷價格
+type Promotion struct {
+	Label   string `json:"label"`
+	Price   string `json:"price"`
+	StartAt string `json:"startAt,omitempty"`
+	EndAt   string `json:"endAt,omitempty"`
+}
+
+// SiblingProduct 同款不同色的其他商品
+type SiblingProduct struct {
+	ListID string `json:"listID"`
+	Name   string `json:"name"`
+	URL    string `json:"url"`
+}
+
+var errProductNotFound = errors.New("找不到商品")
+
+// 商品詳細資訊快取最多保留的商品數
+const productDetailCacheSize = 1000
+
+// productDetailCache 商品詳細資訊快取，超過 ttl 才會重新向商店請求；滿了時先清掉過期的，仍然太多再清掉最舊的
+type productDetailCache struct {
+	sync.Mutex
+	ttl     time.Duration
+	details map[string]ProductDetail
+}
+
+func newProductDetailCache(ttl time.Duration) *productDetailCache {
+	return &productDetailCache{ttl: ttl, details: map[string]ProductDetail{}}
+}
+
+// 取出未過期的快取
+func (cache *productDetailCache) get(key string) (ProductDetail, bool) {
+	cache.Lock()
+	defer cache.Unlock()
+	detail, ok := cache.details[key]
+	hit := ok && time.Since(detail.RefreshedAt) < cache.ttl
+	metrics.cacheLookup("productDetail", hit)
+	return detail, hit
+}
+
+func (cache *productDetailCache) put(key string, detail ProductDetail) {
+	cache.Lock()
+	defer cache.Unlock()
+	if _, exists := cache.details[key]; !exists && len(cache.details) >= productDetailCacheSize {
+		cache.evict()
+	}
+	cache.details[key] = detail
+}
+
+// 清掉過期的商品，都沒過期時清掉最舊的一筆；呼叫時須持有鎖
+func (cache *productDetailCache) evict() {
+	oldestKey := ""
+	var oldest time.Time
+	for key, detail := range cache.details {
+		if time.Since(detail.RefreshedAt) >= cache.ttl {
+			delete(cache.details, key)
+			continue
+		}
+		if oldestKey == "" || detail.RefreshedAt.Before(oldest) {
+			oldestKey, oldest = key, detail.RefreshedAt
+		}
+	}
+	if len(cache.details) >= productDetailCacheSize {
+		delete(cache.details, oldestKey)
+	}
+}
+
+// D+AF 的 ListID 格式為 "商品編號_顏色編號"
+var dafListIDRe = regexp.MustCompile(`^(\d+)_(\d+)$`)
+
+// productHandler 回傳單一商品的詳細資訊: /products/{store}/{id}
+func (s *Server) productHandler(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, r, http.MethodGet)
+		return
+	}
+
+	store := r.PathValue("store")
+	id := r.PathValue("id")
+
+	productLog.InfoContext(r.Context(), "查詢單一商品", "store", store, "listID", id)
+
+	detail, err := s.getProductDetail(r.Context(), store, id)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// 返回 JSON 結果
+	writeData(w, r, http.StatusOK, detail, nil)
+}
+
+// 取得單一商品詳細資訊，快取過期才會重新向商店請求
+func (s *Server) getProductDetail(ctx context.Context, store, id string) (ProductDetail, error) {
+
+	key := catalogKey(store, id)
+
+	detail, ok := s.productDetails.get(key)
+	if ok {
+		return detail, nil
+	}
+	if !s.config.storeEnabled(store) {
+		return detail, errUnknownStore
+	}
+
+	var err error
+	switch store {
+	case "daf":
+		if !dafListIDRe.MatchString(id) {
+			return detail, newParamError("id", errors.New("D+AF 商品編號格式應為 數字_數字"))
+		}
+		detail, err = s.daf.getDAFProductDetail(ctx, id)
+	case "anns":
+		if _, convErr := strconv.Atoi(id); convErr != nil {
+			return detail, newParamError("id", errors.New("Ann's 商品編號應為數字"))
+		}
+		detail, err = s.anns.getAnnsProductDetail(ctx, id)
+	default:
+		// 商店定義只描述列表與商品頁的尺碼顏色，不提供單一商品詳細資訊
+		if _, ok := s.scrapers[store]; ok {
+			return detail, fmt.Errorf("%w: %s 不提供單一商品查詢", errProductNotFound, s.storeDisplayName(store))
+		}
+		return detail, errUnknownStore
+	}
+	if err != nil {
+		return detail, err
+	}
+
+	detail.Store = store
+	detail.ListID = id
+	detail.RefreshedAt = time.Now()
+	s.mergeCatalogEntry(&detail)
+	detail.Attributes = extractStyleAttributes(detail.Name, detail.Description, detail.Category)
+
+	s.productDetails.put(key, detail)
+
+	// 描述補進搜尋索引
+	s.index.addDescription(store, id, detail.Description)
+
+	return detail, nil
+}
+
+// 把爬列表時記下的資訊補進商品詳細資訊
+func (s *Server) mergeCatalogEntry(detail *ProductDetail) {
+	entry, ok := s.catalog.lookup(detail.Store, detail.ListID)
+	if !ok {
+		return
+	}
+	// 列表的圖片較完整，放在詳細頁圖片之前
+	detail.Images = mergeUnique(entry.Images, detail.Images)
+	if detail.SuggestPrice == "" {
+		detail.SuggestPrice = entry.SuggestPrice
+	}
+	if len(detail.Promotions) == 0 {
+		detail.Promotions = entry.Promotions
+	}
+	if detail.Category == "" {
+		detail.Category = entry.Category
+	}
+}
+
+// 合併多個字串切片並去除重複與空字串，保留出現順序
+func mergeUnique(lists ...[]string) []string {
+	seen := map[string]struct{}{}
+	merged := []string{}
+	for _, list := range lists {
+		for _, value := range list {
+			if value == "" {
+				continue
+			}
+			if _, exists := seen[value]; exists {
+				continue
+			}
+			seen[value] = struct{}{}
+			merged = append(merged, value)
+		}
+	}
+	return merged
+}
+
+// 取得 D+AF 單一商品詳細資訊
+func (daf *dafCrawler) getDAFProductDetail(ctx context.Context, listID string) (ProductDetail, error) {
+
+	var detail ProductDetail
+
+	matches := dafListIDRe.FindStringSubmatch(listID)
+	url := fmt.Sprintf("%sproduct/show/%s/%s/", daf.config.BaseURL, matches[1], matches[2])
+	resp, err := getWithContext(ctx, daf.client, url)
+	if err != nil {
+		productLog.ErrorContext(ctx, "D+AF 單一商品請求錯誤", "url", url, "error", err)
+		return detail, err
+	}
+	defer resp.Body.Close()
+
+	// 只有 404 表示商品不存在，其他非 200 的狀態碼是商店異常
+	if resp.StatusCode == http.StatusNotFound {
+		return detail, fmt.Errorf("%w: D+AF 商品 %s", errProductNotFound, listID)
+	}
+	if resp.StatusCode != http.StatusOK {
+		productLog.WarnContext(ctx, "D+AF 單一商品回應異常", "url", url, "status", resp.StatusCode)
+		return detail, &UpstreamStatusError{Store: "D+AF", StatusCode: resp.StatusCode}
+	}
+
+	// 讀取回應內容
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		productLog.ErrorContext(ctx, "D+AF 單一商品讀取回應錯誤", "url", url, "error", err)
+		return detail, err
+	}
+
+	return extractDAFProductDetail(body, url)
+}
+
+// 從 D+AF 商品頁中取出商品詳細資訊
+func extractDAFProductDetail(body []byte, url string) (ProductDetail, error) {
+
+	detail := ProductDetail{URL: url}
+
+	// 名稱、價格、分類放在 gtag 的 view_item 事件裡
+	// 頁面是 200 卻沒有 view_item 表示網頁改版，回傳解析錯誤而不是找不到商品
+	items, err := getGtagItems(body, "view_item")
+	if err != nil {
+		return detail, err
+	}
+	if len(items) == 0 {
+		return detail, newParseError("D+AF", "gtag view_item", errors.New("view_item 沒有商品"))
+	}
+	item := items[0]
+	detail.Name, _ = item["name"].(string)
+	detail.Price = fmt.Sprintf("%v", item["price"])
+	detail.Category, _ = item["category"].(string)
+
+	// 商品描述
+	detail.Description = getMetaDescription(body)
+
+	// 圖片: og:image 與商品輪播的 <source srcset>
+	var images []string
+	ogImageRe := regexp.MustCompile(`<meta[^>]+property=['"]og:image['"][^>]+content=['"]([^'"]+)['"]`)
+	for _, match := range ogImageRe.FindAllSubmatch(body, -1) {
+		images = append(images, string(match[1]))
+	}
+	sourceRe := regexp.MustCompile(`<source[^>]+srcset="([^"]+)"`)
+	for _, match := range sourceRe.FindAllSubmatch(body, -1) {
+		images = append(images, string(match[1]))
+	}
+	detail.Images = mergeUnique(images)
+
+	// 尺碼、顏色沿用列表爬蟲的解析
+	var shoe Shoe
+	shoe.Name = detail.Name
+	getSize(body, &shoe)
+	getColor(body, &shoe)
+	detail.Colors = shoe.Color
+
+	// D+AF 頁面只列出有貨的尺碼，沒有數量；單色時才能確定尺碼對應的顏色
+	color := ""
+	if len(shoe.Color) == 1 {
+		color = shoe.Color[0]
+	}
+	detail.Variants = []Variant{}
+	for _, size := range shoe.Size {
+		detail.Variants = append(detail.Variants, Variant{Color: color, Size: size, InStock: true})
+	}
+
+	return detail, nil
+}
+
+// 取得 Ann's 單一商品詳細資訊
+func (anns *annsCrawler) getAnnsProductDetail(ctx context.Context, salePageId string) (ProductDetail, error) {
+
+	var detail ProductDetail
+	var annsShoeDetailOrignalHTML AnnsShoeDetailOrignalHTML
+
+	resp, err := getWithContext(ctx, anns.client, anns.config.SalePageAPIURL+salePageId)
+	if err != nil {
+		productLog.ErrorContext(ctx, "Ann's 單一商品請求錯誤", "listID", salePageId, "error", err)
+		return detail, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return detail, fmt.Errorf("%w: Ann's 商品 %s", errProductNotFound, salePageId)
+	}
+	if resp.StatusCode != http.StatusOK {
+		productLog.WarnContext(ctx, "Ann's 單一商品回應異常", "listID", salePageId, "status", resp.StatusCode)
+		return detail, &UpstreamStatusError{Store: "Ann's", StatusCode: resp.StatusCode}
+	}
+
+	// 讀取回應內容
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		productLog.ErrorContext(ctx, "Ann's 單一商品讀取回應錯誤", "listID", salePageId, "error", err)
+		return detail, err
+	}
+
+	err = json.Unmarshal(body, &annsShoeDetailOrignalHTML)
+	if err != nil {
+		return detail, newParseError("Ann's", "單一商品 JSON", err)
+	}
+	annsShoeDetail := annsShoeDetailOrignalHTML.Data
+	if annsShoeDetail.Id == 0 || len(annsShoeDetail.MajorList) == 0 || len(annsShoeDetail.MajorList[0].SKUList) == 0 {
+		return detail, fmt.Errorf("%w: Ann's 商品 %s", errProductNotFound, salePageId)
+	}
+
+	detail.Name = annsShoeDetail.Title
+	detail.Description = annsShoeDetail.SubTitle
+	detail.URL = anns.config.SalePageURL + salePageId
+	detail.Price = fmt.Sprintf("%v", annsShoeDetail.MajorList[0].Price)
+
+	// 同款其他顏色
+	for _, item := range annsShoeDetail.SalePageGroup.SalePageItems {
+		detail.Colors = append(detail.Colors, item.GroupItemTitle)
+		if strconv.Itoa(item.SalePageId) == salePageId {
+			continue
+		}
+		detail.Siblings = append(detail.Siblings, SiblingProduct{
+			ListID: strconv.Itoa(item.SalePageId),
+			Name:   item.GroupItemTitle,
+			URL:    anns.config.SalePageURL + strconv.Itoa(item.SalePageId),
+		})
+	}
+
+	// 規格與庫存，以 SKU ID 對應尺寸
+	skus, err := extractAnnsSKUs(annsShoeDetail)
+	if err != nil {
+		return detail, err
+	}
+	skuIds := make([]int, 0, len(skus))
+	for _, sku := range skus {
+		skuIds = append(skuIds, sku.SKUId)
+	}
+	stocks, err := anns.getAnnsStockBySKU(ctx, skuIds)
+	if err != nil {
+		productLog.ErrorContext(ctx, "Ann's 單一商品取得庫存錯誤", "listID", salePageId, "error", err)
+		return detail, err
+	}
+
+	color := ""
+	if len(annsShoeDetail.SalePageGroup.SalePageItems) == 0 {
+		color = annsShoeDetail.MajorList[0].Title
+	} else {
+		for _, item := range annsShoeDetail.SalePageGroup.SalePageItems {
+			if strconv.Itoa(item.SalePageId) == salePageId {
+				color = item.GroupItemTitle
+			}
+		}
+	}
+
+	detail.Variants = []Variant{}
+	for _, sku := range skus {
+		qty := stocks[sku.SKUId]
+		// 多色商品每個 SKU 有自己的顏色，單色商品沿用商品的顏色
+		skuColor := sku.Color
+		if skuColor == "" {
+			skuColor = color
+		}
+		detail.Colors = mergeUnique(detail.Colors, []string{skuColor})
+		detail.Variants = append(detail.Variants, Variant{
+			Color:   skuColor,
+			Size:    sku.Size,
+			Stock:   &qty,
+			InStock: qty > 0,
+		})
+	}
+
+	return detail, nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestExtractDAFProductDetail(t *testing.T) {
+	detail, err := extractDAFProductDetail(readTestdata(t, "daf/product.html"), "https://www.daf-shoes.com/product/show/31035/4/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ProductDetail{
+		URL:         "https://www.daf-shoes.com/product/show/31035/4/",
+		Name:        "麂皮拉鍊中筒靴",
+		Price:       "3280",
+		Category:    "靴子",
+		Description: "麂皮鞋面，側邊拉鍊，跟高4cm",
+		Images: []string{
+			"https://img.daf-shoes.com/product/31035/4/og.jpg",
+			"https://img.daf-shoes.com/product/31035/4/1.webp",
+			"https://img.daf-shoes.com/product/31035/4/2.webp",
+		},
+		Colors: []string{"咖"},
+		// 單色時尺碼對應到該顏色，只列出有貨的尺碼
+		Variants: []Variant{{Color: "咖", Size: "40", InStock: true}, {Color: "咖", Size: "42", InStock: true}},
+	}
+	if !reflect.DeepEqual(detail, want) {
+		t.Errorf("商品詳細資訊\n%+v\n應為\n%+v", detail, want)
+	}
+}
+
+func TestGetDAFProductDetail(t *testing.T) {
+	product := readTestdata(t, "daf/product.html")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/product/show/31035/4/":
+			w.Write(product)
+		case "/product/show/2/1/":
+			// 網頁改版，200 但沒有 view_item
+			w.Write([]byte("<html><body>維護中</body></html>"))
+		case "/product/show/3/1/":
+			http.Error(w, "bad gateway", http.StatusBadGateway)
+		case "/product/show/4/1/":
+			http.Error(w, "too many requests", http.StatusTooManyRequests)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	daf := &dafCrawler{client: server.Client(), config: DAFConfig{BaseURL: server.URL + "/"}}
+
+	detail, err := daf.getDAFProductDetail(context.Background(), "31035_4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if detail.Name != "麂皮拉鍊中筒靴" || detail.URL != server.URL+"/product/show/31035/4/" {
+		t.Errorf("取得 %+v", detail)
+	}
+
+	// 只有 404 是找不到商品，商店異常與網頁改版要能從錯誤代碼分辨
+	tests := []struct {
+		listID string
+		status int
+		code   string
+	}{
+		{listID: "1_1", status: http.StatusNotFound, code: errCodeNotFound},
+		{listID: "2_1", status: http.StatusBadGateway, code: errCodeParseError},
+		{listID: "3_1", status: http.StatusBadGateway, code: errCodeUpstreamError},
+		{listID: "4_1", status: http.StatusBadGateway, code: errCodeUpstreamError},
+	}
+	for _, test := range tests {
+		_, err := daf.getDAFProductDetail(context.Background(), test.listID)
+		if err == nil {
+			t.Errorf("%s 應回傳錯誤", test.listID)
+			continue
+		}
+		status, apiError := classifyError(err)
+		if status != test.status || apiError.Code != test.code {
+			t.Errorf("%s: %d %s，應為 %d %s (%v)", test.listID, status, apiError.Code, test.status, test.code, err)
+		}
+	}
+
+	var parseError *ParseError
+	if _, err := daf.getDAFProductDetail(context.Background(), "2_1"); !errors.As(err, &parseError) || parseError.Field != "gtag view_item" {
+		t.Errorf("沒有 view_item 應回傳 gtag view_item 的解析錯誤，得到 %v", err)
+	}
+}
+
+func TestGetProductDetailInvalidID(t *testing.T) {
+	server, err := newServer(defaultConfig("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct{ store, id string }{{"daf", "31035"}, {"daf", "abc_1"}, {"anns", "12a"}} {
+		var paramError *ParamError
+		if _, err := server.getProductDetail(context.Background(), test.store, test.id); !errors.As(err, &paramError) || paramError.Field != "id" {
+			t.Errorf("%s/%s 應回傳商品編號格式錯誤，得到 %v", test.store, test.id, err)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+)
+
+var errRateLimited = errors.New("查詢太頻繁，請稍後再試")
+
+// RetryAfterError 請客戶端稍後重試的錯誤，RetryAfter 會放進 Retry-After 標頭
+type RetryAfterError struct {
+	Err        error
+	RetryAfter time.Duration
+}
+
+func (e *RetryAfterError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RetryAfterError) Unwrap() error {
+	return e.Err
+}
+
+// Retry-After 的秒數，至少 1 秒
+func retryAfterSeconds(wait time.Duration) int {
+	return max(1, int(math.Ceil(wait.Seconds())))
+}
+
+type clientIPKey struct{}
+
+// 取出客戶端 IP，沒有時回傳無效的位址
+func clientIPFrom(ctx context.Context) netip.Addr {
+	addr, _ := ctx.Value(clientIPKey{}).(netip.Addr)
+	return addr
+}
+
+// tokenBucket 一個客戶端的 token 數，每次發起新的搜尋用掉一個
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter 依客戶端 IP 限制發起新搜尋的頻率，IPv6 以 /64 視為同一個客戶端
+type rateLimiter struct {
+	// 每秒補充的 token 數，0 表示不限制
+	rate     float64
+	burst    float64
+	ipHeader string
+	exempt   []netip.Prefix
+
+	mu        sync.Mutex
+	buckets   map[netip.Addr]*tokenBucket
+	lastSweep time.Time
+}
+
+func newRateLimiter(config RateLimitConfig) *rateLimiter {
+	limiter := &rateLimiter{
+		rate:     float64(config.PerMinute) / 60,
+		burst:    float64(config.Burst),
+		ipHeader: config.ClientIPHeader,
+		buckets:  map[netip.Addr]*tokenBucket{},
+	}
+	// 設定檢查時已確認格式
+	for _, value := range config.Exempt {
+		prefix, _ := parseIPPrefix(value)
+		limiter.exempt = append(limiter.exempt, prefix)
+	}
+	return limiter
+}
+
+// IP 或 CIDR 轉成網段，單一 IP 視為只有它自己的網段
+func parseIPPrefix(value string) (netip.Prefix, error) {
+	if strings.Contains(value, "/") {
+		prefix, err := netip.ParsePrefix(value)
+		return prefix.Masked(), err
+	}
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// withClientIP 取出客戶端 IP 放進 context，供發起搜尋時限制頻率
+func (limiter *rateLimiter) withClientIP(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		addr, ok := limiter.clientIP(r)
+		if ok {
+			r = r.WithContext(context.WithValue(r.Context(), clientIPKey{}, addr))
+		}
+		next(w, r)
+	}
+}
+
+// 有設定標頭(部署在 fly.io 時為 Fly-Client-IP)且請求帶有時以標頭為準，否則用連線位址
+func (limiter *rateLimiter) clientIP(r *http.Request) (netip.Addr, bool) {
+	value := ""
+	if limiter.ipHeader != "" {
+		// X-Forwarded-For 可能有多個位址，第一個是客戶端
+		value, _, _ = strings.Cut(r.Header.Get(limiter.ipHeader), ",")
+		value = strings.TrimSpace(value)
+	}
+	if value == "" {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			return netip.Addr{}, false
+		}
+		value = host
+	}
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
+
+// allow 用掉 ctx 中客戶端的一個 token，用完時回傳 errRateLimited 與需要等待的時間；
+// 不限制、豁免或不知道客戶端 IP 時一律允許
+func (limiter *rateLimiter) allow(ctx context.Context) error {
+
+	addr := clientIPFrom(ctx)
+	if limiter == nil || limiter.rate == 0 || !addr.IsValid() || limiter.exempted(addr) {
+		return nil
+	}
+	if addr.Is6() {
+		addr = netip.PrefixFrom(addr, 64).Masked().Addr()
+	}
+
+	now := time.Now()
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	limiter.sweep(now)
+
+	bucket, ok := limiter.buckets[addr]
+	if !ok {
+		bucket = &tokenBucket{tokens: limiter.burst, last: now}
+		limiter.buckets[addr] = bucket
+	}
+	bucket.tokens = min(limiter.burst, bucket.tokens+now.Sub(bucket.last).Seconds()*limiter.rate)
+	bucket.last = now
+	if bucket.tokens < 1 {
+		wait := time.Duration((1 - bucket.tokens) / limiter.rate * float64(time.Second))
+		return &RetryAfterError{Err: errRateLimited, RetryAfter: wait}
+	}
+	bucket.tokens--
+	return nil
+}
+
+func (limiter *rateLimiter) exempted(addr netip.Addr) bool {
+	for _, prefix := range limiter.exempt {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// 每分鐘清掉一次已經補滿的 bucket，補滿的 bucket 與沒有 bucket 相同；呼叫時須持有 mu
+func (limiter *rateLimiter) sweep(now time.Time) {
+	if now.Sub(limiter.lastSweep) < time.Minute {
+		return
+	}
+	limiter.lastSweep = now
+	refill := time.Duration(limiter.burst / limiter.rate * float64(time.Second))
+	for addr, bucket := range limiter.buckets {
+		if now.Sub(bucket.last) >= refill {
+			delete(limiter.buckets, addr)
+		}
+	}
+}
+
+// 檢查豁免清單的格式
+func validateExempt(values []string) error {
+	var errs []error
+	for _, value := range values {
+		if _, err := parseIPPrefix(value); err != nil {
+			errs = append(errs, fmt.Errorf("rateLimit.exempt 應為 IP 或 CIDR: %q", value))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		value  string
+		want   string
+	}{
+		// 預設不信任任何標頭，客戶端自己帶的 Fly-Client-IP 不會影響頻率限制
+		{name: "預設使用連線位址", value: "203.0.113.9", want: "192.0.2.1"},
+		{name: "設定標頭時以標頭為準", header: "Fly-Client-IP", value: "203.0.113.9", want: "203.0.113.9"},
+		{name: "設定標頭但請求沒帶", header: "Fly-Client-IP", want: "192.0.2.1"},
+		{name: "X-Forwarded-For 取第一個", header: "X-Forwarded-For", value: "203.0.113.9, 10.0.0.1", want: "203.0.113.9"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := defaultConfig("release").RateLimit
+			if test.header != "" {
+				config.ClientIPHeader = test.header
+			}
+			request := httptest.NewRequest(http.MethodGet, "/filter", nil)
+			request.RemoteAddr = "192.0.2.1:54321"
+			if test.value != "" {
+				request.Header.Set("Fly-Client-IP", test.value)
+				request.Header.Set("X-Forwarded-For", test.value)
+			}
+			addr, ok := newRateLimiter(config).clientIP(request)
+			if !ok || addr.String() != test.want {
+				t.Errorf("客戶端 IP %v，應為 %s", addr, test.want)
+			}
+		})
+	}
+}
+
+func clientContext(ip string) context.Context {
+	return context.WithValue(context.Background(), clientIPKey{}, netip.MustParseAddr(ip))
+}
+
+// 把客戶端的 bucket 往前撥，模擬經過了 elapsed
+func rewindBucket(limiter *rateLimiter, ip string, elapsed time.Duration) {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	limiter.buckets[netip.MustParseAddr(ip)].last = limiter.buckets[netip.MustParseAddr(ip)].last.Add(-elapsed)
+}
+
+func requireRateLimited(t *testing.T, err error, wantWait time.Duration) {
+	t.Helper()
+	var retryError *RetryAfterError
+	if !errors.Is(err, errRateLimited) || !errors.As(err, &retryError) {
+		t.Fatalf("應回傳 errRateLimited，得到 %v", err)
+	}
+	if retryError.RetryAfter > wantWait || retryError.RetryAfter < wantWait-100*time.Millisecond {
+		t.Errorf("需要等待 %v，應約為 %v", retryError.RetryAfter, wantWait)
+	}
+}
+
+func TestRateLimiterBurstAndRefill(t *testing.T) {
+	// 每 10 秒補一次，最多連續 2 次
+	limiter := newRateLimiter(RateLimitConfig{PerMinute: 6, Burst: 2})
+	ctx := clientContext("198.51.100.7")
+
+	for i := 0; i < 2; i++ {
+		if err := limiter.allow(ctx); err != nil {
+			t.Fatalf("第 %d 次應允許: %v", i+1, err)
+		}
+	}
+	err := limiter.allow(ctx)
+	requireRateLimited(t, err, 10*time.Second)
+
+	// 429 帶上無條件進位的秒數
+	recorder := httptest.NewRecorder()
+	writeError(recorder, httptest.NewRequest(http.MethodPost, "/searches", nil), err)
+	if recorder.Code != http.StatusTooManyRequests || recorder.Header().Get("Retry-After") != "10" {
+		t.Errorf("狀態碼 %d、Retry-After %q，應為 429、10", recorder.Code, recorder.Header().Get("Retry-After"))
+	}
+
+	// 過了 5 秒只補了半個
+	rewindBucket(limiter, "198.51.100.7", 5*time.Second)
+	requireRateLimited(t, limiter.allow(ctx), 5*time.Second)
+
+	// 再過 5 秒補滿一個
+	rewindBucket(limiter, "198.51.100.7", 5*time.Second)
+	if err := limiter.allow(ctx); err != nil {
+		t.Fatalf("補充後應允許: %v", err)
+	}
+	requireRateLimited(t, limiter.allow(ctx), 10*time.Second)
+
+	// 很久沒發起也只能累積到 burst
+	rewindBucket(limiter, "198.51.100.7", time.Hour)
+	for i := 0; i < 2; i++ {
+		if err := limiter.allow(ctx); err != nil {
+			t.Fatalf("補滿後第 %d 次應允許: %v", i+1, err)
+		}
+	}
+	requireRateLimited(t, limiter.allow(ctx), 10*time.Second)
+
+	// 其他客戶端不受影響
+	if err := limiter.allow(clientContext("198.51.100.8")); err != nil {
+		t.Errorf("其他 IP 應允許: %v", err)
+	}
+}
+
+func TestRateLimiterIPv6Prefix(t *testing.T) {
+	limiter := newRateLimiter(RateLimitConfig{PerMinute: 1, Burst: 1})
+
+	if err := limiter.allow(clientContext("2001:db8:1:2::1")); err != nil {
+		t.Fatal(err)
+	}
+	// 同一個 /64 視為同一個客戶端
+	requireRateLimited(t, limiter.allow(clientContext("2001:db8:1:2:ffff:ffff:ffff:9")), time.Minute)
+	if err := limiter.allow(clientContext("2001:db8:1:3::1")); err != nil {
+		t.Errorf("不同的 /64 應允許: %v", err)
+	}
+	// IPv4 以單一位址為單位
+	if err := limiter.allow(clientContext("198.51.100.1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := limiter.allow(clientContext("198.51.100.2")); err != nil {
+		t.Errorf("不同的 IPv4 位址應允許: %v", err)
+	}
+	if len(limiter.buckets) != 4 {
+		t.Errorf("有 %d 個 bucket，應為 4 個", len(limiter.buckets))
+	}
+}
+
+func TestRateLimiterExempt(t *testing.T) {
+	limiter := newRateLimiter(RateLimitConfig{PerMinute: 1, Burst: 1, Exempt: []string{"127.0.0.0/8", "::1", "10.1.0.0/16"}})
+	tests := []struct {
+		name string
+		ctx  context.Context
+	}{
+		{"豁免的 IPv4 網段", clientContext("127.0.0.1")},
+		{"豁免的 IPv6 位址", clientContext("::1")},
+		{"豁免的內部網段", clientContext("10.1.200.3")},
+		{"不知道客戶端 IP", context.Background()},
+	}
+	for _, test := range tests {
+		for i := 0; i < 3; i++ {
+			if err := limiter.allow(test.ctx); err != nil {
+				t.Errorf("%s: 第 %d 次應允許: %v", test.name, i+1, err)
+			}
+		}
+	}
+	if err := limiter.allow(clientContext("10.2.0.1")); err != nil {
+		t.Fatal(err)
+	}
+	requireRateLimited(t, limiter.allow(clientContext("10.2.0.1")), time.Minute)
+
+	// perMinute 為 0 或沒有設定頻率限制時不限制
+	for _, limiter := range []*rateLimiter{newRateLimiter(RateLimitConfig{}), nil} {
+		for i := 0; i < 3; i++ {
+			if err := limiter.allow(clientContext("198.51.100.7")); err != nil {
+				t.Errorf("不限制時應允許: %v", err)
+			}
+		}
+	}
+}
+
+func TestRateLimiterSweep(t *testing.T) {
+	limiter := newRateLimiter(RateLimitConfig{PerMinute: 60, Burst: 5})
+	for _, ip := range []string{"198.51.100.1", "198.51.100.2"} {
+		if err := limiter.allow(clientContext(ip)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 已經補滿的 bucket 在下次清理時移除
+	rewindBucket(limiter, "198.51.100.1", 5*time.Second)
+	limiter.lastSweep = time.Now().Add(-time.Minute)
+	if err := limiter.allow(clientContext("198.51.100.3")); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := limiter.buckets[netip.MustParseAddr("198.51.100.1")]; ok || len(limiter.buckets) != 2 {
+		t.Errorf("補滿的 bucket 應被清掉，剩下 %d 個", len(limiter.buckets))
+	}
+}
+
+// 只有發起新搜尋會用掉次數，沿用進行中或未過期的結果不受限制
+func TestRateLimiterOnlyNewSearches(t *testing.T) {
+	runner := newBlockingRunner()
+	close(runner.release)
+	limiter := newRateLimiter(RateLimitConfig{PerMinute: 1, Burst: 1})
+	manager := newJobManager(SearchConfig{Workers: 1, QueueSize: 4, JobRetention: Duration{time.Hour}, ResultTTL: Duration{time.Hour}}, limiter, runner.run)
+	manager.start()
+	defer manager.shutdown(context.Background())
+
+	ctx := clientContext("198.51.100.7")
+	first, err := manager.submit(ctx, searchRequestFor("13"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if job, err := manager.submit(ctx, searchRequestFor("13")); err != nil || job != first {
+			t.Fatalf("沿用結果不應受限制，得到 %v %v", job, err)
+		}
+	}
+	_, err = manager.submit(ctx, searchRequestFor("14"))
+	requireRateLimited(t, err, time.Minute)
+	if status, _ := classifyError(err); status != http.StatusTooManyRequests {
+		t.Errorf("狀態碼 %d，應為 429", status)
+	}
+}
+
+func TestWithClientIP(t *testing.T) {
+	limiter := newRateLimiter(RateLimitConfig{PerMinute: 1, Burst: 1})
+	var got netip.Addr
+	handler := limiter.withClientIP(func(w http.ResponseWriter, r *http.Request) {
+		got = clientIPFrom(r.Context())
+	})
+
+	request := httptest.NewRequest(http.MethodGet, "/filter", nil)
+	request.RemoteAddr = "[::ffff:198.51.100.7]:54321"
+	handler(httptest.NewRecorder(), request)
+	if got != netip.MustParseAddr("198.51.100.7") {
+		t.Errorf("客戶端 IP %v，應為 198.51.100.7", got)
+	}
+
+	// 取不到 IP 時不放進 context，allow 一律允許
+	request.RemoteAddr = "@"
+	handler(httptest.NewRecorder(), request)
+	if got.IsValid() {
+		t.Errorf("取不到 IP 時應為無效的位址，得到 %v", got)
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-rod/rod"
+	"github.com/go-rod/rod/lib/launcher"
+	"github.com/go-rod/rod/lib/proto"
+)
+
+// 各店各端點取得頁面的方式
+const (
+	// 只用 HTTP 請求
+	renderOff = "off"
+	// HTTP 取得的頁面解析不到尺碼時，改用瀏覽器渲染後再解析一次
+	renderFallback = "fallback"
+	// 一律用瀏覽器渲染
+	renderAlways = "always"
+)
+
+var renderModes = []string{renderOff, renderFallback, renderAlways}
+
+// 可封鎖的資源類型，渲染只需要 HTML 與 JavaScript
+var renderResourceTypes = map[string]proto.NetworkResourceType{
+	"image":      proto.NetworkResourceTypeImage,
+	"font":       proto.NetworkResourceTypeFont,
+	"media":      proto.NetworkResourceTypeMedia,
+	"stylesheet": proto.NetworkResourceTypeStylesheet,
+}
+
+// 頁面載入後等待 DOM 不再變動的時間，讓 JavaScript 填完資料
+const renderSettleTime = 500 * time.Millisecond
+
+var errBrowserNotFound = errors.New("找不到可用的 Chromium 瀏覽器")
+
+// renderFetcher 以無頭瀏覽器取得 JavaScript 渲染後的頁面；瀏覽器在第一次使用時才啟動，分頁放在 pool 中重複使用
+type renderFetcher struct {
+	config RenderConfig
+
+	mu       sync.Mutex
+	launcher *launcher.Launcher
+	browser  *rod.Browser
+	router   *rod.HijackRouter
+	pages    rod.Pool[rod.Page]
+	closed   bool
+}
+
+func newRenderFetcher(config RenderConfig) *renderFetcher {
+	return &renderFetcher{config: config, pages: rod.NewPagePool(config.Pages)}
+}
+
+// 設定的瀏覽器路徑，沒設定時找系統上常見的位置
+func (renderer *renderFetcher) browserPath() (string, error) {
+	if renderer.config.BrowserPath != "" {
+		if _, err := os.Stat(renderer.config.BrowserPath); err != nil {
+			return "", fmt.Errorf("%w: %s", errBrowserNotFound, renderer.config.BrowserPath)
+		}
+		return renderer.config.BrowserPath, nil
+	}
+	path, ok := launcher.LookPath()
+	if !ok {
+		return "", errBrowserNotFound
+	}
+	return path, nil
+}
+
+// 取得(必要時啟動)瀏覽器，並封鎖設定的資源類型
+func (renderer *renderFetcher) start() (*rod.Browser, error) {
+
+	renderer.mu.Lock()
+	defer renderer.mu.Unlock()
+	if renderer.closed {
+		return nil, errShuttingDown
+	}
+	if renderer.browser != nil {
+		return renderer.browser, nil
+	}
+
+	path, err := renderer.browserPath()
+	if err != nil {
+		return nil, err
+	}
+	// 容器內以 root 執行，Chromium 需要關閉 sandbox
+	browserLauncher := launcher.New().Bin(path).Headless(true).NoSandbox(true)
+	controlURL, err := browserLauncher.Launch()
+	if err != nil {
+		return nil, fmt.Errorf("啟動瀏覽器失敗: %w", err)
+	}
+	browser := rod.New().ControlURL(controlURL)
+	if err := browser.Connect(); err != nil {
+		browserLauncher.Kill()
+		return nil, fmt.Errorf("連線瀏覽器失敗: %w", err)
+	}
+
+	if len(renderer.config.BlockResources) > 0 {
+		router := browser.HijackRequests()
+		for _, name := range renderer.config.BlockResources {
+			err := router.Add("*", renderResourceTypes[name], func(hijack *rod.Hijack) {
+				hijack.Response.Fail(proto.NetworkErrorReasonBlockedByClient)
+			})
+			if err != nil {
+				browser.Close()
+				browserLauncher.Kill()
+				return nil, fmt.Errorf("設定資源封鎖失敗: %w", err)
+			}
+		}
+		go router.Run()
+		renderer.router = router
+	}
+
+	renderer.launcher = browserLauncher
+	renderer.browser = browser
+	renderLog.Info("無頭瀏覽器已啟動", "path", path, "pages", renderer.config.Pages, "block", renderer.config.BlockResources)
+	return browser, nil
+}
+
+// fetch 以瀏覽器開啟網址，等頁面載入且 DOM 穩定後回傳渲染後的 HTML；每頁最多 pageTimeout，pool 沒有空的分頁時排隊等待
+func (renderer *renderFetcher) fetch(ctx context.Context, store, pageURL string) (html string, err error) {
+
+	browser, err := renderer.start()
+	if err != nil {
+		return "", err
+	}
+
+	start := time.Now()
+	defer func() {
+		status := "ok"
+		if err != nil {
+			status = "error"
+			if errors.Is(err, context.DeadlineExceeded) {
+				status = "timeout"
+			}
+		}
+		metrics.renderRequests.add(1, store, status)
+		metrics.renderDuration.observe(time.Since(start).Seconds(), store)
+		attrs := []any{"store", store, "url", pageURL, "status", status, "durationMs", time.Since(start).Milliseconds()}
+		if err != nil {
+			renderLog.WarnContext(ctx, "瀏覽器渲染失敗", append(attrs, "error", err)...)
+			return
+		}
+		renderLog.DebugContext(ctx, "瀏覽器渲染", attrs...)
+	}()
+
+	// 等待空的分頁，搜尋被取消時不再等
+	var page *rod.Page
+	select {
+	case page = <-renderer.pages:
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+	if page == nil {
+		page, err = browser.Page(proto.TargetCreateTarget{})
+		if err != nil {
+			renderer.pages.Put(nil)
+			return "", fmt.Errorf("開啟分頁失敗: %w", err)
+		}
+	}
+
+	// Timeout 會啟動計時器，渲染完要取消，否則計時器留到期限才釋放
+	timed := page.Context(ctx).Timeout(renderer.config.PageTimeout.Duration)
+	defer timed.CancelTimeout()
+	html, err = renderPage(timed, pageURL)
+	if err != nil {
+		// 逾時或出錯的分頁狀態不明，關掉下次重開
+		page.Close()
+		renderer.pages.Put(nil)
+		return "", err
+	}
+	renderer.pages.Put(page)
+	return html, nil
+}
+
+func renderPage(page *rod.Page, pageURL string) (string, error) {
+	if err := page.Navigate(pageURL); err != nil {
+		return "", err
+	}
+	if err := page.WaitLoad(); err != nil {
+		return "", err
+	}
+	if err := page.WaitDOMStable(renderSettleTime, 0); err != nil {
+		return "", err
+	}
+	return page.HTML()
+}
+
+// 是否要用瀏覽器渲染商品頁: always 一律渲染，fallback 只在 HTTP 取得的頁面沒有可用的尺碼時渲染
+func shouldRender(mode string, hasSizes bool) bool {
+	return mode == renderAlways || (mode == renderFallback && !hasSizes)
+}
+
+// 設定了瀏覽器或系統上找得到瀏覽器時為 nil，供 /readyz 檢查
+func (renderer *renderFetcher) available() error {
+	_, err := renderer.browserPath()
+	return err
+}
+
+// close 關閉所有分頁與瀏覽器，之後的 fetch 回傳 errShuttingDown
+func (renderer *renderFetcher) close() {
+
+	renderer.mu.Lock()
+	defer renderer.mu.Unlock()
+	renderer.closed = true
+	if renderer.browser == nil {
+		return
+	}
+	renderer.pages.Cleanup(func(page *rod.Page) { page.Close() })
+	if renderer.router != nil {
+		renderer.router.Stop()
+	}
+	renderer.browser.Close()
+	renderer.launcher.Kill()
+	renderer.launcher.Cleanup()
+	renderer.browser = nil
+	renderLog.Info("無頭瀏覽器已關閉")
+}
+
+// 檢查渲染設定: 模式與可封鎖的資源類型
+func validateRenderMode(field, mode string) error {
+	if !containsString(renderModes, mode) {
+		return fmt.Errorf("%s 應為 %s: %q", field, strings.Join(renderModes, "、"), mode)
+	}
+	return nil
+}
+
+func validateBlockResources(values []string) error {
+	var errs []error
+	for _, value := range values {
+		if _, ok := renderResourceTypes[value]; !ok {
+			errs = append(errs, fmt.Errorf("render.blockResources 不支援 %q，可用的值: image、font、media、stylesheet", value))
+		}
+	}
+	return errors.Join(errs...)
+}
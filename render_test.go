@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestShouldRender(t *testing.T) {
+	tests := []struct {
+		mode     string
+		hasSizes bool
+		want     bool
+	}{
+		{renderOff, false, false},
+		{renderOff, true, false},
+		{renderFallback, false, true},
+		{renderFallback, true, false},
+		{renderAlways, false, true},
+		{renderAlways, true, true},
+	}
+	for _, test := range tests {
+		if got := shouldRender(test.mode, test.hasSizes); got != test.want {
+			t.Errorf("shouldRender(%s, %v) = %v，應為 %v", test.mode, test.hasSizes, got, test.want)
+		}
+	}
+}
+
+// 沒有瀏覽器時，off 與 fallback(HTTP 已取得尺碼)不受影響，always 回傳錯誤且不打商品頁
+func TestDAFRenderModes(t *testing.T) {
+	tests := []struct {
+		mode         string
+		wantErr      error
+		wantRequests int
+	}{
+		{mode: renderOff, wantRequests: 1},
+		{mode: renderFallback, wantRequests: 1},
+		{mode: renderAlways, wantErr: errBrowserNotFound, wantRequests: 0},
+	}
+	for _, test := range tests {
+		t.Run(test.mode, func(t *testing.T) {
+			server := newDAFTestServer(t, 0)
+			daf := server.crawler()
+			daf.config.Render.Detail = test.mode
+			daf.renderer = newRenderFetcher(RenderConfig{BrowserPath: "/nonexistent/chromium", Pages: 1})
+
+			shoe := Shoe{Store: "daf", ListID: "1_2", URL: server.URL + "/product/show/1/2/", Status: shoeStatusAvailable}
+			err := daf.getDAFSizeAndColor(context.Background(), &shoe)
+			if test.wantErr != nil {
+				if !errors.Is(err, test.wantErr) {
+					t.Fatalf("應回傳 %v，得到 %v", test.wantErr, err)
+				}
+			} else if err != nil || len(shoe.Size) == 0 {
+				t.Fatalf("應從 HTTP 取得尺碼，得到 %v %v", shoe.Size, err)
+			}
+			if got := server.totalRequests(); got != test.wantRequests {
+				t.Errorf("商品頁請求 %d 次，應為 %d 次", got, test.wantRequests)
+			}
+		})
+	}
+}
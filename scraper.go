@@ -0,0 +1,593 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// htmlScraper 依商店定義爬取 HTML 商店，流程與 D+AF 相同: 展開篩選組合、並行取得列表頁，
+// 列表每找到一雙新鞋就交給商品頁 worker 取得尺碼與顏色
+type htmlScraper struct {
+	client     *http.Client
+	definition *StoreDefinition
+	// 由定義產生的查詢參數，建立時算好
+	params StoreParams
+	// definition.Render 不是 off 時用來渲染商品頁
+	renderer *renderFetcher
+}
+
+// 建立所有啟用的、以商店定義爬取的店鋪，查詢參數與顯示名稱都從 Server.scrapers 的定義取得；
+// daf.engine 為 definition 時 D+AF 改用內建的 stores/daf.json
+func newHTMLScrapers(config Config, client *http.Client, renderer *renderFetcher) (map[string]*htmlScraper, error) {
+
+	definitions := map[string]*StoreDefinition{}
+	for store, definition := range config.storeDefinitions {
+		if config.storeEnabled(store) {
+			definitions[store] = definition
+		}
+	}
+	if config.DAF.Engine == storeEngineDefinition {
+		definition, err := builtinStoreDefinition("daf")
+		if err != nil {
+			return nil, err
+		}
+		// 網址、並行數量與渲染方式沿用 daf 設定，與手寫的爬蟲相同
+		definition.BaseURL = config.DAF.BaseURL
+		definition.MaxQueries = config.DAF.MaxQueries
+		definition.ListPageWorkers = config.DAF.ListPageWorkers
+		definition.DetailWorkers = config.DAF.DetailWorkers
+		definition.Render = config.DAF.Render
+		definitions["daf"] = definition
+	}
+
+	scrapers := map[string]*htmlScraper{}
+	for store, definition := range definitions {
+		scrapers[store] = &htmlScraper{client: client, definition: definition, params: definition.storeParams(), renderer: renderer}
+	}
+	return scrapers, nil
+}
+
+// 多選展開的列表查詢數不可超過定義的 maxQueries
+func (scraper *htmlScraper) checkQueryCount(params SearchParams) error {
+	definition := scraper.definition
+	if count := len(scraper.listQueries(params)); count > definition.MaxQueries {
+		return newParamError("", fmt.Errorf("%s 篩選條件組合過多(%d 組)，最多 %d 組，請減少篩選條件的選擇", definition.Label, count, definition.MaxQueries))
+	}
+	return nil
+}
+
+// listQuery 一組篩選條件對應的列表查詢
+type listQuery struct {
+	// 編碼後的查詢參數
+	query string
+	// 列表網址中的變數
+	variables map[string]string
+}
+
+func (scraper *htmlScraper) search(ctx context.Context, params SearchParams) ([]Shoe, error) {
+
+	definition := scraper.definition
+	shoes := []Shoe{}
+
+	// 記錄參數
+	scraperLog.InfoContext(ctx, "篩選條件", "store", definition.Store, "orderby", params.OrderBy, "sizes", params.Sizes, "colors", params.Colors, "heels", params.Heels, "cats", params.Cats)
+
+	if err := scraper.checkQueryCount(params); err != nil {
+		return shoes, err
+	}
+	queries := scraper.listQueries(params)
+	totalQueries := len(queries)
+
+	// 爬列表與爬商品頁同時進行
+	pipeline := newDetailPipeline(ctx, definition.Store, definition.DetailWorkers, scraper.getSizeAndColor)
+
+	seen := map[string]struct{}{}
+	var order []string
+	err := func() error {
+		reportProgress(ctx, "list", 0, totalQueries)
+		for i, query := range queries {
+			listShoes, err := scraper.getShoeList(ctx, query)
+			if err != nil {
+				return err
+			}
+			// 不同組合可能查到同一雙鞋
+			for _, shoe := range listShoes {
+				if _, exists := seen[shoe.ListID]; exists {
+					continue
+				}
+				seen[shoe.ListID] = struct{}{}
+				order = append(order, shoe.ListID)
+				pipeline.add(shoe)
+			}
+			reportProgress(ctx, "list", i+1, totalQueries)
+		}
+		return nil
+	}()
+	scraperLog.InfoContext(ctx, "已拿取全部篩選組合的鞋子", "store", definition.Store, "shoes", len(order))
+
+	// 等商品頁 worker 做完，依列表順序排回去
+	enriched := pipeline.wait()
+	if err != nil {
+		return shoes, err
+	}
+	for _, listID := range order {
+		shoes = append(shoes, enriched[listID])
+	}
+	return shoes, nil
+}
+
+// 依篩選參數產生所有列表查詢: expand 的參數每個值各查一次，不同參數的值互相組合
+func (scraper *htmlScraper) listQueries(params SearchParams) []listQuery {
+
+	definition := scraper.definition
+	selected := map[string][]string{
+		"searchSize":  params.Sizes,
+		"searchColor": params.Colors,
+		"searchHeel":  params.Heels,
+		"searchCat":   params.Cats,
+	}
+	if params.OrderBy != "" {
+		selected["orderby"] = []string{params.OrderBy}
+	}
+
+	// 每個組合是參數名稱對應這次要帶的值
+	combinations := []map[string][]string{{}}
+	for _, name := range definitionFilterNames {
+		filter := definition.Filters[name]
+		if filter == nil {
+			continue
+		}
+		values := filter.values(selected[name])
+		if !filter.Expand || len(values) <= 1 {
+			for _, combination := range combinations {
+				combination[name] = values
+			}
+			continue
+		}
+		var expanded []map[string][]string
+		for _, combination := range combinations {
+			for _, value := range values {
+				next := make(map[string][]string, len(combination)+1)
+				for key, values := range combination {
+					next[key] = values
+				}
+				next[name] = []string{value}
+				expanded = append(expanded, next)
+			}
+		}
+		combinations = expanded
+	}
+
+	queries := make([]listQuery, 0, len(combinations))
+	for _, combination := range combinations {
+		query := url.Values{}
+		for name, values := range combination {
+			filter := definition.Filters[name]
+			if filter.Query == "" {
+				continue
+			}
+			if len(values) == 0 {
+				if filter.KeepEmpty {
+					query.Set(filter.Query, "")
+				}
+				continue
+			}
+			query[filter.Query] = values
+		}
+		variables := map[string]string{}
+		for name, variable := range definition.List.Variables {
+			variables[name] = variable.Default
+			if values := combination[variable.Filter]; len(values) > 0 {
+				if value, ok := variable.Values[values[0]]; ok {
+					variables[name] = value
+				}
+			}
+		}
+		// 參數值都要經過編碼再帶給商店
+		queries = append(queries, listQuery{query: query.Encode(), variables: variables})
+	}
+	return queries
+}
+
+// 選了「不限」就只帶這個值
+func (filter *FilterDefinition) values(selected []string) []string {
+	if filter.Any != "" && containsString(selected, filter.Any) {
+		return []string{filter.Any}
+	}
+	return selected
+}
+
+// 列表頁網址
+func (scraper *htmlScraper) listPageURL(query listQuery, page int) string {
+	replacements := []string{
+		"{baseURL}", scraper.definition.BaseURL,
+		"{page}", strconv.Itoa(page),
+		"{query}", query.query,
+	}
+	for name, value := range query.variables {
+		replacements = append(replacements, "{"+name+"}", value)
+	}
+	return strings.NewReplacer(replacements...).Replace(scraper.definition.List.URL)
+}
+
+// 取得單一篩選組合下的所有鞋子(尚未取得尺碼與顏色)，第一頁取得總頁數後其餘頁面並行取得
+func (scraper *htmlScraper) getShoeList(ctx context.Context, query listQuery) ([]Shoe, error) {
+
+	definition := scraper.definition
+	list := &definition.List
+	scraperLog.DebugContext(ctx, "篩選組合", "store", definition.Store, "query", query.query, "variables", query.variables)
+
+	firstURL := scraper.listPageURL(query, list.FirstPage)
+	body, err := scraper.getPage(ctx, firstURL)
+	if err != nil {
+		scraperLog.ErrorContext(ctx, "商品列表初始請求錯誤", "store", definition.Store, "error", err)
+		return nil, err
+	}
+	doc, err := newHTMLDocument(body, firstURL)
+	if err != nil {
+		return nil, newParseError(definition.Label, "列表頁", err)
+	}
+
+	totalPage := 1
+	if list.TotalPages != nil {
+		value := list.TotalPages.value(doc.Selection, doc.Url)
+		totalPage, err = strconv.Atoi(value)
+		if err != nil {
+			return nil, newParseError(definition.Label, "totalPages", fmt.Errorf("無法取得總頁數: %q", value))
+		}
+	}
+	scraperLog.DebugContext(ctx, "已拿到總頁數，要取全部篩選的鞋子", "store", definition.Store, "totalPage", totalPage)
+
+	shoes, err := scraper.parseListPage(body, doc)
+	if err != nil {
+		return nil, err
+	}
+	if totalPage < 2 {
+		return shoes, nil
+	}
+
+	// 第一頁已經拿到了，其餘頁面並行取得，結果依頁碼排序
+	pages := make([][]Shoe, totalPage)
+	errs := make([]error, totalPage)
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, definition.ListPageWorkers)
+	for i := 1; i < totalPage; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			// 異常的列表頁只讓這個篩選組合失敗
+			defer recoverPanic(definition.Store+" 列表頁", &errs[i])
+
+			pageURL := scraper.listPageURL(query, list.FirstPage+i)
+			body, err := scraper.getPage(ctx, pageURL)
+			if err != nil {
+				scraperLog.ErrorContext(ctx, "列表頁請求錯誤", "store", definition.Store, "page", list.FirstPage+i, "error", err)
+				errs[i] = err
+				return
+			}
+			doc, err := newHTMLDocument(body, pageURL)
+			if err != nil {
+				errs[i] = newParseError(definition.Label, "列表頁", err)
+				return
+			}
+			pages[i], errs[i] = scraper.parseListPage(body, doc)
+		}(i)
+	}
+	wg.Wait()
+
+	for i := 1; i < totalPage; i++ {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		shoes = append(shoes, pages[i]...)
+	}
+	scraperLog.DebugContext(ctx, "已拿取全部篩選的鞋子", "store", definition.Store, "shoes", len(shoes))
+	return shoes, nil
+}
+
+// 從列表頁取出這一頁的鞋子，再以 joins 補上列表其他位置的欄位
+func (scraper *htmlScraper) parseListPage(body []byte, doc *goquery.Document) ([]Shoe, error) {
+
+	definition := scraper.definition
+	list := &definition.List
+
+	// 每個商品的欄位值
+	var items []map[string]string
+	if source := list.Items.JSON; source != nil {
+		values, err := scraper.jsonItems(body, doc)
+		if err != nil {
+			return nil, newParseError(definition.Label, "list.items", err)
+		}
+		for _, value := range values {
+			item := map[string]string{}
+			for name, field := range list.Fields {
+				item[name] = field.jsonValue(value, doc.Url)
+			}
+			items = append(items, item)
+		}
+	} else {
+		doc.FindMatcher(list.Items.matcher).Each(func(_ int, node *goquery.Selection) {
+			item := map[string]string{}
+			for name, field := range list.Fields {
+				item[name] = field.value(node, doc.Url)
+			}
+			items = append(items, item)
+		})
+	}
+
+	shoes := []Shoe{}
+	index := map[string]int{}
+	for _, item := range items {
+		// 缺少編號或名稱的略過
+		if item["id"] == "" || item["name"] == "" {
+			scraperLog.Warn("商品列表項目缺少 id 或 name", "store", definition.Store, "item", item)
+			continue
+		}
+		shoe := Shoe{Store: definition.Store, Status: shoeStatusAvailable}
+		for name, value := range item {
+			setShoeField(&shoe, name, value)
+		}
+		// 從標題解析款式屬性
+		shoe.Attributes = extractStyleAttributes(shoe.Name)
+		index[shoe.ListID] = len(shoes)
+		shoes = append(shoes, shoe)
+	}
+
+	for _, join := range list.Joins {
+		doc.FindMatcher(join.matcher).Each(func(_ int, node *goquery.Selection) {
+			i, ok := index[join.Key.value(node, doc.Url)]
+			if !ok {
+				return
+			}
+			for name, field := range join.Fields {
+				if value := field.value(node, doc.Url); value != "" {
+					setShoeField(&shoes[i], name, value)
+				}
+			}
+		})
+	}
+	return shoes, nil
+}
+
+// 取出頁面內嵌的 JSON 商品陣列
+func (scraper *htmlScraper) jsonItems(body []byte, doc *goquery.Document) ([]interface{}, error) {
+
+	source := scraper.definition.List.Items.JSON
+	text := string(body)
+	if source.matcher != nil {
+		text = doc.FindMatcher(source.matcher).First().Text()
+	}
+	// regex 只用來找 JSON 的開頭(有群組時為第一個群組的開頭)，結尾交給 JSON 解析，巢狀的陣列、物件才不會被截斷
+	if source.regex != nil {
+		match := source.regex.FindStringSubmatchIndex(text)
+		if match == nil {
+			return nil, errors.New("未找到符合 regex 的 JSON")
+		}
+		start := match[0]
+		if len(match) > 2 && match[2] >= 0 {
+			start = match[2]
+		}
+		text = text[start:]
+	}
+
+	var data interface{}
+	if err := decodeJSONPrefix([]byte(text), &data); err != nil {
+		return nil, err
+	}
+	value, ok := lookupJSONPath(data, source.Path)
+	if !ok {
+		return nil, fmt.Errorf("JSON 中沒有 %q", source.Path)
+	}
+	items, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("JSON 的 %q 不是陣列", source.Path)
+	}
+	return items, nil
+}
+
+// 解析開頭的一個完整 JSON 值，後面的內容(例如 JavaScript 的 });)忽略
+func decodeJSONPrefix(data []byte, value interface{}) error {
+	return json.NewDecoder(bytes.NewReader(data)).Decode(value)
+}
+
+func setShoeField(shoe *Shoe, name, value string) {
+	switch name {
+	case "id":
+		shoe.ListID = value
+	case "name":
+		shoe.Name = value
+	case "price":
+		shoe.Price = value
+	case "image":
+		shoe.Image = value
+	case "url":
+		shoe.URL = value
+	case "familyID":
+		shoe.FamilyID = value
+	}
+}
+
+// 訪問商品頁，取得一雙鞋的尺碼、顏色與庫存
+func (scraper *htmlScraper) getSizeAndColor(ctx context.Context, shoe *Shoe) error {
+
+	definition := scraper.definition
+	detail := definition.Detail
+	if detail == nil {
+		return nil
+	}
+	if shoe.URL == "" {
+		return newParseError(definition.Label, "url", errors.New("列表上沒有商品頁網址"))
+	}
+
+	mode := definition.Render.Detail
+	// 解析不到尺碼時會標記售罄，渲染後重新解析前先還原列表上的狀態
+	listStatus := shoe.Status
+	var doc *goquery.Document
+	if mode != renderAlways {
+		body, err := scraper.getPage(ctx, shoe.URL)
+		if err != nil {
+			scraperLog.WarnContext(ctx, "遍歷訪問各商品時請求錯誤", "store", definition.Store, "listID", shoe.ListID, "url", shoe.URL, "error", err)
+			return err
+		}
+		doc, err = newHTMLDocument(body, shoe.URL)
+		if err != nil {
+			return newParseError(definition.Label, "商品頁", err)
+		}
+		scraper.extractSizes(doc, shoe)
+	}
+
+	// 一律渲染，或 HTTP 取得的頁面解析不到尺碼時改用瀏覽器渲染後再解析一次
+	if shouldRender(mode, len(shoe.Size) > 0) {
+		html, err := scraper.renderer.fetch(ctx, definition.Store, shoe.URL)
+		if err == nil {
+			doc, err = newHTMLDocument([]byte(html), shoe.URL)
+		}
+		if err != nil {
+			// fallback 時保留 HTTP 的結果
+			if mode == renderAlways {
+				return err
+			}
+		} else {
+			shoe.Status = listStatus
+			shoe.Size = nil
+			scraper.extractSizes(doc, shoe)
+			scraperLog.DebugContext(ctx, "以瀏覽器渲染商品頁", "store", definition.Store, "listID", shoe.ListID, "mode", mode, "sizes", len(shoe.Size))
+		}
+	}
+
+	if detail.Colors != nil {
+		shoe.Color = append(shoe.Color, detail.Colors.values(doc.Selection, doc.Url)...)
+	}
+	// 款式屬性，商品頁描述補充標題沒寫到的部分
+	if detail.Description != nil {
+		description := detail.Description.value(doc.Selection, doc.Url)
+		shoe.Attributes = mergeStyleAttributes(shoe.Attributes, extractStyleAttributes(description))
+	}
+	return nil
+}
+
+// 取出尺碼並判斷是否售罄
+func (scraper *htmlScraper) extractSizes(doc *goquery.Document, shoe *Shoe) {
+	detail := scraper.definition.Detail
+	if detail.Sizes != nil {
+		shoe.Size = append(shoe.Size, detail.Sizes.values(doc.Selection, doc.Url)...)
+	}
+	if (detail.SoldOutWhenNoSizes && len(shoe.Size) == 0) || (detail.SoldOut != nil && detail.SoldOut.exists(doc.Selection, doc.Url)) {
+		scraperLog.Debug("商品頁標示售罄或沒有尺碼", "store", scraper.definition.Store, "name", shoe.Name)
+		shoe.Status = shoeStatusSoldOut
+	}
+}
+
+// 向商店發 GET 請求並讀取 Body，請求本身的 log 由 upstream 子系統記錄
+func (scraper *htmlScraper) getPage(ctx context.Context, pageURL string) ([]byte, error) {
+	resp, err := getWithContext(ctx, scraper.client, pageURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+// 解析 HTML，pageURL 用來把相對網址轉成絕對網址
+func newHTMLDocument(body []byte, pageURL string) (*goquery.Document, error) {
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	doc.Url, err = url.Parse(pageURL)
+	if err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// 所有符合的節點各取一個值，略過空值
+func (field *FieldDefinition) values(selection *goquery.Selection, base *url.URL) []string {
+	nodes := selection
+	if field.matcher != nil {
+		nodes = selection.FindMatcher(field.matcher)
+	}
+	var values []string
+	nodes.Each(func(_ int, node *goquery.Selection) {
+		raw := node.Text()
+		if field.Attr != "" {
+			var ok bool
+			if raw, ok = node.Attr(field.Attr); !ok {
+				return
+			}
+		}
+		if value := field.transform(raw, base); value != "" {
+			values = append(values, value)
+		}
+	})
+	return values
+}
+
+// 第一個符合的值，沒有時為空字串
+func (field *FieldDefinition) value(selection *goquery.Selection, base *url.URL) string {
+	values := field.values(selection, base)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// 只看選擇器時，找到節點就算存在；有 attr 或 regex 時要取得到值
+func (field *FieldDefinition) exists(selection *goquery.Selection, base *url.URL) bool {
+	if field.Attr == "" && field.regex == nil {
+		return field.matcher == nil || selection.FindMatcher(field.matcher).Length() > 0
+	}
+	return len(field.values(selection, base)) > 0
+}
+
+// JSON 商品以 path 取值
+func (field *FieldDefinition) jsonValue(item interface{}, base *url.URL) string {
+	value, ok := lookupJSONPath(item, field.Path)
+	if !ok {
+		return ""
+	}
+	return field.transform(jsonScalar(value), base)
+}
+
+// 去除前後空白後套用 regex 與 template，absolute 時以頁面網址轉成絕對網址；不符合 regex 時為空字串
+func (field *FieldDefinition) transform(raw string, base *url.URL) string {
+	value := strings.TrimSpace(raw)
+	if field.regex != nil {
+		match := field.regex.FindStringSubmatchIndex(value)
+		if match == nil {
+			return ""
+		}
+		switch {
+		case field.Template != "":
+			value = string(field.regex.ExpandString(nil, field.Template, value, match))
+		case field.regex.NumSubexp() > 0:
+			if match[2] < 0 {
+				return ""
+			}
+			value = value[match[2]:match[3]]
+		default:
+			value = value[match[0]:match[1]]
+		}
+	}
+	if field.Absolute && value != "" && base != nil {
+		resolved, err := base.Parse(value)
+		if err != nil {
+			return ""
+		}
+		value = resolved.String()
+	}
+	return value
+}
@@ -27,8 +27,9 @@ function submitAnnsForm() {
   });
 
   fetch(`${url}?${params}`)
-    .then((response) => response.json())
-    .then((data) => {
+    .then(parseApiResponse)
+    .then((body) => {
+      const data = body.data;
       Swal.close(); // 關閉讀取中的遮罩
       document.getElementById("shopname").innerText = "Ann's";
       const tableBody = document.querySelector("tbody");
@@ -67,6 +68,7 @@ function submitAnnsForm() {
       Swal.fire({
         icon: "success",
         title: "資料搜索成功",
+        text: formatWarnings(body.warnings),
         showConfirmButton: false,
         timer: 1500,
       });
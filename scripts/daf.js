@@ -22,8 +22,9 @@ function submitDafForm() {
   });
 
   fetch(`${url}?${params}`)
-    .then((response) => response.json())
-    .then((data) => {
+    .then(parseApiResponse)
+    .then((body) => {
+      const data = body.data;
       Swal.close(); // 關閉讀取中的遮罩
       document.getElementById("shopname").innerText = "D+AF";
       const tableBody = document.querySelector("tbody");
@@ -57,6 +58,7 @@ function submitDafForm() {
       Swal.fire({
         icon: "success",
         title: "資料搜索成功",
+        text: formatWarnings(body.warnings),
         showConfirmButton: false,
         timer: 1500,
       });
@@ -35,6 +35,42 @@ function highlightSizes(sizes, selectedSize) {
     .join(", ");
 }
 
+// 解析 API 回應，失敗時依錯誤代碼丟出可顯示的錯誤
+function parseApiResponse(response) {
+  return response.json().then((body) => {
+    if (body.error) {
+      throw new Error(apiErrorMessage(body.error));
+    }
+    return body;
+  });
+}
+
+// 錯誤代碼對應的訊息
+function apiErrorMessage(error) {
+  switch (error.code) {
+    case "unknown_store":
+      return "未知的商店";
+    case "upstream_timeout":
+      return "商店回應逾時，請稍後再試";
+    case "upstream_error":
+      return "無法連線到商店，請稍後再試";
+    case "parse_error":
+      return "商店網頁格式有變動，暫時無法取得資料";
+    case "busy":
+      return "目前查詢的人太多，請稍後再試";
+    default:
+      return error.message;
+  }
+}
+
+// 有商品資料不完整時的提示
+function formatWarnings(warnings) {
+  if (!warnings || warnings.length === 0) {
+    return "";
+  }
+  return `有 ${warnings.length} 筆商品的尺碼或庫存未能取得`;
+}
+
 // 將鞋子顏色隔開
 function formatShoeColor(shoe) {
   if (!shoe.color) {
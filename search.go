@@ -0,0 +1,291 @@
+package main
+
+import (
+	"container/list"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// BM25 參數
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// indexedDoc 索引中的一個商品
+type indexedDoc struct {
+	Shoe        Shoe
+	Description string
+	Tokens      []string // 不重複的詞，移除時用
+	Length      int
+	IndexedAt   time.Time
+
+	element *list.Element
+}
+
+// searchIndex 以商品名稱、描述、分類建立的倒排索引，所有店鋪共用；與 catalog 一樣最多保存 catalogSize 個商品，超過 catalogTTL 沒再爬到的商品搜尋不到
+type searchIndex struct {
+	mu          sync.RWMutex
+	docs        map[string]*indexedDoc
+	postings    map[string]map[string]int // token -> docKey -> 出現次數
+	totalLength int
+	// 依加入時間排列的 key，最舊的在尾端，清除時只需從尾端移除
+	order *list.List
+	// 商品的分類名稱也列入索引
+	catalog *productCatalog
+}
+
+func newSearchIndex(catalog *productCatalog) *searchIndex {
+	return &searchIndex{
+		catalog:  catalog,
+		docs:     map[string]*indexedDoc{},
+		order:    list.New(),
+		postings: map[string]map[string]int{},
+	}
+}
+
+// searchHit 搜尋結果與相關度分數
+type searchHit struct {
+	Key   string
+	Shoe  Shoe
+	Score float64
+}
+
+// 把爬到的鞋子加入(或更新)索引，描述沿用之前記下的
+func (index *searchIndex) addShoes(shoes []Shoe) {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+	for _, shoe := range shoes {
+		key := catalogKey(shoe.Store, shoe.ListID)
+		description := ""
+		if doc, exists := index.docs[key]; exists {
+			description = doc.Description
+		}
+		index.put(key, shoe, description)
+	}
+	index.evict()
+}
+
+// 商品詳細資訊才有描述，取得後補進索引
+func (index *searchIndex) addDescription(store, listID, description string) {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+	key := catalogKey(store, listID)
+	doc, exists := index.docs[key]
+	if !exists {
+		return
+	}
+	index.put(key, doc.Shoe, description)
+}
+
+// 呼叫前需持有寫入鎖
+func (index *searchIndex) put(key string, shoe Shoe, description string) {
+	index.remove(key)
+
+	category := ""
+	if entry, ok := index.catalog.lookup(shoe.Store, shoe.ListID); ok {
+		category = entry.Category
+	}
+	text := strings.Join([]string{shoe.Name, description, category, strings.Join(shoe.Color, " "), shoe.FamilyColor}, " ")
+	tokens := tokenize(text, true)
+
+	for _, token := range tokens {
+		if index.postings[token] == nil {
+			index.postings[token] = map[string]int{}
+		}
+		index.postings[token][key]++
+	}
+	index.docs[key] = &indexedDoc{Shoe: shoe, Description: description, Tokens: mergeUnique(tokens), Length: len(tokens), IndexedAt: time.Now(), element: index.order.PushFront(key)}
+	index.totalLength += len(tokens)
+}
+
+// 呼叫前需持有寫入鎖
+func (index *searchIndex) remove(key string) {
+	doc, exists := index.docs[key]
+	if !exists {
+		return
+	}
+	for _, token := range doc.Tokens {
+		delete(index.postings[token], key)
+		if len(index.postings[token]) == 0 {
+			delete(index.postings, token)
+		}
+	}
+	index.totalLength -= doc.Length
+	index.order.Remove(doc.element)
+	delete(index.docs, key)
+}
+
+// 從最舊的一端移除過期的商品，仍然超過 catalogSize 時繼續移除最舊的；呼叫前需持有寫入鎖
+func (index *searchIndex) evict() {
+	for oldest := index.order.Back(); oldest != nil; oldest = index.order.Back() {
+		key := oldest.Value.(string)
+		if len(index.docs) <= catalogSize && time.Since(index.docs[key].IndexedAt) < catalogTTL {
+			return
+		}
+		index.remove(key)
+	}
+}
+
+// 搜尋 query，所有詞都要出現才算符合，依 BM25 分數排序；store 為空時搜尋所有店鋪
+func (index *searchIndex) search(query, store string) []searchHit {
+	tokens := mergeUnique(tokenize(query, false))
+	if len(tokens) == 0 {
+		return nil
+	}
+
+	index.mu.RLock()
+	defer index.mu.RUnlock()
+
+	if len(index.docs) == 0 {
+		return nil
+	}
+	averageLength := float64(index.totalLength) / float64(len(index.docs))
+
+	scores := map[string]float64{}
+	for i, token := range tokens {
+		docs := index.postings[token]
+		idf := math.Log(1 + (float64(len(index.docs))-float64(len(docs))+0.5)/(float64(len(docs))+0.5))
+
+		next := map[string]float64{}
+		for key, frequency := range docs {
+			// 第一個詞決定候選集合，之後的詞只保留仍在集合中的
+			if _, ok := scores[key]; i > 0 && !ok {
+				continue
+			}
+			doc := index.docs[key]
+			if store != "" && doc.Shoe.Store != store {
+				continue
+			}
+			if time.Since(doc.IndexedAt) >= catalogTTL {
+				continue
+			}
+			tf := float64(frequency)
+			norm := tf * (bm25K1 + 1) / (tf + bm25K1*(1-bm25B+bm25B*float64(doc.Length)/averageLength))
+			next[key] = scores[key] + idf*norm
+		}
+		scores = next
+		if len(scores) == 0 {
+			return nil
+		}
+	}
+
+	hits := make([]searchHit, 0, len(scores))
+	for key, score := range scores {
+		hits = append(hits, searchHit{Key: key, Shoe: index.docs[key].Shoe, Score: score})
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].Key < hits[j].Key
+	})
+	return hits
+}
+
+// 只保留符合 query 的鞋子並依相關度排序
+func (index *searchIndex) filterShoesByQuery(shoes []Shoe, query string) []Shoe {
+	if strings.TrimSpace(query) == "" {
+		return shoes
+	}
+	store := ""
+	if len(shoes) > 0 {
+		store = shoes[0].Store
+	}
+
+	// 用索引中的分數排序，但回傳這次爬到的鞋子資料
+	current := map[string]Shoe{}
+	for _, shoe := range shoes {
+		current[catalogKey(shoe.Store, shoe.ListID)] = shoe
+	}
+	filteredShoes := []Shoe{}
+	for _, hit := range index.search(query, store) {
+		if shoe, ok := current[hit.Key]; ok {
+			filteredShoes = append(filteredShoes, shoe)
+		}
+	}
+	return filteredShoes
+}
+
+// 不指定店鋪時從索引搜尋所有店鋪已爬過的商品，尺碼以 EU 尺碼(如 41)篩選，有腳型時依各店的建議尺碼篩選
+func (index *searchIndex) searchIndexedShoes(params SearchParams, profile FootProfile, hasProfile bool) []Shoe {
+	shoes := []Shoe{}
+	for _, hit := range index.search(params.Query, "") {
+		sizes := params.Sizes
+		if hasProfile && len(sizes) == 0 {
+			sizes = recommendedSizeLabels(hit.Shoe.Store, profile)
+		}
+		if hit.Shoe.Status == shoeStatusAvailable && len(sizes) > 0 && !hasAnySize(hit.Shoe, sizes) {
+			continue
+		}
+		shoes = append(shoes, hit.Shoe)
+	}
+	return shoes
+}
+
+// 全形轉半形、轉小寫
+func normalizeText(text string) string {
+	var builder strings.Builder
+	for _, r := range text {
+		switch {
+		case r == '　':
+			r = ' '
+		case r >= '！' && r <= '～':
+			r -= 0xFEE0
+		}
+		builder.WriteRune(unicode.ToLower(r))
+	}
+	return builder.String()
+}
+
+// 是否為中日文字元
+func isCJK(r rune) bool {
+	return unicode.Is(unicode.Han, r) || unicode.Is(unicode.Hiragana, r) || unicode.Is(unicode.Katakana, r)
+}
+
+// 斷詞: 英數字以連續字串為一詞，中文以相鄰兩字(bigram)為一詞。
+// 建索引時(forIndex)另外加入單字，查詢時只有一個中文字才用單字查
+func tokenize(text string, forIndex bool) []string {
+	var tokens []string
+	var word []rune
+	var cjk []rune
+
+	flushWord := func() {
+		if len(word) > 0 {
+			tokens = append(tokens, string(word))
+			word = word[:0]
+		}
+	}
+	flushCJK := func() {
+		if len(cjk) == 1 || (forIndex && len(cjk) > 0) {
+			for _, r := range cjk {
+				tokens = append(tokens, string(r))
+			}
+		}
+		for i := 0; i+1 < len(cjk); i++ {
+			tokens = append(tokens, string(cjk[i:i+2]))
+		}
+		cjk = cjk[:0]
+	}
+
+	for _, r := range normalizeText(text) {
+		switch {
+		case isCJK(r):
+			flushWord()
+			cjk = append(cjk, r)
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			flushCJK()
+			word = append(word, r)
+		default:
+			flushWord()
+			flushCJK()
+		}
+	}
+	flushWord()
+	flushCJK()
+	return tokens
+}
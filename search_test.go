@@ -0,0 +1,190 @@
+package main
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		text     string
+		forIndex bool
+		want     []string
+	}{
+		{text: "Pointed Toe 41", want: []string{"pointed", "toe", "41"}},
+		{text: "ＡＢＣ　１２３", want: []string{"abc", "123"}},
+		{text: "尖頭鞋", want: []string{"尖頭", "頭鞋"}},
+		{text: "尖頭鞋", forIndex: true, want: []string{"尖", "頭", "鞋", "尖頭", "頭鞋"}},
+		{text: "鞋", want: []string{"鞋"}},
+		{text: "黑色mary珍鞋", want: []string{"黑色", "mary", "珍鞋"}},
+		{text: "瑪莉珍，跟鞋", want: []string{"瑪莉", "莉珍", "跟鞋"}},
+		{text: "サンダル", want: []string{"サン", "ンダ", "ダル"}},
+		{text: " ,.! ", want: nil},
+	}
+	for _, test := range tests {
+		if got := tokenize(test.text, test.forIndex); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("tokenize(%q, %v) = %q，應為 %q", test.text, test.forIndex, got, test.want)
+		}
+	}
+}
+
+func testShoe(store, listID, name string) Shoe {
+	return Shoe{Store: store, ListID: listID, Name: name, Status: shoeStatusAvailable}
+}
+
+func hitKeys(hits []searchHit) []string {
+	keys := []string{}
+	for _, hit := range hits {
+		keys = append(keys, hit.Key)
+	}
+	return keys
+}
+
+func TestSearchIndexRanking(t *testing.T) {
+	index := newSearchIndex(newProductCatalog())
+	index.addShoes([]Shoe{
+		testShoe("daf", "1_1", "尖頭跟鞋"),
+		testShoe("daf", "2_1", "尖頭瑪莉珍跟鞋 尖頭款"),
+		testShoe("anns", "3", "圓頭樂福鞋"),
+		testShoe("anns", "4", "尖頭 pointed loafer"),
+	})
+
+	tests := []struct {
+		query string
+		store string
+		want  []string
+	}{
+		// 短的商品名稱排前面
+		{query: "尖頭", want: []string{"anns/4", "daf/1_1", "daf/2_1"}},
+		// 所有詞都要出現
+		{query: "尖頭 跟鞋", want: []string{"daf/1_1", "daf/2_1"}},
+		{query: "瑪莉珍", want: []string{"daf/2_1"}},
+		// 單一中文字以單字查詢
+		{query: "樂", want: []string{"anns/3"}},
+		{query: "Pointed", want: []string{"anns/4"}},
+		{query: "尖頭", store: "anns", want: []string{"anns/4"}},
+		// 中文不會被拆開的字誤中
+		{query: "頭尖", want: []string{}},
+		{query: "boots", want: []string{}},
+	}
+	for _, test := range tests {
+		if got := hitKeys(index.search(test.query, test.store)); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("search(%q, %q) = %v，應為 %v", test.query, test.store, got, test.want)
+		}
+	}
+}
+
+// 詞越少見分數越高，出現次數多、商品名稱短的分數較高
+func TestSearchIndexBM25(t *testing.T) {
+	index := newSearchIndex(newProductCatalog())
+	index.addShoes([]Shoe{
+		testShoe("daf", "1_1", "黑色 短靴"),
+		testShoe("daf", "2_1", "黑色 短靴 側拉鍊 粗跟 真皮 內增高"),
+		testShoe("daf", "3_1", "黑色 涼鞋"),
+		testShoe("anns", "4", "涼鞋 涼鞋"),
+		testShoe("anns", "5", "涼鞋 拖鞋"),
+	})
+
+	if got := hitKeys(index.search("涼鞋", "anns")); !reflect.DeepEqual(got, []string{"anns/4", "anns/5"}) {
+		t.Errorf("長度相同時出現次數多的應排前面: %v", got)
+	}
+
+	hits := index.search("短靴", "")
+	if got := hitKeys(hits); !reflect.DeepEqual(got, []string{"daf/1_1", "daf/2_1"}) {
+		t.Fatalf("短的商品名稱應排前面: %v", got)
+	}
+	common := index.search("黑色", "")
+	if len(common) != 3 || common[0].Score >= hits[0].Score {
+		t.Errorf("所有商品都有的詞分數應較低: %v vs %v", common, hits)
+	}
+}
+
+func TestSearchIndexUpdate(t *testing.T) {
+	index := newSearchIndex(newProductCatalog())
+	index.addShoes([]Shoe{testShoe("daf", "1_1", "尖頭跟鞋")})
+	index.addDescription("daf", "1_1", "真皮 loafer")
+	index.addShoes([]Shoe{testShoe("daf", "1_1", "圓頭跟鞋")})
+
+	if hits := index.search("尖頭", ""); len(hits) != 0 {
+		t.Errorf("更新後舊名稱不應再查到: %v", hitKeys(hits))
+	}
+	if hits := index.search("圓頭 loafer", ""); len(hits) != 1 {
+		t.Errorf("更新名稱時應沿用描述: %v", hitKeys(hits))
+	}
+	index.addDescription("daf", "9_9", "不存在")
+	if len(index.docs) != 1 || index.totalLength != index.docs["daf/1_1"].Length {
+		t.Errorf("索引大小 %d、總長度 %d 不一致", len(index.docs), index.totalLength)
+	}
+}
+
+func TestSearchIndexEviction(t *testing.T) {
+	index := newSearchIndex(newProductCatalog())
+	index.addShoes([]Shoe{testShoe("daf", "old_1", "過期的尖頭鞋")})
+	index.docs["daf/old_1"].IndexedAt = time.Now().Add(-catalogTTL)
+	if hits := index.search("尖頭", ""); len(hits) != 0 {
+		t.Errorf("過期的商品不應搜尋到: %v", hitKeys(hits))
+	}
+
+	shoes := make([]Shoe, 0, catalogSize+1)
+	for i := 0; i <= catalogSize; i++ {
+		shoes = append(shoes, testShoe("anns", strconv.Itoa(i), "鞋"))
+	}
+	index.addShoes(shoes)
+	if len(index.docs) != catalogSize {
+		t.Fatalf("索引有 %d 個商品，最多應為 %d", len(index.docs), catalogSize)
+	}
+	if _, exists := index.docs["daf/old_1"]; exists {
+		t.Error("應先移除過期的商品")
+	}
+	if len(index.postings["鞋"]) != catalogSize || index.postings["尖頭"] != nil {
+		t.Error("移除商品時應一併移除倒排索引")
+	}
+}
+
+func TestCatalogEviction(t *testing.T) {
+	c := newProductCatalog()
+	c.remember(catalogEntry{Store: "anns", ListID: "old"})
+	c.entries["anns/old"].Value = catalogEntry{Store: "anns", ListID: "old", RefreshedAt: time.Now().Add(-catalogTTL)}
+	if _, ok := c.lookup("anns", "old"); ok {
+		t.Error("過期的商品不應查到")
+	}
+	for i := 0; i < catalogSize; i++ {
+		c.remember(catalogEntry{Store: "anns", ListID: strconv.Itoa(i)})
+	}
+	// 過期的商品最先清掉
+	if _, exists := c.entries["anns/old"]; exists || len(c.entries) != catalogSize {
+		t.Fatalf("應先清掉過期的商品，目錄有 %d 個商品", len(c.entries))
+	}
+
+	// 重新爬到的商品移到最新，滿了時清掉的是最久沒爬到的
+	c.remember(catalogEntry{Store: "anns", ListID: "0", Category: "靴子"})
+	c.remember(catalogEntry{Store: "anns", ListID: "new"})
+	if len(c.entries) != catalogSize || c.order.Len() != catalogSize {
+		t.Errorf("目錄有 %d 個商品，最多應為 %d", len(c.entries), catalogSize)
+	}
+	if _, ok := c.lookup("anns", "1"); ok {
+		t.Error("最久沒爬到的商品應被清掉")
+	}
+	for _, listID := range []string{"0", "2", "new"} {
+		if _, ok := c.lookup("anns", listID); !ok {
+			t.Errorf("商品 %s 應保留", listID)
+		}
+	}
+	if entry, _ := c.lookup("anns", "0"); entry.Category != "靴子" {
+		t.Errorf("應覆蓋成最新的資訊，得到 %+v", entry)
+	}
+}
+
+// 目錄滿了之後每次加入都只清掉尾端，不應隨商品數變慢
+func BenchmarkCatalogRememberFull(b *testing.B) {
+	c := newProductCatalog()
+	for i := 0; i < catalogSize; i++ {
+		c.remember(catalogEntry{Store: "anns", ListID: strconv.Itoa(i)})
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.remember(catalogEntry{Store: "daf", ListID: strconv.Itoa(i)})
+	}
+}
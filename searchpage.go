@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// /search 沒帶 pageSize 時每頁顯示的鞋子數
+const searchPageSize = 20
+
+// 內建店鋪在頁面上顯示的名稱，商店定義的店鋪由 Server.storeDisplayName 從定義取得
+var builtinStoreDisplayNames = map[string]string{
+	"daf":  "D+AF",
+	"anns": "Ann's",
+	"":     "已爬過的商品",
+}
+
+// searchPage /search 頁面的資料
+type searchPage struct {
+	Store     string
+	StoreName string
+	// 可切換的店鋪
+	StoreLinks []pageLink
+	// 目前店鋪可選的值，不指定店鋪(搜尋已爬過的商品)時沒有選項
+	Options    StoreParams
+	HasOptions bool
+	// 結果的排序方式
+	SortOptions []ParamOption
+	// 使用者送出的條件，用來回填表單
+	query url.Values
+
+	Searched bool
+	Error    *APIError
+	Warnings int
+	Shoes    []searchPageShoe
+	Total    int
+
+	Page       int
+	TotalPages int
+	PrevURL    string
+	NextURL    string
+	PageLinks  []pageLink
+}
+
+// searchPageShoe 結果表格的一列
+type searchPageShoe struct {
+	Shoe
+	StoreName string
+	Sizes     []searchPageSizeLabel
+}
+
+// searchPageSizeLabel 一個尺碼，Highlight 表示符合查詢的尺碼
+type searchPageSizeLabel struct {
+	Label     string
+	Highlight bool
+}
+
+// pageLink 店鋪切換或頁碼連結
+type pageLink struct {
+	Label   string
+	URL     string
+	Current bool
+}
+
+// 表單欄位是否選了某個值
+func (page searchPage) Selected(field, value string) bool {
+	return containsString(splitMultiValue(page.query[field]), value)
+}
+
+// 表單欄位目前的值
+func (page searchPage) Value(field string) string {
+	return page.query.Get(field)
+}
+
+// searchPageHandler 伺服器端渲染的搜尋頁: /search?store=daf&searchSize=13&page=2，條件與 /filter 相同，不需要 JavaScript
+func (s *Server) searchPageHandler(w http.ResponseWriter, r *http.Request) {
+
+	query := r.URL.Query()
+	store := query.Get("store")
+	// 沒帶店鋪也沒帶關鍵字時，顯示第一家店的表單
+	if (!query.Has("store") && !query.Has("q")) || (store != "" && !s.config.storeEnabled(store)) {
+		store = s.config.EnabledStores[0]
+	}
+
+	page := searchPage{
+		Store:       store,
+		StoreName:   s.storeDisplayName(store),
+		Options:     s.storeParams(store),
+		HasOptions:  store != "",
+		SortOptions: sortOptions,
+		query:       query,
+	}
+	for _, name := range append(append([]string(nil), s.config.EnabledStores...), "") {
+		page.StoreLinks = append(page.StoreLinks, pageLink{
+			Label:   s.storeDisplayName(name),
+			URL:     "/search?store=" + url.QueryEscape(name),
+			Current: name == store,
+		})
+	}
+
+	// 只帶店鋪(或什麼都沒帶)時只顯示表單
+	status := http.StatusOK
+	if hasSearchConditions(query) {
+		page.Searched = true
+		// 錯誤顯示在頁面上，表單保留使用者的條件；太頻繁或太忙時回傳 429、503 讓爬蟲與瀏覽器知道要等
+		if err := s.fillSearchPage(r, &page); err != nil {
+			errorStatus, apiError := classifyError(err)
+			if errorStatus == http.StatusInternalServerError {
+				serverLog.ErrorContext(r.Context(), "內部錯誤", "path", r.URL.Path, "error", err)
+			}
+			page.Error = &apiError
+			if errorStatus == http.StatusTooManyRequests || errorStatus == http.StatusServiceUnavailable {
+				setRetryAfter(w, errorStatus, err)
+				status = errorStatus
+			}
+		}
+	}
+
+	// 先寫進 buffer，模板執行失敗時才能回傳 500 而不是半頁 HTML
+	var body bytes.Buffer
+	if err := s.searchTemplate.Execute(&body, page); err != nil {
+		serverLog.ErrorContext(r.Context(), "搜尋頁模板執行錯誤", "error", err)
+		http.Error(w, "頁面產生失敗", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write(body.Bytes())
+}
+
+// 除了 store、page、sort 以外有帶任何參數才執行搜尋
+func hasSearchConditions(query url.Values) bool {
+	for key := range query {
+		if key != "store" && key != "page" && key != "sort" {
+			return true
+		}
+	}
+	return false
+}
+
+// 執行搜尋並填入目前頁的結果
+func (s *Server) fillSearchPage(r *http.Request, page *searchPage) error {
+
+	request, err := s.parseSearchRequest(page.query)
+	if err != nil {
+		return err
+	}
+	pageRequest, _, err := parsePageRequest(page.query, searchPageSize)
+	if err != nil {
+		return err
+	}
+	// 頁面一律每個商品一列
+	request.Group = groupNone
+
+	job, err := s.jobs.submit(r.Context(), request)
+	if err != nil {
+		return err
+	}
+	select {
+	case <-job.done:
+	case <-r.Context().Done():
+		return r.Context().Err()
+	}
+
+	shoes, warnings, err := job.shoesResult()
+	if err != nil {
+		return err
+	}
+	page.Warnings = len(warnings)
+	s.paginateSearchPage(page, request, shoes, pageRequest)
+	return nil
+}
+
+// 依 sort、page 參數排序並切出目前這頁，並產生上一頁、下一頁與頁碼連結
+func (s *Server) paginateSearchPage(page *searchPage, request SearchRequest, shoes []Shoe, pageRequest PageRequest) {
+
+	// 超過最後一頁時顯示最後一頁
+	lastPage := max(1, (len(shoes)+pageRequest.PageSize-1)/pageRequest.PageSize)
+	pageRequest.Offset = min(pageRequest.Offset, (lastPage-1)*pageRequest.PageSize)
+	rows, info := paginate(sortShoes(shoes, pageRequest.Sort), pageRequest, "")
+	page.Total = info.Total
+	page.TotalPages = info.TotalPages
+	page.Page = info.Page
+
+	// 查詢的尺碼代碼轉成頁面上顯示的 EU 尺碼，用來標示
+	highlight := searchedSizeLabels(request, s.storeParams(request.Params.Store).Sizes)
+
+	for _, shoe := range rows {
+		row := searchPageShoe{Shoe: shoe, StoreName: s.storeDisplayName(shoe.Store)}
+		for _, size := range shoe.Size {
+			row.Sizes = append(row.Sizes, searchPageSizeLabel{Label: size, Highlight: containsString(highlight, size)})
+		}
+		page.Shoes = append(page.Shoes, row)
+	}
+
+	pageURL := func(number int) string {
+		query := url.Values{}
+		for key, values := range page.query {
+			query[key] = values
+		}
+		query.Set("page", strconv.Itoa(number))
+		return "/search?" + query.Encode()
+	}
+	if page.Page > 1 {
+		page.PrevURL = pageURL(page.Page - 1)
+	}
+	if page.Page < page.TotalPages {
+		page.NextURL = pageURL(page.Page + 1)
+	}
+	// 頁碼只列出目前頁前後幾頁，避免頁數多時連結太長
+	for number := max(1, page.Page-3); number <= min(page.TotalPages, page.Page+3); number++ {
+		page.PageLinks = append(page.PageLinks, pageLink{Label: strconv.Itoa(number), URL: pageURL(number), Current: number == page.Page})
+	}
+}
+
+// 查詢的尺碼對應的 EU 尺碼，options 為該店的尺碼選項；不指定店鋪時查詢的本來就是 EU 尺碼
+func searchedSizeLabels(request SearchRequest, options []ParamOption) []string {
+	params := request.Params
+	if params.Store == "" {
+		return params.Sizes
+	}
+	var labels []string
+	for _, option := range options {
+		if containsString(params.Sizes, option.Value) {
+			labels = append(labels, option.Label)
+		}
+	}
+	return labels
+}
+
+// 店鋪在頁面上顯示的名稱，以商店定義爬取的店鋪以定義的 label 為準
+func (s *Server) storeDisplayName(store string) string {
+	if scraper, ok := s.scrapers[store]; ok {
+		return scraper.definition.Label
+	}
+	return builtinStoreDisplayNames[store]
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 以測試用的 D+AF 建立完整的伺服器並啟動搜尋工作
+func newTestAPIServer(t *testing.T, latency time.Duration) (*Server, *dafTestServer) {
+	t.Helper()
+	daf := newDAFTestServer(t, latency)
+	config := defaultConfig("test")
+	config.DAF.BaseURL = daf.URL + "/"
+	server, err := newServer(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.jobs.start()
+	t.Cleanup(func() { server.jobs.shutdown(context.Background()) })
+	return server, daf
+}
+
+// 經過所有 middleware 送出一個請求
+func serveTestRequest(handler http.Handler, method, target string, header http.Header) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, target, nil)
+	for key, values := range header {
+		request.Header[key] = values
+	}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestSearchPage(t *testing.T) {
+	server, daf := newTestAPIServer(t, 0)
+	handler := server.routes()
+
+	// 3 頁各 4 雙，每雙都有 41 號(searchSize=13)，每頁 5 雙共 3 頁
+	recorder := serveTestRequest(handler, http.MethodGet, "/search?store=daf&searchSize=13&pageSize=5&q=%3Cscript%3E", nil)
+	body := recorder.Body.String()
+	if recorder.Code != http.StatusOK || !strings.HasPrefix(recorder.Header().Get("Content-Type"), "text/html") {
+		t.Fatalf("狀態碼 %d、Content-Type %q", recorder.Code, recorder.Header().Get("Content-Type"))
+	}
+	// 關鍵字不符合任何商品，但表單要保留跳脫後的值
+	if !strings.Contains(body, `value="&lt;script&gt;"`) || strings.Contains(body, "<script>") {
+		t.Error("表單應回填跳脫後的關鍵字")
+	}
+	if !strings.Contains(body, "沒有找到符合條件的結果") {
+		t.Error("沒有結果時應顯示提示")
+	}
+
+	recorder = serveTestRequest(handler, http.MethodGet, "/search?store=daf&searchSize=13&pageSize=5", nil)
+	body = recorder.Body.String()
+	for _, want := range []string{
+		"D&#43;AF：共 12 雙",
+		"<td>MIT真皮尖頭瑪莉珍跟鞋</td>",
+		`href="` + daf.URL + `/product/show/31000/2/"`,
+		`40, <span class="size-hit">41</span>, 43`,
+		`<option value="13" selected>`,
+		`href="/search?page=2&amp;pageSize=5&amp;searchSize=13&amp;store=daf">下一頁</a>`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("頁面應包含 %s", want)
+		}
+	}
+	if rows := strings.Count(body, `class="size-hit"`); rows != 5 {
+		t.Errorf("第 1 頁有 %d 雙，應為 5 雙", rows)
+	}
+
+	// 翻頁沿用同一個搜尋工作，不再向商店發請求
+	requests := daf.totalRequests()
+	recorder = serveTestRequest(handler, http.MethodGet, "/search?store=daf&searchSize=13&pageSize=5&page=3", nil)
+	body = recorder.Body.String()
+	if rows := strings.Count(body, `class="size-hit"`); rows != 2 {
+		t.Errorf("第 3 頁有 %d 雙，應為 2 雙", rows)
+	}
+	if !strings.Contains(body, `href="/search?page=2&amp;pageSize=5&amp;searchSize=13&amp;store=daf">上一頁</a>`) {
+		t.Error("最後一頁應有上一頁連結")
+	}
+	if got := daf.totalRequests(); got != requests {
+		t.Errorf("翻頁時向商店發了 %d 個請求", got-requests)
+	}
+
+	// 沒有條件時只顯示表單
+	body = serveTestRequest(handler, http.MethodGet, "/search?store=daf", nil).Body.String()
+	if !strings.Contains(body, `<form method="get" action="/search"`) || strings.Contains(body, "共 ") {
+		t.Error("沒有條件時應只顯示表單")
+	}
+
+	// 參數錯誤顯示在頁面上
+	body = serveTestRequest(handler, http.MethodGet, "/search?store=daf&searchSize=999", nil).Body.String()
+	if !strings.Contains(body, `class="alert alert-danger"`) {
+		t.Error("參數錯誤應顯示在頁面上")
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"html/template"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+)
+
+// Server 持有設定與各店爬蟲，所有處理器都掛在這裡
+type Server struct {
+	config Config
+	// 向商店發請求共用的 HTTP 客戶端
+	client *http.Client
+	daf    *dafCrawler
+	anns   *annsCrawler
+	// 以商店定義爬取的店鋪，daf.engine 為 definition 時也包含 D+AF
+	scrapers map[string]*htmlScraper
+	// 店鋪設定要渲染商品頁時共用的無頭瀏覽器
+	renderer *renderFetcher
+	jobs     *jobManager
+	// 每個客戶端發起新搜尋的頻率限制
+	limiter *rateLimiter
+	// 允許跨域呼叫 API 的來源
+	cors *corsPolicy
+	// 短時間內重複查同一商品時不用再打商店
+	productDetails *productDetailCache
+	// 爬列表時記下的商品資訊，與所有店鋪共用的關鍵字搜尋索引
+	catalog *productCatalog
+	index   *searchIndex
+	// 內嵌的靜態檔案與啟動時解析好的頁面模板
+	assets         *assetServer
+	indexTemplate  *template.Template
+	searchTemplate *template.Template
+	// 收到停止訊號後為 true，/readyz 回傳 503 讓平台不再導流量進來
+	shuttingDown atomic.Bool
+}
+
+// newServer 依設定建立 HTTP 客戶端、爬蟲(包含商店定義的店鋪)與搜尋工作佇列，並解析頁面模板
+func newServer(config Config) (*Server, error) {
+
+	client, err := newHTTPClient(config)
+	if err != nil {
+		return nil, err
+	}
+	assets, err := newAssetServer(embeddedAssets)
+	if err != nil {
+		return nil, err
+	}
+	indexTemplate, err := assets.parseTemplate("templates/index.html")
+	if err != nil {
+		return nil, err
+	}
+	searchTemplate, err := assets.parseTemplate("templates/search.html")
+	if err != nil {
+		return nil, err
+	}
+
+	renderer := newRenderFetcher(config.Render)
+	scrapers, err := newHTMLScrapers(config, client, renderer)
+	if err != nil {
+		return nil, err
+	}
+	catalog := newProductCatalog()
+	server := &Server{
+		config:         config,
+		client:         client,
+		daf:            &dafCrawler{client: client, config: config.DAF, renderer: renderer},
+		anns:           &annsCrawler{client: client, config: config.Anns, renderer: renderer, catalog: catalog},
+		scrapers:       scrapers,
+		renderer:       renderer,
+		limiter:        newRateLimiter(config.RateLimit),
+		cors:           newCORSPolicy(config.CORS),
+		productDetails: newProductDetailCache(config.ProductDetailTTL.Duration),
+		catalog:        catalog,
+		index:          newSearchIndex(catalog),
+		assets:         assets,
+		indexTemplate:  indexTemplate,
+		searchTemplate: searchTemplate,
+	}
+	server.jobs = newJobManager(config.Search, server.limiter, server.runSearch)
+	return server, nil
+}
+
+// routes 註冊所有路由，並在外層套上所有路由共用的 middleware
+func (s *Server) routes() http.Handler {
+
+	mux := http.NewServeMux()
+
+	// 內嵌的靜態檔案，網址與 repo 內的目錄相同
+	mux.HandleFunc("GET /statics/", s.withRequestLog(s.assets.ServeHTTP))
+	mux.HandleFunc("GET /scripts/", s.withRequestLog(s.assets.ServeHTTP))
+	mux.HandleFunc("GET /css/", s.withRequestLog(s.assets.ServeHTTP))
+
+	// 動態生成首頁主頁面，其他找不到的路徑回傳 404
+	mux.HandleFunc("GET /{$}", s.withRequestLog(recoverHandler(s.indexHandler)))
+	// 會發起搜尋的路由帶上客戶端 IP，發起新搜尋時依 IP 限制頻率
+	// 伺服器端渲染的搜尋頁，不需要 JavaScript
+	mux.HandleFunc("GET /search", s.limiter.withClientIP(s.withRequestLog(recoverHandler(s.searchPageHandler))))
+	// 處理器來處理爬女鞋資訊主請求
+	mux.HandleFunc("/filter", s.limiter.withClientIP(s.withRequestLog(recoverHandler(s.filterHandler))))
+	// 單一商品詳細資訊
+	mux.HandleFunc("/products/{store}/{id}", s.withRequestLog(recoverHandler(s.productHandler)))
+	// 依腳型建議尺碼
+	mux.HandleFunc("/sizes/recommend", s.withRequestLog(recoverHandler(s.sizeRecommendHandler)))
+	// 各店查詢參數可接受的值
+	mux.HandleFunc("/params", s.withRequestLog(recoverHandler(s.paramsHandler)))
+	// 非同步搜尋工作
+	mux.HandleFunc("POST /searches", s.limiter.withClientIP(s.withRequestLog(recoverHandler(s.createSearchHandler))))
+	mux.HandleFunc("GET /searches/{id}", s.withRequestLog(recoverHandler(s.searchStatusHandler)))
+	// 其他方法同樣以 JSON 回傳 405，而不是 ServeMux 預設的純文字
+	mux.HandleFunc("/searches", s.withRequestLog(methodNotAllowedHandler(http.MethodPost)))
+	mux.HandleFunc("/searches/{id}", s.withRequestLog(methodNotAllowedHandler(http.MethodGet)))
+	// 存活與就緒檢查，不記 log 以免健康檢查洗版
+	mux.HandleFunc("GET /healthz", recoverHandler(s.healthzHandler))
+	mux.HandleFunc("GET /readyz", recoverHandler(s.readyzHandler))
+	// Prometheus 指標
+	mux.HandleFunc("GET /metrics", recoverHandler(s.metricsHandler))
+
+	// CORS 在路由之前處理，預檢(OPTIONS)請求不會因為路由只接受 GET、POST 而回傳 405
+	return chain(mux.ServeHTTP,
+		withRequestID,
+		withSecurityHeaders(s.config.Security),
+		s.cors.handle,
+		withCompression,
+	)
+}
+
+// run 啟動搜尋工作 worker 並開始監聽，收到 SIGTERM 或 SIGINT 時優雅關閉
+func (s *Server) run() error {
+
+	s.jobs.start()
+	httpServer := &http.Server{Addr: s.config.ListenAddr, Handler: s.routes()}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- httpServer.ListenAndServe()
+	}()
+	serverLog.Info("伺服器啟動", "addr", s.config.ListenAddr, "env", s.config.Environment, "stores", s.config.EnabledStores)
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+	}
+	// 再收到一次訊號就直接結束
+	stop()
+	return s.shutdown(httpServer)
+}
+
+// shutdown 在 shutdownTimeout 內先等進行中的請求回應完，再等搜尋工作完成；逾時則中斷剩下的爬取
+func (s *Server) shutdown(httpServer *http.Server) error {
+
+	s.shuttingDown.Store(true)
+	timeout := s.config.ShutdownTimeout.Duration
+	serverLog.Info("收到停止訊號，開始關閉", "timeout", timeout.String())
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// 關閉監聽並等待進行中的請求，/filter 等待中的請求也包含在內
+	httpErr := httpServer.Shutdown(ctx)
+	if httpErr != nil {
+		serverLog.Warn("等待進行中的請求逾時", "error", httpErr)
+	}
+	// 背景的搜尋工作(POST /searches)可能沒有請求在等，一樣等它完成
+	jobErr := s.jobs.shutdown(ctx)
+	// 搜尋工作都結束(或已取消)後才關閉瀏覽器
+	s.renderer.close()
+
+	if err := errors.Join(httpErr, jobErr); err != nil {
+		return err
+	}
+	serverLog.Info("伺服器已關閉")
+	return nil
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+)
+
+// 尺碼對照表，各店的尺碼與適合的腳長(cm)。調整數據時請一併更新 Version
+type sizeChart struct {
+	Version string
+	Rows    []sizeChartRow
+	// 款式(searchCat)需要額外加大的長度(cm)，例如尖頭鞋、跟鞋前端較窄
+	CategoryAllowance map[string]float64
+}
+
+type sizeChartRow struct {
+	Label      string  // 尺碼(EU)
+	Code       string  // 向商店篩選時帶的值
+	FootLength float64 // 適合的腳長(cm)
+}
+
+var sizeCharts = map[string]sizeChart{
+	"daf": {
+		Version: "daf-2025.02",
+		Rows: []sizeChartRow{
+			{Label: "33", Code: "1385", FootLength: 21.5},
+			{Label: "34", Code: "6", FootLength: 22.0},
+			{Label: "35", Code: "7", FootLength: 22.5},
+			{Label: "36", Code: "8", FootLength: 23.0},
+			{Label: "37", Code: "9", FootLength: 23.5},
+			{Label: "38", Code: "10", FootLength: 24.0},
+			{Label: "39", Code: "11", FootLength: 24.5},
+			{Label: "40", Code: "12", FootLength: 25.0},
+			{Label: "41", Code: "13", FootLength: 25.5},
+			{Label: "42", Code: "14", FootLength: 26.0},
+			{Label: "43", Code: "15", FootLength: 26.5},
+			{Label: "44", Code: "16", FootLength: 27.0},
+		},
+		CategoryAllowance: map[string]float64{
+			"142": 0.5, // 跟鞋
+			"338": 0.3, // 瑪莉珍鞋
+			"199": 0.3, // 長靴、膝下靴
+			"314": 0.3, // 膝上靴、過膝靴
+		},
+	},
+	"anns": {
+		Version: "anns-2025.02",
+		Rows: []sizeChartRow{
+			{Label: "33", Code: "33", FootLength: 21.0},
+			{Label: "34", Code: "34", FootLength: 21.5},
+			{Label: "35", Code: "35", FootLength: 22.0},
+			{Label: "36", Code: "36", FootLength: 22.5},
+			{Label: "37", Code: "37", FootLength: 23.0},
+			{Label: "38", Code: "38", FootLength: 23.5},
+			{Label: "39", Code: "39", FootLength: 24.0},
+			{Label: "40", Code: "40", FootLength: 24.5},
+			{Label: "41", Code: "41", FootLength: 25.0},
+			{Label: "42", Code: "42", FootLength: 25.5},
+			{Label: "43", Code: "43", FootLength: 26.0},
+			{Label: "44", Code: "44", FootLength: 26.5},
+			{Label: "45", Code: "45", FootLength: 27.0},
+		},
+		CategoryAllowance: map[string]float64{
+			"100059": 0.5, // 尖頭鞋
+			"487996": 0.3, // 瑪莉珍鞋
+			"100074": 0.3, // 長靴
+			"407882": 0.3, // 過膝靴
+		},
+	},
+}
+
+const (
+	// 預設可接受的腳長誤差(cm)
+	defaultFitTolerance = 0.5
+	// 腳寬/腳長超過此比例視為寬腳，建議加大
+	wideFootRatio     = 0.41
+	wideFootAllowance = 0.5
+)
+
+// FootProfile 使用者的腳型
+type FootProfile struct {
+	Length    float64 // 腳長(cm)
+	Width     float64 // 腳寬(cm)，0 表示未提供
+	Tolerance float64 // 可接受的誤差(cm)
+}
+
+// SizeRecommendation 某家店(某款式)的尺碼建議
+type SizeRecommendation struct {
+	Store           string            `json:"store"`
+	Category        string            `json:"category,omitempty"`
+	ChartVersion    string            `json:"chartVersion"`
+	FootLength      float64           `json:"footLength"`
+	FootWidth       float64           `json:"footWidth,omitempty"`
+	WideFoot        bool              `json:"wideFoot"`
+	EffectiveLength float64           `json:"effectiveLength"`
+	Tolerance       float64           `json:"tolerance"`
+	Sizes           []RecommendedSize `json:"sizes"`
+}
+
+// RecommendedSize 建議的尺碼，依合腳程度排序
+type RecommendedSize struct {
+	Label      string  `json:"label"`
+	Code       string  `json:"code"`
+	FootLength float64 `json:"footLength"`
+	Diff       float64 `json:"diff"` // 尺碼適合腳長 - 有效腳長，負值偏緊
+	Fit        string  `json:"fit"`  // snug / regular / roomy
+}
+
+// 從 query string 取出腳型，沒有帶 footLength 時回傳 false
+func parseFootProfile(query url.Values) (FootProfile, bool, error) {
+	profile := FootProfile{Tolerance: defaultFitTolerance}
+	if query.Get("footLength") == "" {
+		return profile, false, nil
+	}
+
+	length, err := strconv.ParseFloat(query.Get("footLength"), 64)
+	if err != nil || length < 15 || length > 35 {
+		return profile, false, fmt.Errorf("footLength 應為 15~35 之間的腳長(cm)")
+	}
+	profile.Length = length
+
+	if value := query.Get("footWidth"); value != "" {
+		width, err := strconv.ParseFloat(value, 64)
+		if err != nil || width < 5 || width > 15 {
+			return profile, false, fmt.Errorf("footWidth 應為 5~15 之間的腳寬(cm)")
+		}
+		profile.Width = width
+	}
+
+	if value := query.Get("tolerance"); value != "" {
+		tolerance, err := strconv.ParseFloat(value, 64)
+		if err != nil || tolerance < 0 || tolerance > 2 {
+			return profile, false, fmt.Errorf("tolerance 應為 0~2 之間的誤差(cm)")
+		}
+		profile.Tolerance = tolerance
+	}
+
+	return profile, true, nil
+}
+
+// 依腳型計算某家店(某款式)的建議尺碼
+func recommendSizes(store, category string, profile FootProfile) (SizeRecommendation, error) {
+
+	chart, exists := sizeCharts[store]
+	if !exists {
+		// 商店定義的店鋪沒有尺碼對照表
+		return SizeRecommendation{}, newParamError("footLength", errors.New("此商店不支援腳長建議"))
+	}
+
+	recommendation := SizeRecommendation{
+		Store:        store,
+		Category:     category,
+		ChartVersion: chart.Version,
+		FootLength:   profile.Length,
+		FootWidth:    profile.Width,
+		Tolerance:    profile.Tolerance,
+		Sizes:        []RecommendedSize{},
+	}
+
+	// 有效腳長 = 腳長 + 寬腳加大 + 款式加大
+	effectiveLength := profile.Length
+	if profile.Width > 0 && profile.Width/profile.Length >= wideFootRatio {
+		recommendation.WideFoot = true
+		effectiveLength += wideFootAllowance
+	}
+	effectiveLength += chart.CategoryAllowance[category]
+	recommendation.EffectiveLength = roundCm(effectiveLength)
+
+	for _, row := range chart.Rows {
+		diff := roundCm(row.FootLength - effectiveLength)
+		if math.Abs(diff) > profile.Tolerance {
+			continue
+		}
+		fit := "regular"
+		if diff < -0.15 {
+			fit = "snug"
+		} else if diff > 0.15 {
+			fit = "roomy"
+		}
+		recommendation.Sizes = append(recommendation.Sizes, RecommendedSize{
+			Label:      row.Label,
+			Code:       row.Code,
+			FootLength: row.FootLength,
+			Diff:       diff,
+			Fit:        fit,
+		})
+	}
+
+	// 最合腳的排前面，一樣合腳時偏大的優先(鞋子偏緊比偏鬆難穿)
+	sort.SliceStable(recommendation.Sizes, func(i, j int) bool {
+		di, dj := math.Abs(recommendation.Sizes[i].Diff), math.Abs(recommendation.Sizes[j].Diff)
+		if di != dj {
+			return di < dj
+		}
+		return recommendation.Sizes[i].Diff > recommendation.Sizes[j].Diff
+	})
+
+	return recommendation, nil
+}
+
+// 依腳型算出 /filter 要帶給商店的尺碼篩選值，多個款式時取聯集
+func recommendedSizeCodes(store string, cats []string, profile FootProfile) ([]string, error) {
+	if len(cats) == 0 {
+		cats = []string{""}
+	}
+	var codes []string
+	for _, category := range cats {
+		recommendation, err := recommendSizes(store, category, profile)
+		if err != nil {
+			return nil, err
+		}
+		for _, size := range recommendation.Sizes {
+			codes = append(codes, size.Code)
+		}
+	}
+	return mergeUnique(codes), nil
+}
+
+// 依腳型算出某家店的建議尺碼(EU 尺碼)
+func recommendedSizeLabels(store string, profile FootProfile) []string {
+	recommendation, err := recommendSizes(store, "", profile)
+	if err != nil {
+		return nil
+	}
+	var labels []string
+	for _, size := range recommendation.Sizes {
+		labels = append(labels, size.Label)
+	}
+	return labels
+}
+
+// 四捨五入到小數第二位
+func roundCm(value float64) float64 {
+	return math.Round(value*100) / 100
+}
+
+// sizeRecommendHandler 依腳長、腳寬建議各店尺碼: /sizes/recommend?footLength=25.3&footWidth=10.2[&store=daf][&searchCat=142]
+func (s *Server) sizeRecommendHandler(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w, r, http.MethodGet)
+		return
+	}
+
+	query := r.URL.Query()
+	profile, ok, err := parseFootProfile(query)
+	if err != nil {
+		writeError(w, r, newParamError("footLength", err))
+		return
+	}
+	if !ok {
+		writeError(w, r, newParamError("footLength", errors.New("請提供 footLength 腳長(cm)")))
+		return
+	}
+
+	// 沒指定店鋪時列出所有已啟用店鋪的建議
+	stores := s.config.EnabledStores
+	if store := query.Get("store"); store != "" {
+		if !s.config.storeEnabled(store) {
+			writeError(w, r, errUnknownStore)
+			return
+		}
+		stores = []string{store}
+	}
+	category := query.Get("searchCat")
+
+	recommendations := []SizeRecommendation{}
+	for _, store := range stores {
+		// 商店定義的店鋪沒有尺碼對照表，列出所有店鋪時略過
+		if _, ok := sizeCharts[store]; !ok && query.Get("store") == "" {
+			continue
+		}
+		recommendation, err := recommendSizes(store, category, profile)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		recommendations = append(recommendations, recommendation)
+	}
+	serverLog.DebugContext(r.Context(), "尺碼建議", "footLength", profile.Length, "footWidth", profile.Width, "category", category)
+
+	// 返回 JSON 結果
+	writeData(w, r, http.StatusOK, recommendations, nil)
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+// 尺碼對照表必須依腳長遞增、每列相差 0.5cm，且尺碼與篩選值不重複
+func TestSizeChartsAreOrdered(t *testing.T) {
+	for store, chart := range sizeCharts {
+		labels := map[string]bool{}
+		codes := map[string]bool{}
+		for i, row := range chart.Rows {
+			if labels[row.Label] || codes[row.Code] {
+				t.Errorf("%s 第 %d 列尺碼或篩選值重複: %+v", store, i, row)
+			}
+			labels[row.Label], codes[row.Code] = true, true
+			if i > 0 && roundCm(row.FootLength-chart.Rows[i-1].FootLength) != 0.5 {
+				t.Errorf("%s 第 %d 列腳長應比前一列多 0.5cm: %v -> %v", store, i, chart.Rows[i-1].FootLength, row.FootLength)
+			}
+		}
+	}
+}
+
+func TestRecommendSizes(t *testing.T) {
+	tests := []struct {
+		name     string
+		store    string
+		category string
+		profile  FootProfile
+		want     []string
+		wide     bool
+	}{
+		{
+			name:    "剛好落在尺碼上，兩側在誤差邊界內",
+			store:   "anns",
+			profile: FootProfile{Length: 25.0, Tolerance: 0.5},
+			want:    []string{"41", "42", "40"},
+		},
+		{
+			name:    "一樣合腳時偏大的優先",
+			store:   "anns",
+			profile: FootProfile{Length: 25.25, Tolerance: 0.25},
+			want:    []string{"42", "41"},
+		},
+		{
+			name:    "浮點誤差四捨五入後仍在誤差內",
+			store:   "anns",
+			profile: FootProfile{Length: 25.1, Tolerance: 0.6},
+			want:    []string{"41", "42", "40"},
+		},
+		{
+			name:    "誤差為 0 只取完全相符的尺碼",
+			store:   "daf",
+			profile: FootProfile{Length: 25.5, Tolerance: 0},
+			want:    []string{"41"},
+		},
+		{
+			name:    "對照表最小的尺碼",
+			store:   "anns",
+			profile: FootProfile{Length: 21.0, Tolerance: 0},
+			want:    []string{"33"},
+		},
+		{
+			name:    "對照表最大的尺碼",
+			store:   "daf",
+			profile: FootProfile{Length: 27.0, Tolerance: 0.3},
+			want:    []string{"44"},
+		},
+		{
+			name:    "腳長超出對照表",
+			store:   "daf",
+			profile: FootProfile{Length: 30.0, Tolerance: 0.5},
+			want:    []string{},
+		},
+		{
+			name:    "寬腳加大",
+			store:   "anns",
+			profile: FootProfile{Length: 25.0, Width: 10.3, Tolerance: 0},
+			want:    []string{"42"},
+			wide:    true,
+		},
+		{
+			name:    "腳寬比例未達寬腳",
+			store:   "anns",
+			profile: FootProfile{Length: 25.0, Width: 10.0, Tolerance: 0},
+			want:    []string{"41"},
+		},
+		{
+			name:     "款式加大",
+			store:    "daf",
+			category: "142",
+			profile:  FootProfile{Length: 25.0, Tolerance: 0},
+			want:     []string{"41"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recommendation, err := recommendSizes(test.store, test.category, test.profile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			labels := []string{}
+			for _, size := range recommendation.Sizes {
+				labels = append(labels, size.Label)
+			}
+			if !reflect.DeepEqual(labels, test.want) {
+				t.Errorf("建議尺碼 %v，應為 %v", labels, test.want)
+			}
+			if recommendation.WideFoot != test.wide {
+				t.Errorf("WideFoot = %v，應為 %v", recommendation.WideFoot, test.wide)
+			}
+		})
+	}
+}
+
+func TestRecommendSizesFit(t *testing.T) {
+	recommendation, err := recommendSizes("anns", "", FootProfile{Length: 25.2, Tolerance: 0.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fits := map[string]string{}
+	for _, size := range recommendation.Sizes {
+		fits[size.Label] = size.Fit
+	}
+	want := map[string]string{"41": "snug", "42": "roomy"}
+	if !reflect.DeepEqual(fits, want) {
+		t.Errorf("合腳程度 %v，應為 %v", fits, want)
+	}
+}
+
+// 沒有尺碼對照表的店鋪回傳 footLength 的參數錯誤(400)，不是未知的商店或 500
+func TestRecommendSizesWithoutChart(t *testing.T) {
+	_, err := recommendedSizeCodes("example", nil, FootProfile{Length: 25, Tolerance: 0.5})
+	var paramError *ParamError
+	if !errors.As(err, &paramError) || paramError.Field != "footLength" {
+		t.Fatalf("錯誤 %v，應為 footLength 的參數錯誤", err)
+	}
+	if status, apiError := classifyError(err); status != http.StatusBadRequest || apiError.Message != "此商店不支援腳長建議" {
+		t.Errorf("回應 %d %+v", status, apiError)
+	}
+}
+
+func TestParseFootProfile(t *testing.T) {
+	tests := []struct {
+		query   string
+		ok      bool
+		wantErr bool
+		want    FootProfile
+	}{
+		{query: "", ok: false, want: FootProfile{Tolerance: defaultFitTolerance}},
+		{query: "footLength=15", ok: true, want: FootProfile{Length: 15, Tolerance: defaultFitTolerance}},
+		{query: "footLength=35&footWidth=15&tolerance=2", ok: true, want: FootProfile{Length: 35, Width: 15, Tolerance: 2}},
+		{query: "footLength=24.5&tolerance=0", ok: true, want: FootProfile{Length: 24.5}},
+		{query: "footLength=14.9", wantErr: true},
+		{query: "footLength=35.1", wantErr: true},
+		{query: "footLength=abc", wantErr: true},
+		{query: "footLength=25&footWidth=4", wantErr: true},
+		{query: "footLength=25&tolerance=2.1", wantErr: true},
+		{query: "footLength=25&tolerance=-0.1", wantErr: true},
+	}
+	for _, test := range tests {
+		query, _ := url.ParseQuery(test.query)
+		profile, ok, err := parseFootProfile(query)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%q: 錯誤 %v", test.query, err)
+			continue
+		}
+		if test.wantErr {
+			continue
+		}
+		if ok != test.ok || profile != test.want {
+			t.Errorf("%q: 得到 %+v %v，應為 %+v %v", test.query, profile, ok, test.want, test.ok)
+		}
+	}
+}
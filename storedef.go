@@ -0,0 +1,463 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/cascadia"
+)
+
+// 內建的商店定義，與 daf.go 等手寫的爬蟲並存，作為格式的範例與替代實作
+//
+//go:embed stores
+var embeddedStoreDefinitions embed.FS
+
+// 店鋪的爬取方式
+const (
+	// 手寫的爬蟲(daf.go)
+	storeEngineBuiltin = "builtin"
+	// 商店定義(stores/daf.json)
+	storeEngineDefinition = "definition"
+)
+
+// 商店定義可對應的查詢參數，依這個順序展開篩選組合
+var definitionFilterNames = []string{"orderby", "searchSize", "searchColor", "searchHeel", "searchCat"}
+
+// 商品欄位，id 與 name 必填
+var definitionShoeFields = []string{"id", "name", "price", "image", "url", "familyID"}
+
+var storeCodeRe = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// 網址模板中的 {名稱}
+var urlPlaceholderRe = regexp.MustCompile(`\{([A-Za-z0-9_]+)\}`)
+
+// StoreDefinition 以設定描述一家 HTML 商店: 列表頁網址與分頁、商品欄位的 CSS 選擇器或 JSON 路徑、
+// 商品頁的尺碼顏色與庫存、查詢參數如何帶給商店。由 htmlScraper 解讀，新增這類商店不需要寫程式
+type StoreDefinition struct {
+	// 店鋪代碼，用在 store 參數與結果的 store 欄位
+	Store string `json:"store"`
+	// 頁面上顯示的名稱，預設與店鋪代碼相同
+	Label   string `json:"label"`
+	BaseURL string `json:"baseURL"`
+	// 多選時最多展開幾組上游查詢，與同時請求的列表頁數、商品頁數
+	MaxQueries      int `json:"maxQueries"`
+	ListPageWorkers int `json:"listPageWorkers"`
+	DetailWorkers   int `json:"detailWorkers"`
+	// 商品頁是否改用無頭瀏覽器取得
+	Render StoreRenderConfig `json:"render"`
+	// 是否至少要選一個款式
+	RequiresCategory bool `json:"requiresCategory"`
+	// 以查詢參數名稱(orderby、searchSize、searchColor、searchHeel、searchCat)為 key，沒列出的參數不接受任何值
+	Filters map[string]*FilterDefinition `json:"filters"`
+	List    ListDefinition               `json:"list"`
+	// 沒設定時不訪問商品頁
+	Detail *DetailDefinition `json:"detail"`
+}
+
+// FilterDefinition 一個查詢參數可接受的值，以及如何帶給商店
+type FilterDefinition struct {
+	// 商店的參數名稱，空字串表示只用在列表網址的變數
+	Query   string        `json:"query"`
+	Options []ParamOption `json:"options"`
+	// 商店一次只接受一個值，多選時展開成多組查詢分別爬取；否則所有值帶在同一個查詢
+	Expand bool `json:"expand"`
+	// 表示「不限」的值，選了就不再帶其他值
+	Any string `json:"any"`
+	// 沒選時仍帶上空值
+	KeepEmpty bool `json:"keepEmpty"`
+}
+
+// ListDefinition 列表頁
+type ListDefinition struct {
+	// 網址模板，可用 {baseURL}、{page}、{query}(編碼後的查詢參數)與 variables 中的名稱
+	URL       string                         `json:"url"`
+	Variables map[string]*VariableDefinition `json:"variables"`
+	// 第一頁的頁碼，預設 1
+	FirstPage int `json:"firstPage"`
+	// 從第一頁取出總頁數，沒設定表示只有一頁
+	TotalPages *FieldDefinition `json:"totalPages"`
+	Items      ItemsDefinition  `json:"items"`
+	// 商品欄位: id、name、price、image、url、familyID，相對於每個商品
+	Fields map[string]*FieldDefinition `json:"fields"`
+	// 列表頁其他位置的資料，以 key 對應到商品 id 後補進欄位
+	Joins []*JoinDefinition `json:"joins"`
+}
+
+// VariableDefinition 列表網址中依查詢參數決定的片段，例如靴類要打另一個路徑
+type VariableDefinition struct {
+	Filter string `json:"filter"`
+	// 參數值對應的片段，沒對應到時用 default
+	Values  map[string]string `json:"values"`
+	Default string            `json:"default"`
+}
+
+// ItemsDefinition 列表上的商品: 符合 selector 的每個節點，或頁面內嵌的 JSON 陣列，兩者擇一
+type ItemsDefinition struct {
+	Selector string          `json:"selector"`
+	JSON     *JSONDefinition `json:"json"`
+
+	matcher cascadia.Selector
+}
+
+// JSONDefinition 頁面內嵌的 JSON
+type JSONDefinition struct {
+	// JSON 所在的節點，例如 script#__NEXT_DATA__；沒設定時為整個頁面原始碼
+	Selector string `json:"selector"`
+	// 找出 JSON 開頭的正則表達式，從符合處(有群組時為第一個群組)開始解析一個完整的 JSON 值
+	Regex string `json:"regex"`
+	// 商品陣列在 JSON 中的路徑，以 . 分隔，陣列以數字索引
+	Path string `json:"path"`
+
+	matcher cascadia.Selector
+	regex   *regexp.Regexp
+}
+
+// FieldDefinition 取出一個欄位: HTML 以 selector 找節點後取 attr(沒設定時取文字)，JSON 以 path 取值；
+// 再以 regex 擷取(template 為 $1_$2 這類組合，沒設定時取第一個群組)，absolute 時轉成絕對網址
+type FieldDefinition struct {
+	Selector string `json:"selector"`
+	Attr     string `json:"attr"`
+	Path     string `json:"path"`
+	Regex    string `json:"regex"`
+	Template string `json:"template"`
+	Absolute bool   `json:"absolute"`
+
+	matcher cascadia.Selector
+	regex   *regexp.Regexp
+}
+
+// JoinDefinition 列表頁上符合 selector 的節點，key 等於商品 id 時以 fields 補上欄位
+type JoinDefinition struct {
+	Selector string                      `json:"selector"`
+	Key      *FieldDefinition            `json:"key"`
+	Fields   map[string]*FieldDefinition `json:"fields"`
+
+	matcher cascadia.Selector
+}
+
+// DetailDefinition 商品頁
+type DetailDefinition struct {
+	Sizes  *FieldDefinition `json:"sizes"`
+	Colors *FieldDefinition `json:"colors"`
+	// 找得到時標記售罄
+	SoldOut *FieldDefinition `json:"soldOut"`
+	// 找不到任何尺碼時標記售罄
+	SoldOutWhenNoSizes bool `json:"soldOutWhenNoSizes"`
+	// 商品描述，用來補充標題沒寫到的款式屬性
+	Description *FieldDefinition `json:"description"`
+}
+
+// 內建的商店定義
+func builtinStoreDefinition(store string) (*StoreDefinition, error) {
+	return parseStoreDefinition(embeddedStoreDefinitions, "stores/"+store+".json")
+}
+
+// 讀取目錄中所有 .json 商店定義，以店鋪代碼為 key；不可與內建店鋪或彼此同名
+func loadStoreDefinitions(dir string) (map[string]*StoreDefinition, error) {
+
+	definitions := map[string]*StoreDefinition{}
+	if dir == "" {
+		return definitions, nil
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("無法讀取商店定義目錄: %w", err)
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("商店定義目錄 %s 沒有 .json 檔案", dir)
+	}
+
+	var errs []error
+	for _, path := range paths {
+		definition, err := parseStoreDefinition(os.DirFS(dir), filepath.Base(path))
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		switch {
+		case containsString(knownStores, definition.Store):
+			errs = append(errs, fmt.Errorf("商店定義 %s: store %q 與內建店鋪同名", path, definition.Store))
+		case definitions[definition.Store] != nil:
+			errs = append(errs, fmt.Errorf("商店定義 %s: store %q 重複", path, definition.Store))
+		default:
+			definitions[definition.Store] = definition
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	serverLog.Info("已讀取商店定義", "dir", dir, "stores", sortedKeys(definitions))
+	return definitions, nil
+}
+
+func parseStoreDefinition(fsys fs.FS, name string) (*StoreDefinition, error) {
+
+	file, err := fsys.Open(name)
+	if err != nil {
+		return nil, fmt.Errorf("無法讀取商店定義: %w", err)
+	}
+	defer file.Close()
+
+	definition := &StoreDefinition{}
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(definition); err != nil {
+		return nil, fmt.Errorf("商店定義 %s 格式錯誤: %w", name, err)
+	}
+	definition.applyDefaults()
+	if err := definition.compile(); err != nil {
+		return nil, fmt.Errorf("商店定義 %s 錯誤: %w", name, err)
+	}
+	return definition, nil
+}
+
+// 沒寫的欄位採用與 D+AF 相同的預設值
+func (definition *StoreDefinition) applyDefaults() {
+	if definition.Label == "" {
+		definition.Label = definition.Store
+	}
+	if definition.MaxQueries == 0 {
+		definition.MaxQueries = 20
+	}
+	if definition.ListPageWorkers == 0 {
+		definition.ListPageWorkers = 4
+	}
+	if definition.DetailWorkers == 0 {
+		definition.DetailWorkers = 8
+	}
+	if definition.Render.Detail == "" {
+		definition.Render.Detail = renderOff
+	}
+	if definition.List.FirstPage == 0 {
+		definition.List.FirstPage = 1
+	}
+}
+
+// 檢查定義並編譯選擇器與正則表達式，一次列出所有錯誤
+func (definition *StoreDefinition) compile() error {
+
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+	// JSON 中寫成 null 的項目解析後是 nil，要明確拒絕，否則爬取時才 panic
+	notNull := func(ok bool, name string) bool {
+		check(ok, "%s 不可為 null", name)
+		return ok
+	}
+	compileField := func(name string, field *FieldDefinition, isJSON bool) {
+		if !notNull(field != nil, name) {
+			return
+		}
+		if err := field.compile(isJSON); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+
+	check(storeCodeRe.MatchString(definition.Store), "store 應為小寫英數字與 -: %q", definition.Store)
+	check(validBaseURL(definition.BaseURL), "baseURL 應為 http(s) 網址: %q", definition.BaseURL)
+	check(definition.MaxQueries > 0, "maxQueries 必須大於 0")
+	check(definition.ListPageWorkers > 0, "listPageWorkers 必須大於 0")
+	check(definition.DetailWorkers > 0, "detailWorkers 必須大於 0")
+	if err := validateRenderMode("render.detail", definition.Render.Detail); err != nil {
+		errs = append(errs, err)
+	}
+
+	for name, filter := range definition.Filters {
+		notNull(filter != nil, "filters."+name)
+		check(containsString(definitionFilterNames, name), "filters 不支援 %q，可用的值: %s", name, strings.Join(definitionFilterNames, "、"))
+	}
+	check(!definition.RequiresCategory || definition.Filters["searchCat"] != nil, "requiresCategory 需要設定 filters.searchCat")
+
+	list := &definition.List
+	check(list.URL != "", "list.url 不可為空")
+	for _, match := range urlPlaceholderRe.FindAllStringSubmatch(list.URL, -1) {
+		name := match[1]
+		check(name == "baseURL" || name == "page" || name == "query" || list.Variables[name] != nil,
+			"list.url 的 {%s} 不是 baseURL、page、query 或 list.variables 中的名稱", name)
+	}
+	check(strings.Contains(list.URL, "{page}") || list.TotalPages == nil, "list.url 需要 {page} 才能取得第 2 頁之後")
+	for name, variable := range list.Variables {
+		if !notNull(variable != nil, "list.variables."+name) {
+			continue
+		}
+		check(definition.Filters[variable.Filter] != nil, "list.variables.%s.filter 不是 filters 中的參數: %q", name, variable.Filter)
+	}
+	if list.TotalPages != nil {
+		compileField("list.totalPages", list.TotalPages, false)
+	}
+
+	items := &list.Items
+	isJSON := items.JSON != nil
+	check((items.Selector == "") == isJSON, "list.items 的 selector 與 json 須擇一設定")
+	if items.Selector != "" {
+		matcher, err := cascadia.Compile(items.Selector)
+		check(err == nil, "list.items.selector: %v", err)
+		items.matcher = matcher
+	}
+	if isJSON {
+		if err := items.JSON.compile(); err != nil {
+			errs = append(errs, fmt.Errorf("list.items.json: %w", err))
+		}
+	}
+
+	check(list.Fields["id"] != nil && list.Fields["name"] != nil, "list.fields 必須有 id 與 name")
+	for name, field := range list.Fields {
+		check(containsString(definitionShoeFields, name), "list.fields 不支援 %q，可用的值: %s", name, strings.Join(definitionShoeFields, "、"))
+		compileField("list.fields."+name, field, isJSON)
+	}
+
+	for i, join := range list.Joins {
+		prefix := "list.joins[" + strconv.Itoa(i) + "]"
+		if !notNull(join != nil, prefix) {
+			continue
+		}
+		matcher, err := cascadia.Compile(join.Selector)
+		check(err == nil, "%s.selector: %v", prefix, err)
+		join.matcher = matcher
+		check(join.Key != nil, "%s.key 不可為空", prefix)
+		if join.Key != nil {
+			compileField(prefix+".key", join.Key, false)
+		}
+		check(len(join.Fields) > 0, "%s.fields 不可為空", prefix)
+		for name, field := range join.Fields {
+			check(containsString(definitionShoeFields, name) && name != "id", "%s.fields 不支援 %q", prefix, name)
+			compileField(prefix+".fields."+name, field, false)
+		}
+	}
+
+	if detail := definition.Detail; detail != nil {
+		check(list.Fields["url"] != nil || definition.joinsField("url"), "有 detail 時須能從列表取得 url")
+		for name, field := range map[string]*FieldDefinition{
+			"sizes": detail.Sizes, "colors": detail.Colors, "soldOut": detail.SoldOut, "description": detail.Description,
+		} {
+			if field != nil {
+				compileField("detail."+name, field, false)
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// 是否有 join 會補上指定的欄位
+func (definition *StoreDefinition) joinsField(name string) bool {
+	for _, join := range definition.List.Joins {
+		if join != nil && join.Fields[name] != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (source *JSONDefinition) compile() error {
+	var errs []error
+	if source.Selector != "" {
+		matcher, err := cascadia.Compile(source.Selector)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("selector: %w", err))
+		}
+		source.matcher = matcher
+	}
+	if source.Regex != "" {
+		regex, err := regexp.Compile(source.Regex)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("regex: %w", err))
+		}
+		source.regex = regex
+	}
+	return errors.Join(errs...)
+}
+
+// JSON 的欄位以 path 取值，HTML 的欄位以 selector 與 attr 取值
+func (field *FieldDefinition) compile(isJSON bool) error {
+	var errs []error
+	if isJSON {
+		if field.Selector != "" || field.Attr != "" {
+			errs = append(errs, errors.New("JSON 商品的欄位只能用 path"))
+		}
+	} else if field.Path != "" {
+		errs = append(errs, errors.New("HTML 節點的欄位不能用 path"))
+	}
+	if field.Selector != "" {
+		matcher, err := cascadia.Compile(field.Selector)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("selector: %w", err))
+		}
+		field.matcher = matcher
+	}
+	if field.Regex != "" {
+		regex, err := regexp.Compile(field.Regex)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("regex: %w", err))
+		}
+		field.regex = regex
+	}
+	if field.Template != "" && field.Regex == "" {
+		errs = append(errs, errors.New("template 需要搭配 regex"))
+	}
+	return errors.Join(errs...)
+}
+
+// 商店定義的查詢參數可接受的值，供 /params 與參數檢查使用
+func (definition *StoreDefinition) storeParams() StoreParams {
+	params := StoreParams{Store: definition.Store, RequiresCategory: definition.RequiresCategory}
+	options := func(name string) []ParamOption {
+		if filter := definition.Filters[name]; filter != nil {
+			return filter.Options
+		}
+		return nil
+	}
+	params.OrderBy = options("orderby")
+	params.Sizes = options("searchSize")
+	params.Colors = options("searchColor")
+	params.Heels = options("searchHeel")
+	params.Cats = options("searchCat")
+	return params
+}
+
+// 依 . 分隔的路徑取出 JSON 中的值，陣列以數字索引；空路徑為值本身
+func lookupJSONPath(value interface{}, path string) (interface{}, bool) {
+	if path == "" {
+		return value, true
+	}
+	for _, key := range strings.Split(path, ".") {
+		switch node := value.(type) {
+		case map[string]interface{}:
+			next, ok := node[key]
+			if !ok {
+				return nil, false
+			}
+			value = next
+		case []interface{}:
+			index, err := strconv.Atoi(key)
+			if err != nil || index < 0 || index >= len(node) {
+				return nil, false
+			}
+			value = node[index]
+		default:
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+func sortedKeys[V any](values map[string]V) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
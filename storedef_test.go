@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// 合法的最小商店定義，各測試從這份修改
+func testStoreDefinition() map[string]interface{} {
+	return map[string]interface{}{
+		"store":   "shoebox",
+		"baseURL": "https://shoebox.example/",
+		"filters": map[string]interface{}{
+			"searchSize": map[string]interface{}{"query": "size", "options": []interface{}{map[string]interface{}{"value": "41", "label": "41"}}},
+			"searchCat":  map[string]interface{}{"query": "", "options": []interface{}{map[string]interface{}{"value": "boots", "label": "靴"}}},
+		},
+		"list": map[string]interface{}{
+			"url":        "{baseURL}{path}?page={page}&{query}",
+			"variables":  map[string]interface{}{"path": map[string]interface{}{"filter": "searchCat", "values": map[string]interface{}{"boots": "boots"}, "default": "shoes"}},
+			"totalPages": map[string]interface{}{"selector": ".pager .last"},
+			"items":      map[string]interface{}{"selector": ".product"},
+			"fields": map[string]interface{}{
+				"id":   map[string]interface{}{"selector": "a", "attr": "href", "regex": `/p/(\d+)`},
+				"name": map[string]interface{}{"selector": ".name"},
+				"url":  map[string]interface{}{"selector": "a", "attr": "href", "absolute": true},
+			},
+			"joins": []interface{}{map[string]interface{}{
+				"selector": ".price-list li",
+				"key":      map[string]interface{}{"attr": "data-id"},
+				"fields":   map[string]interface{}{"price": map[string]interface{}{"selector": ".price"}},
+			}},
+		},
+		"detail": map[string]interface{}{"sizes": map[string]interface{}{"selector": ".size"}},
+	}
+}
+
+func storeDefinitionJSON(t *testing.T, definition map[string]interface{}) []byte {
+	t.Helper()
+	data, err := json.Marshal(definition)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestParseStoreDefinition(t *testing.T) {
+	list := func(definition map[string]interface{}) map[string]interface{} {
+		return definition["list"].(map[string]interface{})
+	}
+	fields := func(definition map[string]interface{}) map[string]interface{} {
+		return list(definition)["fields"].(map[string]interface{})
+	}
+	tests := []struct {
+		name   string
+		modify func(definition map[string]interface{})
+		raw    string
+		// 錯誤訊息應包含的片段，空的表示應成功
+		wantErrs []string
+	}{
+		{name: "合法", modify: func(map[string]interface{}) {}},
+		{name: "JSON 格式錯誤", raw: `{"store": `, wantErrs: []string{"格式錯誤"}},
+		{name: "未知的欄位", raw: `{"store": "shoebox", "colour": "red"}`, wantErrs: []string{"格式錯誤", "colour"}},
+		{name: "店鋪代碼", modify: func(d map[string]interface{}) { d["store"] = "Shoe Box" }, wantErrs: []string{"store 應為小寫英數字與 -"}},
+		{name: "baseURL", modify: func(d map[string]interface{}) { d["baseURL"] = "ftp://shoebox.example/" }, wantErrs: []string{"baseURL 應為 http(s) 網址"}},
+		{name: "maxQueries", modify: func(d map[string]interface{}) { d["maxQueries"] = -1 }, wantErrs: []string{"maxQueries 必須大於 0"}},
+		{name: "listPageWorkers", modify: func(d map[string]interface{}) { d["listPageWorkers"] = -1 }, wantErrs: []string{"listPageWorkers 必須大於 0"}},
+		{name: "detailWorkers", modify: func(d map[string]interface{}) { d["detailWorkers"] = -1 }, wantErrs: []string{"detailWorkers 必須大於 0"}},
+		{name: "render", modify: func(d map[string]interface{}) { d["render"] = map[string]interface{}{"detail": "sometimes"} }, wantErrs: []string{"render.detail 應為"}},
+		{name: "不支援的參數", modify: func(d map[string]interface{}) {
+			d["filters"].(map[string]interface{})["searchBrand"] = map[string]interface{}{"query": "brand"}
+		}, wantErrs: []string{`filters 不支援 "searchBrand"`}},
+		{name: "requiresCategory 沒有款式", modify: func(d map[string]interface{}) {
+			d["requiresCategory"] = true
+			delete(d["filters"].(map[string]interface{}), "searchCat")
+			delete(list(d), "variables")
+			list(d)["url"] = "{baseURL}?page={page}"
+		}, wantErrs: []string{"requiresCategory 需要設定 filters.searchCat"}},
+		{name: "網址為空", modify: func(d map[string]interface{}) { list(d)["url"] = "" }, wantErrs: []string{"list.url 不可為空"}},
+		{name: "未知的網址變數", modify: func(d map[string]interface{}) { list(d)["url"] = "{baseURL}{gender}?page={page}" }, wantErrs: []string{"list.url 的 {gender}"}},
+		{name: "分頁沒有 {page}", modify: func(d map[string]interface{}) { list(d)["url"] = "{baseURL}{path}" }, wantErrs: []string{"list.url 需要 {page}"}},
+		{name: "變數的參數", modify: func(d map[string]interface{}) {
+			list(d)["variables"] = map[string]interface{}{"path": map[string]interface{}{"filter": "searchHeel"}}
+		}, wantErrs: []string{`list.variables.path.filter 不是 filters 中的參數: "searchHeel"`}},
+		{name: "selector 與 json 都設定", modify: func(d map[string]interface{}) {
+			list(d)["items"] = map[string]interface{}{"selector": ".product", "json": map[string]interface{}{"path": "items"}}
+		}, wantErrs: []string{"list.items 的 selector 與 json 須擇一設定"}},
+		{name: "selector 與 json 都沒設定", modify: func(d map[string]interface{}) { list(d)["items"] = map[string]interface{}{} }, wantErrs: []string{"list.items 的 selector 與 json 須擇一設定"}},
+		{name: "items 選擇器", modify: func(d map[string]interface{}) { list(d)["items"] = map[string]interface{}{"selector": "div["} }, wantErrs: []string{"list.items.selector"}},
+		{name: "items JSON 正則", modify: func(d map[string]interface{}) {
+			list(d)["items"] = map[string]interface{}{"json": map[string]interface{}{"regex": "(", "path": "items"}}
+			list(d)["fields"] = map[string]interface{}{"id": map[string]interface{}{"path": "id"}, "name": map[string]interface{}{"path": "name"}}
+			list(d)["joins"] = []interface{}{}
+			delete(d, "detail")
+		}, wantErrs: []string{"list.items.json: regex"}},
+		{name: "缺少 id", modify: func(d map[string]interface{}) { delete(fields(d), "id") }, wantErrs: []string{"list.fields 必須有 id 與 name"}},
+		{name: "不支援的欄位", modify: func(d map[string]interface{}) { fields(d)["brand"] = map[string]interface{}{"selector": ".brand"} }, wantErrs: []string{`list.fields 不支援 "brand"`}},
+		{name: "HTML 欄位用 path", modify: func(d map[string]interface{}) { fields(d)["name"] = map[string]interface{}{"path": "name"} }, wantErrs: []string{"list.fields.name: HTML 節點的欄位不能用 path"}},
+		{name: "JSON 欄位用 selector", modify: func(d map[string]interface{}) {
+			list(d)["items"] = map[string]interface{}{"json": map[string]interface{}{"path": "items"}}
+			list(d)["fields"] = map[string]interface{}{"id": map[string]interface{}{"path": "id"}, "name": map[string]interface{}{"selector": ".name"}}
+			list(d)["joins"] = []interface{}{}
+			delete(d, "detail")
+		}, wantErrs: []string{"list.fields.name: JSON 商品的欄位只能用 path"}},
+		{name: "欄位正則", modify: func(d map[string]interface{}) {
+			fields(d)["id"] = map[string]interface{}{"selector": "a", "regex": "(\\d+"}
+		}, wantErrs: []string{"list.fields.id: regex"}},
+		{name: "template 沒有 regex", modify: func(d map[string]interface{}) {
+			fields(d)["id"] = map[string]interface{}{"selector": "a", "template": "$1"}
+		}, wantErrs: []string{"list.fields.id: template 需要搭配 regex"}},
+		{name: "join 沒有 key 與 fields", modify: func(d map[string]interface{}) {
+			list(d)["joins"] = []interface{}{map[string]interface{}{"selector": ".price-list li"}}
+		}, wantErrs: []string{"list.joins[0].key 不可為空", "list.joins[0].fields 不可為空"}},
+		{name: "join 補上 id", modify: func(d map[string]interface{}) {
+			list(d)["joins"] = []interface{}{map[string]interface{}{"selector": "li", "key": map[string]interface{}{}, "fields": map[string]interface{}{"id": map[string]interface{}{}}}}
+		}, wantErrs: []string{`list.joins[0].fields 不支援 "id"`}},
+		{name: "detail 沒有 url", modify: func(d map[string]interface{}) { delete(fields(d), "url") }, wantErrs: []string{"有 detail 時須能從列表取得 url"}},
+		{name: "url 由 join 補上", modify: func(d map[string]interface{}) {
+			delete(fields(d), "url")
+			list(d)["joins"] = []interface{}{map[string]interface{}{"selector": "li", "key": map[string]interface{}{}, "fields": map[string]interface{}{"url": map[string]interface{}{"attr": "href"}}}}
+		}},
+		{name: "detail 欄位", modify: func(d map[string]interface{}) {
+			d["detail"] = map[string]interface{}{"soldOut": map[string]interface{}{"selector": "p:nth-child("}}
+		}, wantErrs: []string{"detail.soldOut: selector"}},
+		{name: "null 欄位", modify: func(d map[string]interface{}) { fields(d)["price"] = nil }, wantErrs: []string{"list.fields.price 不可為 null"}},
+		{name: "null 參數", modify: func(d map[string]interface{}) { d["filters"].(map[string]interface{})["searchColor"] = nil }, wantErrs: []string{"filters.searchColor 不可為 null"}},
+		{name: "null 網址變數", modify: func(d map[string]interface{}) {
+			list(d)["variables"].(map[string]interface{})["x"] = nil
+		}, wantErrs: []string{"list.variables.x 不可為 null"}},
+		{name: "null join", modify: func(d map[string]interface{}) { list(d)["joins"] = []interface{}{nil} }, wantErrs: []string{"list.joins[0] 不可為 null"}},
+		{name: "null join 欄位", modify: func(d map[string]interface{}) {
+			list(d)["joins"].([]interface{})[0].(map[string]interface{})["fields"] = map[string]interface{}{"price": nil}
+		}, wantErrs: []string{"list.joins[0].fields.price 不可為 null"}},
+		{name: "多個錯誤一次列出", modify: func(d map[string]interface{}) {
+			d["store"] = ""
+			d["baseURL"] = ""
+			list(d)["joins"] = []interface{}{nil}
+		}, wantErrs: []string{"store 應為", "baseURL 應為", "list.joins[0] 不可為 null"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data := []byte(test.raw)
+			if test.modify != nil {
+				definition := testStoreDefinition()
+				test.modify(definition)
+				data = storeDefinitionJSON(t, definition)
+			}
+			parsed, err := parseStoreDefinition(fstest.MapFS{"shoebox.json": {Data: data}}, "shoebox.json")
+			if len(test.wantErrs) == 0 {
+				if err != nil {
+					t.Fatalf("應為合法的商店定義: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("應回傳錯誤，得到 %+v", parsed)
+			}
+			for _, want := range test.wantErrs {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("錯誤訊息應包含 %q，得到 %v", want, err)
+				}
+			}
+		})
+	}
+}
+
+func TestParseStoreDefinitionDefaults(t *testing.T) {
+	definition, err := parseStoreDefinition(fstest.MapFS{"shoebox.json": {Data: storeDefinitionJSON(t, testStoreDefinition())}}, "shoebox.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if definition.Label != "shoebox" || definition.MaxQueries != 20 || definition.ListPageWorkers != 4 ||
+		definition.DetailWorkers != 8 || definition.Render.Detail != renderOff || definition.List.FirstPage != 1 {
+		t.Errorf("預設值不符: %+v", definition)
+	}
+	params := definition.storeParams()
+	if params.Store != "shoebox" || len(params.Sizes) != 1 || len(params.Cats) != 1 || params.Colors != nil {
+		t.Errorf("查詢參數不符: %+v", params)
+	}
+}
+
+func TestBuiltinStoreDefinition(t *testing.T) {
+	definition, err := builtinStoreDefinition("daf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if definition.Store != "daf" || definition.Detail == nil {
+		t.Errorf("內建的 D+AF 商店定義不符: %+v", definition)
+	}
+}
+
+func TestLoadStoreDefinitions(t *testing.T) {
+	named := func(store string) []byte {
+		definition := testStoreDefinition()
+		definition["store"] = store
+		return storeDefinitionJSON(t, definition)
+	}
+	tests := []struct {
+		name       string
+		files      map[string][]byte
+		wantStores []string
+		wantErrs   []string
+	}{
+		{name: "多家店", files: map[string][]byte{"a.json": named("shoebox"), "b.json": named("big-feet"), "notes.txt": []byte("略過")}, wantStores: []string{"big-feet", "shoebox"}},
+		{name: "沒有 .json", files: map[string][]byte{"notes.txt": []byte("略過")}, wantErrs: []string{"沒有 .json 檔案"}},
+		{name: "重複的店鋪", files: map[string][]byte{"a.json": named("shoebox"), "b.json": named("shoebox")}, wantErrs: []string{`b.json: store "shoebox" 重複`}},
+		{name: "與內建店鋪同名", files: map[string][]byte{"a.json": named("daf"), "b.json": named("anns")}, wantErrs: []string{`a.json: store "daf" 與內建店鋪同名`, `b.json: store "anns" 與內建店鋪同名`}},
+		{name: "每個檔案的錯誤都列出", files: map[string][]byte{"a.json": []byte("{"), "b.json": named("Bad Store")}, wantErrs: []string{"商店定義 a.json 格式錯誤", "商店定義 b.json 錯誤"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, data := range test.files {
+				if err := os.WriteFile(filepath.Join(dir, name), data, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			definitions, err := loadStoreDefinitions(dir)
+			if len(test.wantErrs) > 0 {
+				if err == nil {
+					t.Fatalf("應回傳錯誤，得到 %v", sortedKeys(definitions))
+				}
+				for _, want := range test.wantErrs {
+					if !strings.Contains(err.Error(), want) {
+						t.Errorf("錯誤訊息應包含 %q，得到 %v", want, err)
+					}
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.Join(sortedKeys(definitions), ","); got != strings.Join(test.wantStores, ",") {
+				t.Errorf("讀到的店鋪 %s，應為 %v", got, test.wantStores)
+			}
+		})
+	}
+
+	if definitions, err := loadStoreDefinitions(""); err != nil || len(definitions) != 0 {
+		t.Errorf("沒有設定目錄時應為空，得到 %v %v", definitions, err)
+	}
+}